@@ -0,0 +1,85 @@
+package main
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// newBlockchain 指定了node的话从它导出的账本快照快速启动，再把快照之后的区块逐个校验着接上来，否则从创世区块开始
+// 快照的高度和那个高度的区块hash由checkpoint("高度:hash")指定
+func newBlockchain(params blockchain.ChainParams, engine blockchain.ConsensusEngine, node, checkpoint string) (*blockchain.Blockchain, error) {
+	if node == "" {
+		return blockchain.NewBlockchainWithEngine(params, engine)
+	}
+
+	//区块头只按固定的难度校验工作量，node可以伪造一串区块头和任意的状态承诺，
+	//所以快照要对上一个从别的可信来源拿到的checkpoint，不能只凭node说了算
+	trusted, err := parseCheckpoint(checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	params.Checkpoints = append(append([]blockchain.Checkpoint{}, params.Checkpoints...), trusted)
+	var snapshot blockchain.StateSnapshot
+	found, err := get(fmt.Sprintf("%s/snapshot/?height=%d", node, trusted.Height), &snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch snapshot %w", err)
+	}
+	if !found {
+		return nil, fmt.Errorf("%s has no snapshot yet", node)
+	}
+	chain, err := blockchain.NewBlockchainFromSnapshot(params, engine, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("bootstrapped from snapshot at height %d, state root: %s", snapshot.Height, snapshot.Root)
+
+	for height := snapshot.Height + 1; ; height++ {
+		var info blockchain.BlockInfo
+		found, err := get(fmt.Sprintf("%s/block/?height=%d", node, height), &info)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			break
+		}
+		if err := chain.ProcessBlock(blockchain.NewBlockFromInfo(info)); err != nil {
+			return nil, fmt.Errorf("could not process block %d %w", height, err)
+		}
+	}
+	log.Printf("synced to height %d", chain.Height())
+	return chain, nil
+}
+
+// parseCheckpoint 解析"高度:hash"格式的checkpoint
+func parseCheckpoint(checkpoint string) (blockchain.Checkpoint, error) {
+	heightText, hash, ok := strings.Cut(checkpoint, ":")
+	height, err := strconv.Atoi(heightText)
+	if !ok || err != nil || height <= 0 || hash == "" {
+		return blockchain.Checkpoint{}, fmt.Errorf("snapshot checkpoint must be <height>:<block hash> from a source you trust, got %q", checkpoint)
+	}
+	return blockchain.Checkpoint{Height: height, Hash: hash}, nil
+}
+
+// get 请求url并把返回的JSON解到result里，404的话返回false
+func get(url string, result interface{}) (bool, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		var message bytes.Buffer
+		message.ReadFrom(resp.Body)
+		return false, fmt.Errorf("%s: %s %s", url, resp.Status, strings.TrimSpace(message.String()))
+	}
+	return true, json.NewDecoder(resp.Body).Decode(result)
+}
@@ -3,17 +3,104 @@ package main
 import (
 	"CcCoin-go-version/internal/blockchain"
 	"CcCoin-go-version/internal/server"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 )
 
 func main() {
-	difficulty := 3
+	network := flag.String("network", "mainnet", "要连接的网络: mainnet, testnet, regtest")
+	genesisPath := flag.String("genesis", "", "创世配置文件(json)，不指定的话使用网络默认的创世区块")
+	minerAddress := flag.String("miner-address", "", "指定的话启动后台矿工，挖矿奖励发给这个地址")
+	consensus := flag.String("consensus", "", "共识引擎: pow, poa, pos，不指定的话使用网络默认的")
+	powAlgorithm := flag.String("pow-algorithm", "", "工作量证明的hash算法: sha256, scrypt，不指定的话使用网络默认的")
+	signerAddress := flag.String("signer-address", "", "权威证明或权益证明下本节点用来出块的公钥")
+	signerKey := flag.String("signer-key", "", "权威证明或权益证明下本节点用来对区块签名的私钥")
+	stratumAddr := flag.String("stratum-addr", "", "指定的话在这个地址上启动矿池协议服务，例如 :3333")
+	poolAddress := flag.String("pool-address", "", "矿池挖到的区块奖励发给这个地址，启动矿池协议服务时必须指定")
+	shareDifficulty := flag.Int("share-difficulty", 0, "矿池份额的难度，不指定的话只接受能出块的份额")
+	miningWorkers := flag.Int("mining-workers", blockchain.DefaultMiningWorkers(), "挖矿时并行的goroutine数")
+	nonceRange := flag.Uint64("nonce-range", 0, "每个extra nonce下最多尝试多少个nonce，试完了就换extra nonce并更新时间戳，0表示整个4字节的nonce空间")
+	pruneDepth := flag.Int("prune", 0, "只保留最近多少个区块的交易，更早的区块只留下区块头，0表示不裁剪")
+	bootstrapNode := flag.String("bootstrap", "", "从这个节点(例如 http://localhost:5000)导出的账本快照快速启动，不用重放整条链")
+	snapshotCheckpoint := flag.String("snapshot-checkpoint", "", "用-bootstrap启动时必须指定，格式是 高度:区块hash，从可信的来源拿到，快照就导出这个高度的，区块hash必须一致")
+	flag.Parse()
+
+	params, err := blockchain.ParamsForNetwork(*network)
+	if err != nil {
+		log.Fatalf("could not select network %v", err)
+	}
+	if *genesisPath != "" {
+		params.Genesis, err = blockchain.LoadGenesisConfig(*genesisPath)
+		if err != nil {
+			log.Fatalf("could not load genesis config %v", err)
+		}
+		//自定义的创世区块和网络自带的checkpoint对不上
+		params.Checkpoints = nil
+	}
+	if *consensus != "" {
+		params.Consensus = *consensus
+	}
+	if *powAlgorithm != "" && *powAlgorithm != params.PowAlgorithm {
+		params.PowAlgorithm = *powAlgorithm
+		//创世hash承诺了工作量证明的算法，换了算法就是另一条链
+		params.Checkpoints = nil
+	}
+
+	engine, err := blockchain.NewConsensusEngine(params)
+	if err != nil {
+		log.Fatalf("could not create consensus engine %v", err)
+	}
+	if *signerAddress != "" {
+		//权威证明和权益证明都是靠签名出块的
+		signer, ok := engine.(interface {
+			Authorize(address, privateKey string) error
+		})
+		if !ok {
+			log.Fatalf("-signer-address requires the poa or pos consensus engine")
+		}
+		if err := signer.Authorize(*signerAddress, *signerKey); err != nil {
+			log.Fatalf("could not authorize signer %v", err)
+		}
+	}
+
+	//配置里写了期望的创世hash的话，这里会校验，不一致就拒绝启动
+	blockchain, err := newBlockchain(params, engine, *bootstrapNode, *snapshotCheckpoint)
+	if err != nil {
+		log.Fatalf("could not create blockchain %v", err)
+	}
+	blockchain.SetMiningWorkers(*miningWorkers)
+	blockchain.SetNonceRange(*nonceRange)
+	if err := blockchain.SetPruneDepth(*pruneDepth); err != nil {
+		log.Fatalf("could not enable pruning %v", err)
+	}
+	log.Printf("network: %s, genesis hash: %s", params.Name, blockchain.GenesisHash())
+
+	if *stratumAddr != "" {
+		stratum, err := server.NewStratumServer(blockchain, *poolAddress)
+		if err != nil {
+			log.Fatalf("could not create stratum server %v", err)
+		}
+		stratum.SetShareDifficulty(*shareDifficulty)
+		go func() {
+			if err := stratum.ListenAndServe(*stratumAddr); err != nil {
+				log.Fatalf("could not listen on %s %v", *stratumAddr, err)
+			}
+		}()
+		log.Printf("stratum server started on %s, pool address: %s", *stratumAddr, *poolAddress)
+	}
 
-	blockchain := blockchain.NewBlockchain(difficulty)
 	server := server.NewBlockchainServer(blockchain)
+	if *minerAddress != "" {
+		if err := server.Miner().Start(*minerAddress); err != nil {
+			log.Fatalf("could not start background miner %v", err)
+		}
+		log.Printf("background miner started, reward address: %s", *minerAddress)
+	}
 
-	if err := http.ListenAndServe(":5000", server); err != nil {
-		log.Fatalf("could not listen on port 5000 %v", err)
+	addr := fmt.Sprintf(":%d", params.DefaultPort)
+	if err := http.ListenAndServe(addr, server); err != nil {
+		log.Fatalf("could not listen on %s %v", addr, err)
 	}
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 独立的矿工进程：从节点领取区块模板，在本地找nonce，挖到了就提交回节点
+func main() {
+	node := flag.String("node", "http://localhost:5000", "节点的地址")
+	address := flag.String("address", "", "挖矿奖励发给这个地址")
+	refresh := flag.Duration("refresh", 5*time.Second, "模板用了多久之后重新领取，好把新交易打包进去")
+	flag.Parse()
+	if *address == "" {
+		log.Fatalf("-address is required")
+	}
+
+	for {
+		template, err := getBlockTemplate(*node, *address)
+		if err != nil {
+			log.Printf("could not get block template %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		nonce, ok, err := search(template, time.Now().Add(*refresh))
+		if err != nil {
+			log.Fatalf("could not mine block template %v", err)
+		}
+		if !ok {
+			continue
+		}
+		hash, err := submitBlock(*node, template.TemplateID, nonce)
+		if err != nil {
+			log.Printf("could not submit block %v", err)
+			continue
+		}
+		log.Printf("mined block %d, nonce: %d, hash: %s", template.Height, nonce, hash)
+	}
+}
+
+// search 寻找满足难度要求的nonce，到了deadline或者nonce都试完了还没找到的话返回false
+func search(template blockchain.BlockTemplate, deadline time.Time) (uint32, bool, error) {
+	data, err := hex.DecodeString(template.Header)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(data) != blockchain.BlockHeaderSize {
+		return 0, false, fmt.Errorf("invalid block header size %d", len(data))
+	}
+	var header [blockchain.BlockHeaderSize]byte
+	copy(header[:], data)
+	//sha256可以复用区块头前64字节的中间状态
+	var hasher *blockchain.MidstateHasher
+	if template.Algorithm == blockchain.PowAlgorithmSHA256 {
+		hasher = blockchain.NewMidstateHasher(&header)
+	}
+	for nonce := uint64(0); nonce <= math.MaxUint32; nonce++ {
+		if nonce%1024 == 0 && time.Now().After(deadline) {
+			return 0, false, nil
+		}
+		binary.BigEndian.PutUint32(header[blockchain.HeaderNonceOffset:], uint32(nonce))
+		var hash string
+		if hasher != nil {
+			hash = hex.EncodeToString(hasher.Hash(&header))
+		} else if hash, err = blockchain.PowHash(template.Algorithm, header[:]); err != nil {
+			return 0, false, err
+		}
+		if strings.HasPrefix(hash, template.Target) {
+			return uint32(nonce), true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+func getBlockTemplate(node, address string) (blockchain.BlockTemplate, error) {
+	var template blockchain.BlockTemplate
+	err := post(node+"/getblocktemplate/", map[string]string{"RewardAddress": address}, http.StatusOK, &template)
+	return template, err
+}
+
+func submitBlock(node, templateID string, nonce uint32) (string, error) {
+	var result struct {
+		Hash string `json:"hash"`
+	}
+	err := post(node+"/submitblock/", map[string]interface{}{"TemplateID": templateID, "Nonce": nonce}, http.StatusCreated, &result)
+	return result.Hash, err
+}
+
+func post(url string, body interface{}, expectedStatus int, result interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != expectedStatus {
+		var message bytes.Buffer
+		message.ReadFrom(resp.Body)
+		return fmt.Errorf("%s: %s %s", url, resp.Status, strings.TrimSpace(message.String()))
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,167 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// 后台矿工的默认参数
+const (
+	DefaultTemplateRefreshInterval = 2 * time.Second //有新交易进池时，最快多久重新生成一次待挖的区块
+	backgroundMinerRetryDelay      = time.Second     //挖矿出错后等多久再重试
+)
+
+var (
+	ErrMinerAlreadyRunning = errors.New("background miner already running")
+	ErrMinerNotRunning     = errors.New("background miner not running")
+
+	errNewTransactions = errors.New("new transactions in pool")
+)
+
+// BackgroundMinerStatus 后台矿工的状态
+type BackgroundMinerStatus struct {
+	Running       bool        `json:"running"`
+	RewardAddress string      `json:"rewardAddress"`
+	StartedAt     time.Time   `json:"startedAt"`
+	BlocksMined   uint64      `json:"blocksMined"` //这次启动以来挖出的区块数
+	Restarts      uint64      `json:"restarts"`    //因为新交易或者新区块而重新生成待挖区块的次数
+	LastError     string      `json:"lastError"`
+	Stats         MiningStats `json:"stats"`
+}
+
+// BackgroundMiner 后台持续挖矿：不停地用交易池里的交易生成新区块去挖，奖励发给配置好的地址
+// 链的末端变了会立刻换新的区块来挖；有新交易进池时，最多每TemplateRefreshInterval重新生成一次区块，
+// 避免交易源源不断进来导致一个区块都挖不出来
+type BackgroundMiner struct {
+	chain *Blockchain
+
+	mu                      sync.Mutex
+	templateRefreshInterval time.Duration
+	running                 bool
+	cancel                  context.CancelFunc
+	done                    chan struct{}
+	status                  BackgroundMinerStatus
+}
+
+func NewBackgroundMiner(chain *Blockchain) *BackgroundMiner {
+	return &BackgroundMiner{
+		chain:                   chain,
+		templateRefreshInterval: DefaultTemplateRefreshInterval,
+	}
+}
+
+// SetTemplateRefreshInterval 设置有新交易时重新生成待挖区块的最小间隔
+func (miner *BackgroundMiner) SetTemplateRefreshInterval(interval time.Duration) {
+	miner.mu.Lock()
+	defer miner.mu.Unlock()
+	miner.templateRefreshInterval = interval
+}
+
+// Start 开始在后台挖矿，奖励发给rewardAddress
+func (miner *BackgroundMiner) Start(rewardAddress string) error {
+	if rewardAddress == MinerRewardFromAddress {
+		return errors.New("miner reward address is required")
+	}
+	miner.mu.Lock()
+	defer miner.mu.Unlock()
+	if miner.running {
+		return ErrMinerAlreadyRunning
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	miner.running = true
+	miner.cancel = cancel
+	miner.done = make(chan struct{})
+	miner.status = BackgroundMinerStatus{
+		Running:       true,
+		RewardAddress: rewardAddress,
+		StartedAt:     time.Now(),
+	}
+	go miner.loop(ctx, rewardAddress, miner.templateRefreshInterval, miner.done)
+	return nil
+}
+
+// Stop 停止后台挖矿，等正在挖的区块放弃之后才返回
+func (miner *BackgroundMiner) Stop() error {
+	miner.mu.Lock()
+	if !miner.running {
+		miner.mu.Unlock()
+		return ErrMinerNotRunning
+	}
+	miner.running = false
+	miner.status.Running = false
+	miner.cancel()
+	done := miner.done
+	miner.mu.Unlock()
+
+	<-done
+	return nil
+}
+
+// Status 返回后台矿工的状态
+func (miner *BackgroundMiner) Status() BackgroundMinerStatus {
+	miner.mu.Lock()
+	status := miner.status
+	miner.mu.Unlock()
+	status.Stats = miner.chain.MiningStats()
+	return status
+}
+
+func (miner *BackgroundMiner) loop(ctx context.Context, rewardAddress string, refreshInterval time.Duration, done chan struct{}) {
+	defer close(done)
+	for ctx.Err() == nil {
+		err := miner.mineOne(ctx, rewardAddress, refreshInterval)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err == nil:
+			miner.update(func(status *BackgroundMinerStatus) { status.BlocksMined++ })
+		case errors.Is(err, ErrStaleTip) || errors.Is(err, errNewTransactions):
+			miner.update(func(status *BackgroundMinerStatus) { status.Restarts++ })
+		case errors.Is(err, ErrNotInTurn):
+			//权威证明下还没轮到本节点出块，等别人出了新区块再看
+			select {
+			case <-ctx.Done():
+			case <-miner.chain.TipChangedNotify():
+			case <-time.After(backgroundMinerRetryDelay):
+			}
+		default:
+			miner.update(func(status *BackgroundMinerStatus) { status.LastError = err.Error() })
+			select {
+			case <-ctx.Done():
+			case <-time.After(backgroundMinerRetryDelay):
+			}
+		}
+	}
+}
+
+// mineOne 生成一个区块去挖，有新交易进池的话，在区块生成超过refreshInterval之后放弃它，好把新交易也打包进去
+func (miner *BackgroundMiner) mineOne(ctx context.Context, rewardAddress string, refreshInterval time.Duration) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	txAdded := miner.chain.transationsPool.TxAddedNotify()
+	templateCreated := time.Now()
+	go func() {
+		select {
+		case <-txAdded:
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-time.After(time.Until(templateCreated.Add(refreshInterval))):
+			cancel(errNewTransactions)
+		case <-ctx.Done():
+		}
+	}()
+
+	return miner.chain.MineTransctionFromPoolWithContext(ctx, rewardAddress)
+}
+
+func (miner *BackgroundMiner) update(f func(status *BackgroundMinerStatus)) {
+	miner.mu.Lock()
+	defer miner.mu.Unlock()
+	f(&miner.status)
+}
@@ -0,0 +1,161 @@
+package blockchain
+
+import (
+	"container/heap"
+	"time"
+)
+
+// DefaultMaxBlockSize 一个区块里能容纳的交易的总字节数
+const DefaultMaxBlockSize = 1024 * 1024
+
+// blockPackage 打包候选：一笔交易连同它还没被选进区块的所有祖先交易，祖先排在前面
+// 矿工按整体费率来挑选，这样高手续费的子交易能把低手续费的父交易一起带进区块(CPFP)，
+// 不管父交易是同一个发送者nonce更小的交易，还是转钱给子交易发送者的交易
+type blockPackage struct {
+	entry   *mempoolEntry //交易包的最后一笔交易
+	entries []*mempoolEntry
+	fee     float64
+	size    int
+}
+
+func (pkg *blockPackage) feeRate() float64 {
+	return pkg.fee / float64(pkg.size)
+}
+
+func (pkg *blockPackage) betterThan(other *blockPackage) bool {
+	if pkg.feeRate() != other.feeRate() {
+		return pkg.feeRate() > other.feeRate()
+	}
+	return pkg.entry.sequence < other.entry.sequence
+}
+
+// packageHeap 每笔交易的交易包，堆顶是整体费率最高的
+type packageHeap []*blockPackage
+
+func (h packageHeap) Len() int           { return len(h) }
+func (h packageHeap) Less(i, j int) bool { return h[i].betterThan(h[j]) }
+func (h packageHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *packageHeap) Push(x any)        { *h = append(*h, x.(*blockPackage)) }
+func (h *packageHeap) Pop() any {
+	old := *h
+	pkg := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return pkg
+}
+
+// packageSelection 挑选交易的过程中每笔交易的状态
+type packageSelection struct {
+	next     map[string]uint64 //每个发送者链上下一个nonce
+	selected map[*mempoolEntry]bool
+	skipped  map[*mempoolEntry]bool //放不下、付不起或者接不上链上nonce，连同子孙交易都不再考虑
+	stale    map[*mempoolEntry]bool //有祖先交易被选中了，堆里的交易包要重算
+}
+
+// newPackage entry连同它还没被选中的祖先交易组成的交易包，有祖先接不上链上nonce的话返回nil
+// 交易池限制了一簇交易的数量，这里的遍历开销也有上限
+func (selection *packageSelection) newPackage(entry *mempoolEntry) *blockPackage {
+	ancestors := walkPostOrder(entry, (*mempoolEntry).parents, func(e *mempoolEntry) bool { return selection.selected[e] })
+	pkg := &blockPackage{entry: entry, entries: append(ancestors, entry)}
+	for _, e := range pkg.entries {
+		//同一个发送者nonce更小的交易都已经上链或者选中了，才轮得到它
+		if e.parent == nil && e.tx.nonce != selection.next[e.tx.from] {
+			return nil
+		}
+		pkg.fee += e.tx.fee
+		pkg.size += e.size
+	}
+	return pkg
+}
+
+// skip 交易和它的子孙交易都不再考虑，包含它的交易包同样放不下或者付不起
+func (selection *packageSelection) skip(entry *mempoolEntry) {
+	selection.skipped[entry] = true
+	for _, descendant := range entry.descendants() {
+		selection.skipped[descendant] = true
+	}
+}
+
+// markStale 交易包选中之后，依赖包里交易的交易都少了几个没选中的祖先，交易包要重算
+func (selection *packageSelection) markStale(entries []*mempoolEntry) {
+	visited := map[*mempoolEntry]bool{}
+	stack := []*mempoolEntry{}
+	for _, e := range entries {
+		stack = append(stack, e.children()...)
+	}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[e] || selection.selected[e] {
+			continue
+		}
+		visited[e] = true
+		selection.stale[e] = true
+		stack = append(stack, e.children()...)
+	}
+}
+
+// assembleBlockTransactions 从交易池里挑选要打包进新区块的交易，调用方需要持有链的锁
+// 每一轮都挑整体费率最高、而且放得下的交易包，直到池子挑完或者区块放满
+// 选中一个交易包之后，只有它的子孙交易的交易包会变，标记一下，等从堆里取出来的时候再重算
+func (blockchain *Blockchain) assembleBlockTransactions(maxSize int) []Transaction {
+	pool := blockchain.transationsPool
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	defer pool.publishEvents()
+	pool.expire(time.Now())
+
+	selection := &packageSelection{
+		next:     blockchain.state.nonces,
+		selected: map[*mempoolEntry]bool{},
+		skipped:  map[*mempoolEntry]bool{},
+		stale:    map[*mempoolEntry]bool{},
+	}
+	candidates := packageHeap{}
+	for _, entry := range pool.entries {
+		if pkg := selection.newPackage(entry); pkg != nil {
+			candidates = append(candidates, pkg)
+		}
+	}
+	heap.Init(&candidates)
+
+	balances := newPendingBalances(blockchain.state)
+	transactions := []Transaction{}
+	size := 0
+	for candidates.Len() > 0 {
+		best := heap.Pop(&candidates).(*blockPackage)
+		entry := best.entry
+		if selection.selected[entry] || selection.skipped[entry] {
+			continue
+		}
+		if selection.stale[entry] {
+			delete(selection.stale, entry)
+			if pkg := selection.newPackage(entry); pkg != nil {
+				heap.Push(&candidates, pkg)
+			}
+			continue
+		}
+		if size+best.size > maxSize || !packageAffordable(best, balances) {
+			selection.skip(entry)
+			continue
+		}
+		for _, e := range best.entries {
+			size += e.size
+			transactions = append(transactions, e.tx)
+			selection.selected[e] = true
+		}
+		selection.markStale(best.entries)
+	}
+	return transactions
+}
+
+// packageAffordable 检查交易包按顺序放进区块后，发送者的余额是不是付得起；付得起的话把交易包带来的余额变化记进balances
+func packageAffordable(pkg *blockPackage, balances *pendingBalances) bool {
+	trial := balances.child()
+	for _, e := range pkg.entries {
+		if trial.spend(e.tx) != nil {
+			return false
+		}
+	}
+	trial.commit()
+	return true
+}
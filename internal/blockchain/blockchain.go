@@ -3,11 +3,15 @@ package blockchain
 import (
 	"CcCoin-go-version/internal/encryption" //导入自个项目里的包
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"strconv"
+	"math/big"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -15,27 +19,98 @@ const (
 	MinerRewardFromAddress = ""
 )
 
+var (
+	ErrDoubleSpend         = errors.New("double spend")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+)
+
 type Transaction struct {
-	//from和to表示交易者的钱包地址，amount表示交易的金额
+	//from和to表示交易者的钱包地址，amount表示交易的金额，fee表示付给矿工的手续费
+	//nonce表示这是发送者发出的第几笔交易，同一个发送者的同一个nonce只能上链一次，用来防止双花
 	to        string
 	from      string
 	amount    float64
+	fee       float64
+	nonce     uint64
 	signature string
+
+	//只有矿工奖励交易用到：区块头里的nonce都试完了还没挖到的话，矿工把它加一，区块内容变了又可以从头试一遍nonce
+	extraNonce uint64
 }
 
 func NewTransaction(senderPublicKey, senderPrivateKey, receiverPublicKey string, amount float64) (Transaction, error) {
-	transaction := Transaction{from: senderPublicKey, to: receiverPublicKey, amount: amount}
+	return NewTransactionWithFee(senderPublicKey, senderPrivateKey, receiverPublicKey, amount, 0)
+}
+
+// NewTransactionWithFee 创建一笔带手续费的交易，手续费越高越容易进交易池、越早被打包
+func NewTransactionWithFee(senderPublicKey, senderPrivateKey, receiverPublicKey string, amount, fee float64) (Transaction, error) {
+	return NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, amount, fee, 0)
+}
+
+// NewTransactionWithNonce 创建发送者的第nonce笔交易，nonce需要和链上该发送者已经确认的交易数一致
+func NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey string, amount, fee float64, nonce uint64) (Transaction, error) {
+	transaction := Transaction{from: senderPublicKey, to: receiverPublicKey, amount: amount, fee: fee, nonce: nonce}
 	//使用发送者的密钥对里的私钥来进行签名
 	err := transaction.Sign(senderPrivateKey)
 	return transaction, err
 }
 
+// computeHash 交易内容的hash，签名签的就是它
+// 地址带上长度前缀，金额、手续费和nonce都按固定的8个字节写进去，不同的交易内容拼不出同一串字节，
+// 否则转发交易的人可以把(金额1, 手续费10)改成(金额11, 手续费0)，签名照样能通过
 func (t *Transaction) computeHash() string {
-	data := fmt.Sprintf("%v%v%v", t.from, t.to, t.amount)
-	hash := sha256.Sum256([]byte(data))
+	var data bytes.Buffer
+	for _, address := range []string{t.from, t.to} {
+		binary.Write(&data, binary.BigEndian, uint32(len(address)))
+		data.WriteString(address)
+	}
+	binary.Write(&data, binary.BigEndian, t.amount)
+	binary.Write(&data, binary.BigEndian, t.fee)
+	binary.Write(&data, binary.BigEndian, t.nonce)
+	binary.Write(&data, binary.BigEndian, t.extraNonce)
+	hash := sha256.Sum256(data.Bytes())
 	return string(hash[:])
 }
 
+func spendKey(from string, nonce uint64) string {
+	return fmt.Sprintf("%s:%d", from, nonce)
+}
+
+// spendKey 交易花的是哪一份钱，两笔交易的spendKey相同就说明它们是双花
+func (t *Transaction) spendKey() string {
+	return spendKey(t.from, t.nonce)
+}
+
+// ID 交易的唯一标识，由交易内容和签名一起算出来
+func (t *Transaction) ID() string {
+	hash := sha256.Sum256([]byte(t.computeHash() + t.signature))
+	return hex.EncodeToString(hash[:])
+}
+
+// Size 交易占用的字节数，用来计算费率和交易池的容量
+func (t *Transaction) Size() int {
+	//amount、fee和nonce各按8个字节算
+	return len(t.from) + len(t.to) + len(t.signature) + 8*3
+}
+
+// FeeRate 每字节的手续费
+func (t *Transaction) FeeRate() float64 {
+	return t.fee / float64(t.Size())
+}
+
+func (t *Transaction) Fee() float64 {
+	return t.fee
+}
+
+func (t *Transaction) Nonce() uint64 {
+	return t.nonce
+}
+
+// ExtraNonce 矿工奖励交易里的extra nonce
+func (t *Transaction) ExtraNonce() uint64 {
+	return t.extraNonce
+}
+
 // Sign 使用私钥对交易数据的哈希值进行签名
 func (t *Transaction) Sign(privateKey string) error {
 	var err error
@@ -43,20 +118,31 @@ func (t *Transaction) Sign(privateKey string) error {
 	return err
 }
 
+// Validate 校验交易的签名，不通过的话返回带交易id的*ValidationError
+func (t *Transaction) Validate() error {
+	if err := t.verifySignature(); err != nil {
+		return newTxError(t, err)
+	}
+	return nil
+}
+
 func (t *Transaction) IsValid() bool {
+	return t.Validate() == nil
+}
+
+func (t *Transaction) verifySignature() error {
 	//当this.from === ''，说明该转账是由区块链发起的矿工奖励，无需校验签名的合法性
 	if t.from == MinerRewardFromAddress {
-		return true
+		return nil
 	}
-
-	res := false
-	var err error
-	res, err = encryption.VerifySignature(t.from, t.computeHash(), t.signature)
+	res, err := encryption.VerifySignature(t.from, t.computeHash(), t.signature)
 	if err != nil {
-		fmt.Println("verify signature failed,err:", err)
-		return false
+		return fmt.Errorf("%w: %v", ErrInvalidTxSignature, err)
 	}
-	return res
+	if !res {
+		return ErrInvalidTxSignature
+	}
+	return nil
 }
 
 // 区块，用来存储交易信息
@@ -64,8 +150,20 @@ type Block struct {
 	transactions []Transaction //这个区块所存储的交易信息
 	prevHash     string        //前一个区块的hash
 	hash         string        //hash是一个区块的指纹
-	nonce        int           //随机数
+	nonce        uint32        //随机数，在区块头里占4个字节
 	timestamp    uint64        //时间戳
+	message      string        //区块附带的信息，创世区块用来记录创世信息
+
+	//下面是权威证明(PoA)和权益证明(PoS)用到的字段，工作量证明的区块里都是空的，不影响区块的hash
+	extra         string       //创世区块里记录初始的签名者列表，用逗号分隔
+	signer        string       //出块的签名者(公钥)
+	vote          string       //签名者这次投票要加入或者踢出的地址
+	voteAuthorize bool         //true表示投票加入，false表示投票踢出
+	slashings     []DoubleSign //权益证明里举报的双签证据
+	signature     string       //签名者对区块hash的签名，不参与hash计算
+
+	stateRoot  string             //高度是SnapshotInterval的倍数的区块才有：应用这个区块之后的账本状态的hash
+	prunedRoot *[sha256.Size]byte //交易被裁剪掉之后保留下来的contentRoot，nil表示交易还在
 }
 
 func NewBlock(transactions []Transaction, prevHash string) Block {
@@ -80,17 +178,64 @@ func NewBlock(transactions []Transaction, prevHash string) Block {
 }
 
 func (block *Block) computeHash() string {
-	data := bytes.Join(
-		[][]byte{
-			[]byte(block.prevHash),
-			[]byte(fmt.Sprintf("%v", block.transactions)),
-			[]byte(strconv.FormatUint(block.timestamp, 10)),
-			[]byte(strconv.Itoa(block.nonce)),
-		},
-		[]byte{},
-	)
-	hash := sha256.Sum256(data)
-	return string(hash[:])
+	header := block.header()
+	hash := sha256.Sum256(header[:])
+	//用十六进制表示，方便打印和在checkpoint里面写死
+	return hex.EncodeToString(hash[:])
+}
+
+func (block *Block) voteData() string {
+	if block.vote == "" {
+		return ""
+	}
+	if block.voteAuthorize {
+		return "+" + block.vote
+	}
+	return "-" + block.vote
+}
+
+func (block *Block) slashingData() string {
+	var data strings.Builder
+	for _, evidence := range block.slashings {
+		data.WriteString(evidence.First.hash)
+		data.WriteString(evidence.Second.hash)
+	}
+	return data.String()
+}
+
+// Hash 区块的hash
+func (block *Block) Hash() string {
+	return block.hash
+}
+
+// Transactions 区块里的交易，最后一笔是矿工奖励；交易被裁剪掉的区块返回nil
+func (block *Block) Transactions() []Transaction {
+	return block.transactions
+}
+
+// Pruned 区块的交易是否已经被裁剪掉了，只剩下区块头
+func (block *Block) Pruned() bool {
+	return block.prunedRoot != nil
+}
+
+// Timestamp 区块的时间戳
+func (block *Block) Timestamp() uint64 {
+	return block.timestamp
+}
+
+// Signer 出块的签名者，只有权威证明和权益证明的区块才有
+func (block *Block) Signer() string {
+	return block.signer
+}
+
+// Vote 签名者在这个区块里的投票，address为空表示没有投票
+func (block *Block) Vote() (address string, authorize bool) {
+	return block.vote, block.voteAuthorize
+}
+
+// Slashings 区块里举报的双签证据
+func (block *Block) Slashings() []DoubleSign {
+	return block.slashings
 }
 
 func (block *Block) getAnswer(difficulty int) string {
@@ -98,70 +243,228 @@ func (block *Block) getAnswer(difficulty int) string {
 	return strings.Repeat("0", difficulty)
 }
 
-func (block *Block) validateBlockTransations() bool {
-	for _, t := range block.transactions {
-		if !t.IsValid() {
-			fmt.Println("invalid transaction found in transations, 发现异常交易")
-			return false
+// validateBlockTransations 校验区块里的交易，sigErrs是signatureVerifier对每笔交易签名校验的结果，为nil时跳过签名校验(比如checkpoint之前的区块)
+// 只检查不依赖链上状态的规则，出错时返回的*ValidationError还没有标上区块高度
+func (block *Block) validateBlockTransations(sigErrs []error) error {
+	spent := map[string]bool{}
+	for i, t := range block.transactions {
+		if sigErrs != nil && sigErrs[i] != nil {
+			return newTxError(&t, sigErrs[i])
+		}
+		if t.from == MinerRewardFromAddress {
+			//矿工奖励只能是区块的最后一笔交易
+			if i != len(block.transactions)-1 {
+				return newTxError(&t, ErrMisplacedMinerReward)
+			}
+			if !validAmount(t.amount) {
+				return newTxError(&t, ErrInvalidAmount)
+			}
+			continue
 		}
+		if !validAmount(t.amount) || !validAmount(t.fee) {
+			return newTxError(&t, ErrInvalidAmount)
+		}
+		//同一个区块里不能出现两笔花同一份钱的交易
+		if spent[t.spendKey()] {
+			return newTxError(&t, fmt.Errorf("%w: nonce %d spent twice in block", ErrDoubleSpend, t.nonce))
+		}
+		spent[t.spendKey()] = true
 	}
-	return true
+	return nil
 }
 
 // 计算符号区块难度要求的hash
 // 为什么需要引入难度要求?为了控制每10min会有一个区块被挖矿挖出来，需要动态调整这个难度要求
-func (block *Block) mine(difficulty int) error {
+// nonce空间会分给opts.Workers个goroutine一起挖，ctx被取消时立刻停下来；newHasher决定用哪种hash算法来衡量工作量
+// 每opts.NonceRange个nonce都试完了还没挖到的话，换一个extra nonce并更新时间戳，接着挖
+func (block *Block) mine(ctx context.Context, difficulty int, opts SealOptions, newHasher newPowHasherFunc) error {
 	//开挖之前，应该要检查一下即将要挖来存储的transctions的合法性,避免浪费算力
-	bOk := block.validateBlockTransations()
-	if !bOk {
-		fmt.Println("invalid transaction found in transations, stop mining!")
-		return errors.New("invalid transaction found in transations")
+	if err := block.validateBlockTransations(opts.verifier.verify(block.transactions)); err != nil {
+		return err
 	}
 
-	ans := block.getAnswer(difficulty)
+	var nonce uint32
+	var hashRes string
 	for {
-		hashRes := block.computeHash()
-		//fmt.Println(hashRes)
-		if hashRes[:difficulty] != ans {
-			//改变随机数，继续尝试
-			block.nonce++
-		} else {
-			block.hash = hashRes
-			fmt.Printf("finish mining, nonce:%d,difficulty:%d,hash:%s\n", block.nonce, difficulty, block.hash)
+		var err error
+		nonce, hashRes, err = searchNonce(ctx, *block, difficulty, opts.Workers, opts.NonceRange, opts.telemetry, newHasher)
+		if err == nil {
 			break
 		}
+		if !errors.Is(err, ErrNonceSpaceExhausted) {
+			return err
+		}
+		extraNonce, err := block.rollExtraNonce()
+		if err != nil {
+			return err
+		}
+		opts.telemetry.rollExtraNonce(extraNonce)
+	}
+	block.nonce = nonce
+	block.hash = hashRes
+	fmt.Printf("finish mining, nonce:%d,difficulty:%d,hash:%s\n", block.nonce, difficulty, block.hash)
+	return nil
+}
+
+// rollExtraNonce 矿工奖励交易的extra nonce加一，时间戳更新到现在，区块头的前半部分跟着变了，又有一整个nonce空间可以试
+func (block *Block) rollExtraNonce() (uint64, error) {
+	last := len(block.transactions) - 1
+	if last < 0 {
+		return 0, errors.New("block has no miner reward transaction to carry extra nonce")
+	}
+	extraNonce := block.transactions[last].extraNonce + 1
+	if err := block.setExtraNonce(extraNonce); err != nil {
+		return 0, err
+	}
+	block.timestamp = max(block.timestamp, uint64(time.Now().Unix()))
+	return extraNonce, nil
+}
+
+// setExtraNonce 把矿工奖励交易的extra nonce设成extraNonce
+func (block *Block) setExtraNonce(extraNonce uint64) error {
+	last := len(block.transactions) - 1
+	if last < 0 || block.transactions[last].from != MinerRewardFromAddress {
+		return errors.New("block has no miner reward transaction to carry extra nonce")
 	}
+	//交易列表可能和别的区块模板共用，复制一份再改
+	transactions := append([]Transaction{}, block.transactions...)
+	transactions[last].extraNonce = extraNonce
+	block.transactions = transactions
 	return nil
 }
 
 // 区块的链表
 // 区块链是一个transations转账记录的池子，需要一个miner reword
+// Blockchain 可以被多个goroutine同时使用：读操作之间互不阻塞，挖矿的时候也不持有锁，只有把区块接到链上时才短暂加写锁
 type Blockchain struct {
-	blocks          []Block       //保存的所有区块
-	difficulty      int           //复杂度
-	transationsPool []Transaction //交易池子
-	minerReward     float64       //矿工奖励
+	mu       sync.RWMutex //保护下面所有字段
+	miningMu sync.Mutex   //同一时间只让一个矿工挖矿，避免同时在同一个区块高度上浪费算力
+
+	params          ChainParams     //链参数
+	engine          ConsensusEngine //共识引擎
+	blocks          []Block         //保存的所有区块
+	transationsPool *Mempool        //交易池子，自己带锁
+	minerReward     float64         //矿工奖励
+	maxBlockSize    int             //每个区块能容纳的交易字节数
+	checkpoints     []Checkpoint
+	miningWorkers   int             //挖矿时并行的goroutine数
+	nonceRange      uint64          //每个extra nonce下尝试的nonce个数，0表示整个nonce空间
+	tipChanged      chan struct{}   //每接上一个新区块就关闭并换一个新的，正在挖旧区块的矿工据此停下来
+	telemetry       miningTelemetry //挖矿统计，自己带锁
+	templates       TemplateStore   //NewBlockTemplate发给外部矿工的区块模板，自己带锁
+	events          *eventFeed      //区块和交易事件的订阅者，自己带锁
+	sigCache        *sigCache       //验过签名的交易，自己带锁
+	verifyWorkers   int             //校验区块时并行验签名的goroutine数，0表示GOMAXPROCS
+	pruneDepth      int             //只保留最近多少个区块的交易，0表示不裁剪
+	prunedHeight    int             //这个高度及之前的区块(创世区块除外)的交易已经被裁剪掉了
+	prunedState     *ledgerState    //prunedHeight处的账本状态，裁剪之后就不会再改，nil表示还没有裁剪过
+
+	state *ledgerState //账本状态，记录每个地址的余额、nonce和锁定的权益
+}
+
+var ErrStaleTip = errors.New("chain tip changed while mining")
+
+// NewBlockchain 使用主网参数创建区块链，difficulty覆盖主网的挖矿难度
+func NewBlockchain(difficulty int) *Blockchain {
+	return NewBlockchainWithMempool(difficulty, DefaultMempoolConfig())
 }
 
-func NewBlockchain(difficulty int) Blockchain {
-	blockchain := Blockchain{
+// NewBlockchainWithMempool 使用指定的交易池配置来创建区块链
+// 难度不合法(比如小于0)时建不出创世区块，直接panic，需要处理错误的调用方用NewBlockchainWithParams
+func NewBlockchainWithMempool(difficulty int, mempoolConfig MempoolConfig) *Blockchain {
+	params := MainNetParams
+	params.Genesis.Difficulty = difficulty
+	params.Mempool = mempoolConfig
+	if difficulty != MainNetParams.Genesis.Difficulty {
+		//创世hash承诺了难度，换了难度就是另一条链，和主网的checkpoint对不上
+		params.Checkpoints = nil
+	}
+	blockchain, err := NewBlockchainWithParams(params)
+	if err != nil {
+		panic(fmt.Sprintf("create blockchain with difficulty %d: %v", difficulty, err))
+	}
+	return blockchain
+}
+
+// NewBlockchainFromGenesis 根据创世配置创建区块链，配置里期望的创世hash和实际不一致时返回错误
+func NewBlockchainFromGenesis(genesis GenesisConfig, mempoolConfig MempoolConfig) (*Blockchain, error) {
+	params := MainNetParams
+	params.Genesis = genesis
+	params.Mempool = mempoolConfig
+	//自定义的创世区块和主网的checkpoint对不上
+	params.Checkpoints = nil
+	return NewBlockchainWithParams(params)
+}
+
+// NewBlockchainWithParams 根据链参数创建区块链，共识引擎由链参数决定
+func NewBlockchainWithParams(params ChainParams) (*Blockchain, error) {
+	engine, err := NewConsensusEngine(params)
+	if err != nil {
+		return nil, err
+	}
+	return NewBlockchainWithEngine(params, engine)
+}
+
+// NewBlockchainWithEngine 使用指定的共识引擎创建区块链
+func NewBlockchainWithEngine(params ChainParams, engine ConsensusEngine) (*Blockchain, error) {
+	genesis := params.Genesis
+	blockchain := &Blockchain{
+		params:          params,
+		engine:          engine,
 		blocks:          []Block{},
-		difficulty:      difficulty,
-		transationsPool: []Transaction{},
-		minerReward:     50,
+		transationsPool: NewMempool(params.Mempool),
+		minerReward:     genesis.MinerReward,
+		maxBlockSize:    params.MaxBlockSize,
+		miningWorkers:   DefaultMiningWorkers(),
+		tipChanged:      make(chan struct{}),
+		events:          newEventFeed(),
+		sigCache:        newSigCache(DefaultSignatureCacheSize),
+		state:           newLedgerState(),
+	}
+	blockchain.transationsPool.events = blockchain.events
+	blockchain.setCheckpoints(params.Checkpoints)
+	if err := genesis.Validate(params.PowAlgorithm); err != nil {
+		return blockchain, err
 	}
 	//每当这个puzzle被发出来后，矿工会从transctionPool池子里面去一部分收益最高的transction(因为每一个block的大小是有限的，能容纳的transction数目是有限的)，以这些transction为基础去新建这个block
 	//也就意味着这个block的新建应该是发生在链上的，发生在哪一个步骤呢，发生在挖transction这个操作里
-	blockchain.blocks = append(blockchain.blocks, blockchain.bingBang())
-	return blockchain
+	genesisBlock := blockchain.bingBang(genesis, params.PowAlgorithm)
+	if err := blockchain.checkCheckpoint(0, genesisBlock.hash); err != nil {
+		return blockchain, err
+	}
+	blockchain.blocks = append(blockchain.blocks, genesisBlock)
+	blockchain.state.applyBlock(genesisBlock)
+	return blockchain, nil
+}
+
+// Params 返回链参数
+func (blockchain *Blockchain) Params() ChainParams {
+	return blockchain.params
+}
+
+// Engine 返回链使用的共识引擎
+func (blockchain *Blockchain) Engine() ConsensusEngine {
+	return blockchain.engine
+}
+
+// snapshot 返回以高度height的区块为末端的链快照，调用方需要持有链的锁
+func (blockchain *Blockchain) snapshot(height int) *chainSnapshot {
+	return &chainSnapshot{params: blockchain.params, blocks: blockchain.blocks[:height+1]}
 }
 
 // 生成祖先区块/创世区块(Genesis Block)
 // 创世区块是区块链中第一个被创建的区块
 // 隐喻了区块链网络的诞生,就像宇宙大爆炸(Big Bang)一样,创世区块标志着区块链网络的开始。
-func (blockchain *Blockchain) bingBang() Block {
-	genesisBlock := NewBlock([]Transaction{}, "0")
-	return genesisBlock
+// 创世区块的内容完全由创世配置决定，这样不同节点生成的创世区块是同一个
+func (blockchain *Blockchain) bingBang(genesis GenesisConfig, powAlgorithm string) Block {
+	return genesis.Block(powAlgorithm)
+}
+
+// GenesisHash 返回创世区块的hash，节点之间可以用它来确认是不是同一条链
+func (blockchain *Blockchain) GenesisHash() string {
+	blockchain.mu.RLock()
+	defer blockchain.mu.RUnlock()
+	return blockchain.blocks[0].hash
 }
 
 func (blockchain *Blockchain) getLatestBlock() Block {
@@ -171,72 +474,362 @@ func (blockchain *Blockchain) getLatestBlock() Block {
 // 添加待存储的transction到transction pool里面，供后续挖出来的block来存储这些transction交易记录
 func (blockchain *Blockchain) AddTransction2Pool(transaction Transaction) error {
 	// 添加transaction到transationsPool之前，先校验一下transation的合法性
-	if !transaction.IsValid() {
-		return errors.New("invalid transaction,reject it")
+	if err := blockchain.sigCache.verify(&transaction); err != nil {
+		return newTxError(&transaction, err)
+	}
+	if transaction.from == MinerRewardFromAddress {
+		//矿工奖励只能由挖矿的时候生成，不能从外面塞进池子
+		return newTxError(&transaction, ErrMisplacedMinerReward)
+	}
+	if !validAmount(transaction.amount) || !validAmount(transaction.fee) {
+		return newTxError(&transaction, ErrInvalidAmount)
+	}
+
+	//持有读锁，保证校验nonce和进池之间不会有新区块接上来
+	blockchain.mu.RLock()
+	defer blockchain.mu.RUnlock()
+	//nonce比链上已确认的还小，说明这份钱已经在链上被花掉了
+	if transaction.nonce < blockchain.state.nonces[transaction.from] {
+		return newTxError(&transaction, fmt.Errorf("%w: nonce %d already confirmed", ErrDoubleSpend, transaction.nonce))
+	}
+	//已确认的余额减去池子里同一个发送者排在前面的交易，要够付这笔交易，锁定的权益也只能来自这部分余额
+	//不够的话可以借用池子里别人转过来、还没上链的钱，这笔交易就依赖那些交易，它们被替换或者驱逐的话这笔交易也会被驱逐
+	if err := blockchain.transationsPool.addFunded(transaction, blockchain.state.balances[transaction.from], blockchain.state.nonces[transaction.from]); err != nil {
+		return err
 	}
-	blockchain.transationsPool = append(blockchain.transationsPool, transaction)
 	fmt.Println("valid transaction has been pushed to transationsPool")
 	return nil
 }
 
+// AccountNonce 返回地址已经上链的交易数，也就是该地址下一笔交易应该使用的nonce
+func (blockchain *Blockchain) AccountNonce(address string) uint64 {
+	blockchain.mu.RLock()
+	defer blockchain.mu.RUnlock()
+	return blockchain.state.nonces[address]
+}
+
+// GetBalance 返回地址在链上已确认的余额
+func (blockchain *Blockchain) GetBalance(address string) float64 {
+	blockchain.mu.RLock()
+	defer blockchain.mu.RUnlock()
+	return blockchain.state.balances[address]
+}
+
+// GetStake 返回地址在链上锁定的权益
+func (blockchain *Blockchain) GetStake(address string) float64 {
+	blockchain.mu.RLock()
+	defer blockchain.mu.RUnlock()
+	return blockchain.state.stakes[address]
+}
+
+// PendingNonce 把交易池里还没上链的交易也算上，返回该地址下一笔交易应该使用的nonce
+func (blockchain *Blockchain) PendingNonce(address string) uint64 {
+	blockchain.mu.RLock()
+	defer blockchain.mu.RUnlock()
+	nonce := blockchain.state.nonces[address]
+	for blockchain.transationsPool.HasSpend(address, nonce) {
+		nonce++
+	}
+	return nonce
+}
+
+// Height 返回最新区块的高度，创世区块的高度为0
+func (blockchain *Blockchain) Height() int {
+	blockchain.mu.RLock()
+	defer blockchain.mu.RUnlock()
+	return len(blockchain.blocks) - 1
+}
+
+// TotalWeight 返回创世区块之后所有区块的权重之和，分叉的时候权重大的链胜出
+func (blockchain *Blockchain) TotalWeight() *big.Int {
+	blockchain.mu.RLock()
+	defer blockchain.mu.RUnlock()
+	total := new(big.Int)
+	for i := 1; i < len(blockchain.blocks); i++ {
+		total.Add(total, blockchain.engine.Weight(blockchain.snapshot(i-1), &blockchain.blocks[i]))
+	}
+	return total
+}
+
+// GetBlock 返回指定高度的区块，交易已经被裁剪掉的区块Pruned()返回true
+func (blockchain *Blockchain) GetBlock(height int) (Block, bool) {
+	blockchain.mu.RLock()
+	defer blockchain.mu.RUnlock()
+	if height < 0 || height >= len(blockchain.blocks) {
+		return Block{}, false
+	}
+	return blockchain.blocks[height], true
+}
+
+// SetMaxBlockSize 设置每个区块能容纳的交易字节数
+func (blockchain *Blockchain) SetMaxBlockSize(size int) {
+	blockchain.mu.Lock()
+	defer blockchain.mu.Unlock()
+	blockchain.maxBlockSize = size
+}
+
+// SetMiningWorkers 设置挖矿时并行的goroutine数
+func (blockchain *Blockchain) SetMiningWorkers(workers int) {
+	blockchain.mu.Lock()
+	defer blockchain.mu.Unlock()
+	blockchain.miningWorkers = workers
+}
+
+// SetNonceRange 设置每个extra nonce下最多尝试多少个nonce，试完了就换extra nonce并更新时间戳
+// 0或者超过4字节nonce空间的话就是整个nonce空间；设小一点可以让挖了很久的区块的时间戳也不至于太旧
+func (blockchain *Blockchain) SetNonceRange(nonceRange uint64) {
+	blockchain.mu.Lock()
+	defer blockchain.mu.Unlock()
+	blockchain.nonceRange = nonceRange
+}
+
+// MiningStats 返回矿工当前(或者上一次)挖矿的统计数据
+func (blockchain *Blockchain) MiningStats() MiningStats {
+	return blockchain.telemetry.stats()
+}
+
+// Mempool 返回链的交易池
+func (blockchain *Blockchain) Mempool() *Mempool {
+	return blockchain.transationsPool
+}
+
 // 从chain的待存储的transationsPool里面挑选收益最高的transations来存储到新生成的block
 // 也就是说生成block的过程应该是chain来负责了，而不是像上面方法一样是外面传进来的
 func (blockchain *Blockchain) MineTransctionFromPool(minerRewardAddress string) error {
-	///生成矿工奖励的transction,放到transationsPool里面
-	minerRewardTransction := Transaction{
-		from:   MinerRewardFromAddress,
-		to:     minerRewardAddress,
-		amount: blockchain.minerReward,
+	return blockchain.MineTransctionFromPoolWithContext(context.Background(), minerRewardAddress)
+}
+
+// MineTransctionFromPoolWithContext 和MineTransctionFromPool一样，但ctx被取消或者链的末端变了的时候会立刻停止挖矿
+// 因为链的末端变了而停下来时返回ErrStaleTip
+func (blockchain *Blockchain) MineTransctionFromPoolWithContext(ctx context.Context, minerRewardAddress string) error {
+	if minerRewardAddress == MinerRewardFromAddress {
+		return errors.New("miner reward address is required")
+	}
+	blockchain.miningMu.Lock()
+	defer blockchain.miningMu.Unlock()
+
+	newBlock, chain, opts, tipChanged := blockchain.newBlockTemplate(minerRewardAddress)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		select {
+		case <-tipChanged:
+			cancel(ErrStaleTip)
+		case <-ctx.Done():
+		}
+	}()
+
+	//挖矿很慢，这期间不持有链的锁，别的请求可以照常读链、往池子里加交易
+	if err := blockchain.engine.Prepare(chain, &newBlock); err != nil {
+		return err
 	}
-	err := blockchain.AddTransction2Pool(minerRewardTransction)
+	if err := blockchain.commitState(&newBlock); err != nil {
+		return err
+	}
+	err := blockchain.engine.Seal(ctx, chain, &newBlock, opts)
 	if err != nil {
-		fmt.Println("add minerRewardTransction to transationsPool failed")
 		return err
 	}
+	if err := blockchain.connectSealedBlock(newBlock); err != nil {
+		return err
+	}
+	blockchain.telemetry.blockConnected()
+	return nil
+}
 
-	//从transationsPool挑选收益最高的transations来存储到新生成的block
-	newBlock := NewBlock(blockchain.transationsPool, blockchain.getLatestBlock().hash)
-	err = newBlock.mine(blockchain.difficulty)
-	if err != nil {
+// connectSealedBlock 把封装好的区块接到链的末端
+// 和ProcessBlock一样按账本状态校验nonce、余额、矿工奖励和状态承诺，共识引擎封装时改坏了区块也接不上来
+func (blockchain *Blockchain) connectSealedBlock(newBlock Block) error {
+	blockchain.mu.Lock()
+	defer blockchain.mu.Unlock()
+	//挖矿期间链的末端变了的话，这个区块已经接不上了
+	if newBlock.prevHash != blockchain.getLatestBlock().hash {
+		return ErrStaleTip
+	}
+	height := len(blockchain.blocks)
+	//和checkpoint冲突的区块不能接到链上
+	if err := blockchain.checkCheckpoint(height, newBlock.hash); err != nil {
 		return err
 	}
+	if err := validateBlockAgainstState(blockchain.state, newBlock, blockchain.minerReward); err != nil {
+		return atBlock(err, height)
+	}
+	if err := checkStateRoot(blockchain.params, height, blockchain.state, &newBlock); err != nil {
+		return newBlockError(height, err)
+	}
 
-	blockchain.blocks = append(blockchain.blocks, newBlock)
-	blockchain.transationsPool = []Transaction{} //reset transationsPool
+	blockchain.connectBlock(newBlock)
 	return nil
 }
 
-// 验证区块的合法性
+// newBlockTemplate 基于当前链的末端和交易池生成一个待挖的区块
+// 同时返回当前链的快照、挖矿的选项，以及当前末端被替换时会关闭的channel
+func (blockchain *Blockchain) newBlockTemplate(minerRewardAddress string) (Block, ChainReader, SealOptions, <-chan struct{}) {
+	blockchain.mu.RLock()
+	defer blockchain.mu.RUnlock()
+
+	//从transationsPool挑选收益最高的transations来存储到新生成的block
+	transactions := blockchain.assembleBlockTransactions(blockchain.maxBlockSize)
+
+	///生成矿工奖励的transction,矿工除了出块奖励，还能拿到打包进来的交易的手续费
+	reward := blockchain.minerReward
+	for _, t := range transactions {
+		reward += t.fee
+	}
+	minerRewardTransction := Transaction{
+		from:   MinerRewardFromAddress,
+		to:     minerRewardAddress,
+		amount: reward,
+	}
+	transactions = append(transactions, minerRewardTransction)
+
+	newBlock := NewBlock(transactions, blockchain.getLatestBlock().hash)
+	opts := SealOptions{
+		Workers:    blockchain.miningWorkers,
+		NonceRange: blockchain.nonceRange,
+		telemetry:  &blockchain.telemetry,
+		verifier:   blockchain.signatureVerifier(),
+	}
+	return newBlock, blockchain.snapshot(len(blockchain.blocks) - 1), opts, blockchain.tipChanged
+}
+
+// TipChangedNotify 返回一个在链的末端被替换时关闭的channel
+func (blockchain *Blockchain) TipChangedNotify() <-chan struct{} {
+	blockchain.mu.RLock()
+	defer blockchain.mu.RUnlock()
+	return blockchain.tipChanged
+}
+
+// connectBlock 把区块接到链上，更新账本状态，并把上链的交易以及和它们冲突的交易从池子里移除
+// 调用方需要持有链的写锁
+func (blockchain *Blockchain) connectBlock(block Block) {
+	blockchain.blocks = append(blockchain.blocks, block)
+	blockchain.state.applyBlock(block)
+	blockchain.prune(false)
+	blockchain.events.publish(Event{Type: EventBlockConnected, Height: len(blockchain.blocks) - 1, Block: block})
+	for _, t := range block.transactions {
+		blockchain.transationsPool.Remove(t.ID())
+		blockchain.transationsPool.RemoveConflicts(t)
+	}
+	//通知正在挖旧末端的矿工停下来
+	close(blockchain.tipChanged)
+	blockchain.tipChanged = make(chan struct{})
+}
+
+// DisconnectTip 把链末端的区块断开(比如发现它有问题需要回滚)，返回被断开的区块
+// 区块里除矿工奖励之外的交易会放回交易池，放不回去的(比如池子满了、手续费不够)就丢掉；创世区块和最后一个checkpoint及之前的区块不能断开
+func (blockchain *Blockchain) DisconnectTip() (Block, error) {
+	blockchain.mu.Lock()
+	defer blockchain.mu.Unlock()
+
+	height := len(blockchain.blocks) - 1
+	if height == 0 {
+		return Block{}, errors.New("can not disconnect genesis block")
+	}
+	if height <= lastCheckpointHeight(blockchain.checkpoints, len(blockchain.blocks)) {
+		return Block{}, fmt.Errorf("%w: can not disconnect block %d", ErrCheckpointMismatch, height)
+	}
+	//交易已经没了，放不回交易池，账本也没法回滚
+	if height <= blockchain.prunedHeight {
+		return Block{}, fmt.Errorf("%w: can not disconnect block %d", ErrPruned, height)
+	}
+	block := blockchain.blocks[height]
+	//快照可能还引用着原来的底层数组，截断时限制容量，下一次接区块时会重新分配，不会改到快照里的区块
+	blockchain.blocks = blockchain.blocks[:height:height]
+	state := prunedBaseState(blockchain.blocks[0], blockchain.prunedState)
+	for _, b := range blockchain.blocks[blockchain.prunedHeight+1:] {
+		state.applyBlock(b)
+	}
+	blockchain.state = state
+	close(blockchain.tipChanged)
+	blockchain.tipChanged = make(chan struct{})
+	blockchain.events.publish(Event{Type: EventBlockDisconnected, Height: height, Block: block})
+
+	for _, t := range block.transactions {
+		if t.from == MinerRewardFromAddress {
+			continue
+		}
+		blockchain.transationsPool.Add(t)
+	}
+	return block, nil
+}
+
+// IsValidChain 验证区块的合法性，想知道具体哪里不合法的话用ValidateChain
 func (blockchain *Blockchain) IsValidChain() bool {
-	if len(blockchain.blocks) == 1 {
-		//通过区块的hash值，验证内容和hash值有无被篡改
-		if blockchain.blocks[0].hash != string(blockchain.blocks[0].computeHash()) {
-			fmt.Println("祖先区块被篡改了!")
-			return false
+	return blockchain.ValidateChain() == nil
+}
+
+// ValidateChain 从创世区块开始逐个校验区块，返回遇到的第一个问题，是一个带区块高度(和交易id)的*ValidationError
+func (blockchain *Blockchain) ValidateChain() error {
+	//区块一旦上链就不会再被修改，拿到当前链的快照之后就可以不持有锁慢慢校验了
+	blockchain.mu.RLock()
+	blocks := blockchain.blocks
+	checkpoints := blockchain.checkpoints
+	params := blockchain.params
+	minerReward := blockchain.minerReward
+	verifier := blockchain.signatureVerifier()
+	prunedHeight := blockchain.prunedHeight
+	prunedState := blockchain.prunedState
+	blockchain.mu.RUnlock()
+
+	//通过区块的hash值，验证内容和hash值有无被篡改
+	if blocks[0].hash != blocks[0].computeHash() {
+		return newBlockError(0, ErrTamperedBlock)
+	}
+
+	//和checkpoint对不上的链直接拒绝
+	for i := range blocks {
+		if err := checkCheckpoint(checkpoints, i, blocks[i].hash); err != nil {
+			return newBlockError(i, err)
 		}
-		return true
 	}
+	//最后一个checkpoint之前的区块已经是可信的了，不用再挨个校验签名
+	lastCheckpointHeight := lastCheckpointHeight(checkpoints, len(blocks))
+	//验签名最花时间，先把之后所有区块的交易放在一起并行地验完，下面再按区块的顺序看结果
+	var transactions []Transaction
+	for i := max(lastCheckpointHeight+1, 1); i < len(blocks); i++ {
+		transactions = append(transactions, blocks[i].transactions...)
+	}
+	sigErrs := verifier.verify(transactions)
 
-	for i := 1; i < len(blockchain.blocks); i++ {
-		block := blockchain.blocks[i]
+	//创世区块里的预挖和验证者锁定权益的交易不用校验，直接记到账本上；裁剪过的话从裁剪点的账本状态开始
+	state := prunedBaseState(blocks[0], prunedState)
+	for i := 1; i < len(blocks); i++ {
+		block := blocks[i]
 		//检验当前数据是否有无被篡改
-		if block.hash != string(block.computeHash()) {
-			fmt.Printf("区块 %d 被篡改了!\n", i)
-			return false
+		if block.hash != block.computeHash() {
+			return newBlockError(i, ErrTamperedBlock)
 		}
 		//通过prevHash来判断是否断链
-		prevBlockHash := blockchain.blocks[i-1].hash
-		if block.prevHash != prevBlockHash {
-			fmt.Printf("区块 %d 断联了!\n", i)
-			return false
+		if block.prevHash != blocks[i-1].hash {
+			return newBlockError(i, ErrBrokenLink)
+		}
+		//区块必须是按共识规则产出的
+		if err := blockchain.engine.VerifySeal(&chainSnapshot{params: params, blocks: blocks[:i]}, &block); err != nil {
+			return newBlockError(i, err)
+		}
+		//交易被裁剪掉的区块只能校验区块头
+		if i <= prunedHeight {
+			continue
 		}
 
 		//还需要验证 链里面的每一个区块是否被篡改了
-		if !block.validateBlockTransations() {
-			fmt.Printf("发现链里面有非法交易,异常block idx: %d\n", i)
-			return false
+		var blockSigErrs []error
+		if i > lastCheckpointHeight {
+			blockSigErrs, sigErrs = sigErrs[:len(block.transactions)], sigErrs[len(block.transactions):]
+		}
+		if err := block.validateBlockTransations(blockSigErrs); err != nil {
+			return atBlock(err, i)
 		}
+		if err := validateBlockAgainstState(state, block, minerReward); err != nil {
+			return atBlock(err, i)
+		}
+		if err := checkStateRoot(params, i, state, &block); err != nil {
+			return newBlockError(i, err)
+		}
+		state.applyBlock(block)
 	}
 
-	return true
+	return nil
 }
@@ -0,0 +1,99 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MaxFutureBlockTime 外部区块的时间戳最多能比本地时间超前多少，防止有人用未来的时间戳提前出块
+const MaxFutureBlockTime = 2 * time.Hour
+
+// ErrKnownBlock 区块已经在链上了，同步时重复收到同一个区块是正常的
+var ErrKnownBlock = errors.New("block already in chain")
+
+// ProcessBlock 校验一个外部产出的区块(从别的节点同步来的、外部矿工挖的)，通过的话接到链的末端，并把它的交易移出交易池
+// 依次检查：hash和内容是否一致、交易签名等不依赖链上状态的规则、时间戳、能否接上链的末端、共识规则(工作量或者签名)、checkpoint，
+// 最后按账本状态检查nonce、权益、矿工奖励和状态承诺。校验失败返回带区块高度的*ValidationError；
+// 交易被裁剪掉的区块返回ErrPruned；区块已经在链上返回ErrKnownBlock，父区块在链上但已经不是末端(分叉)返回ErrStaleTip
+func (blockchain *Blockchain) ProcessBlock(block Block) error {
+	blockchain.mu.RLock()
+	height := len(blockchain.blocks)
+	verifier := blockchain.signatureVerifier()
+	blockchain.mu.RUnlock()
+
+	//只有区块头、交易被裁剪掉的区块没法校验交易，也没法记到账本上
+	if block.prunedRoot != nil {
+		return newBlockError(height, ErrPruned)
+	}
+	//签名校验比较慢，先做不需要持有锁的检查
+	if block.hash != block.computeHash() {
+		return newBlockError(height, ErrTamperedBlock)
+	}
+	if err := block.validateBlockTransations(verifier.verify(block.transactions)); err != nil {
+		return atBlock(err, height)
+	}
+	if limit := uint64(time.Now().Add(MaxFutureBlockTime).Unix()); block.timestamp > limit {
+		return newBlockError(height, fmt.Errorf("%w: timestamp %d too far in the future", ErrInvalidBlockTimestamp, block.timestamp))
+	}
+
+	blockchain.mu.RLock()
+	height = len(blockchain.blocks)
+	parent := blockchain.getLatestBlock()
+	if err := blockchain.checkParent(block); err != nil {
+		blockchain.mu.RUnlock()
+		return err
+	}
+	chain := blockchain.snapshot(height - 1)
+	blockchain.mu.RUnlock()
+
+	if block.timestamp < parent.timestamp {
+		return newBlockError(height, fmt.Errorf("%w: timestamp %d before parent %d", ErrInvalidBlockTimestamp, block.timestamp, parent.timestamp))
+	}
+	if err := blockchain.engine.VerifySeal(chain, &block); err != nil {
+		return newBlockError(height, err)
+	}
+
+	blockchain.mu.Lock()
+	defer blockchain.mu.Unlock()
+	//校验期间别的区块先接上来了
+	if err := blockchain.checkParent(block); err != nil {
+		return err
+	}
+	if err := blockchain.checkCheckpoint(height, block.hash); err != nil {
+		return newBlockError(height, err)
+	}
+	if err := validateBlockAgainstState(blockchain.state, block, blockchain.minerReward); err != nil {
+		return atBlock(err, height)
+	}
+	if err := checkStateRoot(blockchain.params, height, blockchain.state, &block); err != nil {
+		return newBlockError(height, err)
+	}
+	blockchain.connectBlock(block)
+	return nil
+}
+
+// checkParent 区块必须接在链的末端上，调用方需要持有链的锁
+func (blockchain *Blockchain) checkParent(block Block) error {
+	if block.prevHash == blockchain.getLatestBlock().hash {
+		return nil
+	}
+	if blockchain.hasBlock(block.hash) {
+		return fmt.Errorf("%w: %s", ErrKnownBlock, block.hash)
+	}
+	//父区块在链上但不是末端，说明区块接在一个旧的末端上，暂时不支持切换到分叉
+	if blockchain.hasBlock(block.prevHash) {
+		return fmt.Errorf("%w: block %s builds on %s", ErrStaleTip, block.hash, block.prevHash)
+	}
+	return newBlockError(len(blockchain.blocks), ErrBrokenLink)
+}
+
+// hasBlock 调用方需要持有链的锁
+func (blockchain *Blockchain) hasBlock(hash string) bool {
+	for i := len(blockchain.blocks) - 1; i >= 0; i-- {
+		if blockchain.blocks[i].hash == hash {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package blockchain
+
+import (
+	"CcCoin-go-version/internal/encryption"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// blockKeys 本节点持有的出块密钥：公钥 -> 私钥，权威证明和权益证明这种靠签名出块的共识引擎共用
+type blockKeys struct {
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+// Authorize 让本节点持有一个出块者的密钥，轮到这个出块者的时候本节点就用它出块
+func (k *blockKeys) Authorize(address, privateKey string) error {
+	//先签一下名，确认私钥和公钥是一对
+	signature, err := encryption.SignMessage(privateKey, address)
+	if err != nil {
+		return err
+	}
+	if ok, err := encryption.VerifySignature(address, address, signature); err != nil || !ok {
+		return errors.New("signer private key does not match address")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.keys == nil {
+		k.keys = map[string]string{}
+	}
+	k.keys[address] = privateKey
+	return nil
+}
+
+func (k *blockKeys) privateKey(address string) (string, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	privateKey, ok := k.keys[address]
+	return privateKey, ok
+}
+
+// signBlock 等到区块的时间戳之后，用出块者的私钥对区块hash签名
+func signBlock(ctx context.Context, block *Block, privateKey string, verifier signatureVerifier) error {
+	if err := block.validateBlockTransations(verifier.verify(block.transactions)); err != nil {
+		return err
+	}
+
+	if wait := time.Until(time.Unix(int64(block.timestamp), 0)); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-timer.C:
+		}
+	}
+
+	block.hash = block.computeHash()
+	signature, err := encryption.SignMessage(privateKey, block.hash)
+	if err != nil {
+		return err
+	}
+	block.signature = signature
+	return nil
+}
+
+// verifyBlockSignature 校验区块的hash和出块者的签名
+func verifyBlockSignature(block *Block) error {
+	if block.hash != block.computeHash() {
+		return fmt.Errorf("%w: hash does not match block content", ErrInvalidBlockSignature)
+	}
+	if block.signer == "" {
+		return fmt.Errorf("%w: missing signer", ErrInvalidBlockSignature)
+	}
+	if ok, err := encryption.VerifySignature(block.signer, block.hash, block.signature); err != nil || !ok {
+		return fmt.Errorf("%w: block %s", ErrInvalidBlockSignature, block.hash)
+	}
+	return nil
+}
+
+// nextBlockTimestamp 出块时间取当前时间，但离父区块不能小于出块周期
+func nextBlockTimestamp(chain ChainReader) uint64 {
+	parent, _ := chain.GetBlock(chain.Height())
+	timestamp := uint64(time.Now().Unix())
+	if earliest := parent.timestamp + chain.Params().Genesis.Period; timestamp < earliest {
+		timestamp = earliest
+	}
+	return timestamp
+}
+
+// verifyBlockTimestamp 校验区块离父区块不小于出块周期
+func verifyBlockTimestamp(chain ChainReader, block *Block) error {
+	parent, _ := chain.GetBlock(chain.Height())
+	if block.timestamp < parent.timestamp+chain.Params().Genesis.Period {
+		return fmt.Errorf("%w: block %d produced before the end of the period", ErrInvalidBlockTimestamp, chain.Height()+1)
+	}
+	return nil
+}
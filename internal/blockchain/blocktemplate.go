@@ -0,0 +1,309 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// maxBlockTemplates TemplateStore默认最多保留多少个发出去的区块模板，更早的模板提交上来会被当作未知模板
+const maxBlockTemplates = 64
+
+var (
+	ErrUnknownTemplate           = errors.New("unknown block template")
+	ErrExternalMiningUnsupported = errors.New("consensus engine does not support external mining")
+)
+
+// TransactionInfo 交易对外展示的信息
+type TransactionInfo struct {
+	ID     string  `json:"id"`
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Amount float64 `json:"amount"`
+	Fee    float64 `json:"fee"`
+	Nonce  uint64  `json:"nonce"`
+
+	ExtraNonce uint64 `json:"extraNonce,omitempty"` //只有矿工奖励交易才有
+	Signature  string `json:"signature,omitempty"`  //矿工奖励交易没有签名
+}
+
+func (t *Transaction) Info() TransactionInfo {
+	return TransactionInfo{
+		ID:     t.ID(),
+		From:   t.from,
+		To:     t.to,
+		Amount: t.amount,
+		Fee:    t.fee,
+		Nonce:  t.nonce,
+
+		ExtraNonce: t.extraNonce,
+		Signature:  t.signature,
+	}
+}
+
+func (info TransactionInfo) transaction() Transaction {
+	return Transaction{
+		from:       info.From,
+		to:         info.To,
+		amount:     info.Amount,
+		fee:        info.Fee,
+		nonce:      info.Nonce,
+		extraNonce: info.ExtraNonce,
+		signature:  info.Signature,
+	}
+}
+
+// BlockInfo 区块对外展示的信息
+type BlockInfo struct {
+	Height       int               `json:"height"`
+	Hash         string            `json:"hash"`
+	PrevHash     string            `json:"prevHash"`
+	Timestamp    uint64            `json:"timestamp"`
+	Nonce        uint32            `json:"nonce"`
+	Message      string            `json:"message,omitempty"`
+	Transactions []TransactionInfo `json:"transactions"` //最后一笔是矿工奖励
+
+	//交易被裁剪掉的区块Transactions为空，BodyRoot是交易等内容(状态承诺除外)的hash，有了它还能校验区块的hash
+	Pruned   bool   `json:"pruned,omitempty"`
+	BodyRoot string `json:"bodyRoot,omitempty"`
+	//高度是SnapshotInterval的倍数的区块才有，应用这个区块之后的账本状态的hash
+	StateRoot string `json:"stateRoot,omitempty"`
+
+	//只有权威证明和权益证明的区块才有
+	Extra         string           `json:"extra,omitempty"`
+	Signer        string           `json:"signer,omitempty"`
+	Signature     string           `json:"signature,omitempty"`
+	Vote          string           `json:"vote,omitempty"`
+	VoteAuthorize bool             `json:"voteAuthorize,omitempty"`
+	Slashings     []DoubleSignInfo `json:"slashings,omitempty"`
+}
+
+// DoubleSignInfo 双签证据对外展示的信息
+type DoubleSignInfo struct {
+	First  BlockInfo `json:"first"`
+	Second BlockInfo `json:"second"`
+}
+
+// Info 区块本身不记录高度，需要调用方传进来
+func (block *Block) Info(height int) BlockInfo {
+	info := BlockInfo{
+		Height:       height,
+		Hash:         block.hash,
+		PrevHash:     block.prevHash,
+		Timestamp:    block.timestamp,
+		Nonce:        block.nonce,
+		Message:      block.message,
+		Transactions: make([]TransactionInfo, 0, len(block.transactions)),
+		StateRoot:    block.stateRoot,
+
+		Extra:         block.extra,
+		Signer:        block.signer,
+		Signature:     block.signature,
+		Vote:          block.vote,
+		VoteAuthorize: block.voteAuthorize,
+	}
+	if block.prunedRoot != nil {
+		info.Pruned = true
+		info.BodyRoot = hex.EncodeToString(block.prunedRoot[:])
+	}
+	for _, t := range block.transactions {
+		info.Transactions = append(info.Transactions, t.Info())
+	}
+	for _, evidence := range block.slashings {
+		//证据里的区块不在这条链上，高度没有意义
+		info.Slashings = append(info.Slashings, DoubleSignInfo{
+			First:  evidence.First.Info(-1),
+			Second: evidence.Second.Info(-1),
+		})
+	}
+	return info
+}
+
+// NewBlockFromInfo 按对外展示的信息还原出区块，比如从别的节点收到的区块，Height会被忽略
+// 不做任何校验，Hash也原样保留，内容被改过的话交给ProcessBlock去发现；Pruned的话还原出来的是只有区块头的区块
+func NewBlockFromInfo(info BlockInfo) Block {
+	block := Block{
+		prevHash:      info.PrevHash,
+		hash:          info.Hash,
+		nonce:         info.Nonce,
+		timestamp:     info.Timestamp,
+		message:       info.Message,
+		stateRoot:     info.StateRoot,
+		extra:         info.Extra,
+		signer:        info.Signer,
+		signature:     info.Signature,
+		vote:          info.Vote,
+		voteAuthorize: info.VoteAuthorize,
+	}
+	if info.Pruned {
+		var root [sha256.Size]byte
+		decoded, _ := hex.DecodeString(info.BodyRoot)
+		copy(root[:], decoded)
+		block.prunedRoot = &root
+	}
+	for _, t := range info.Transactions {
+		block.transactions = append(block.transactions, t.transaction())
+	}
+	for _, evidence := range info.Slashings {
+		block.slashings = append(block.slashings, DoubleSign{
+			First:  NewBlockFromInfo(evidence.First),
+			Second: NewBlockFromInfo(evidence.Second),
+		})
+	}
+	return block
+}
+
+// BlockTemplate 发给外部矿工的区块模板
+// 矿工不需要知道区块的内部格式：Header是76字节的区块头，最后4个字节是大端的nonce(见HeaderNonceOffset)，
+// 填上nonce之后用Algorithm算hash(见PowHash)，算出来的十六进制hash以Target开头就算挖到了，然后把TemplateID和nonce提交回来
+// sha256的话区块头的前64字节不变，可以预先算好中间状态；scrypt算法的参数是N=1024、r=1、p=1，密码和盐都是区块头，输出32字节
+type BlockTemplate struct {
+	TemplateID   string            `json:"templateId"`
+	Height       int               `json:"height"`
+	PrevHash     string            `json:"prevHash"`
+	Timestamp    uint64            `json:"timestamp"`
+	Difficulty   int               `json:"difficulty"`
+	Target       string            `json:"target"`
+	Algorithm    string            `json:"algorithm"`
+	Header       string            `json:"header"`       //十六进制，nonce为0
+	Transactions []TransactionInfo `json:"transactions"` //最后一笔是矿工奖励
+	StateRoot    string            `json:"stateRoot,omitempty"`
+}
+
+// TemplateStore 发出去的区块模板：模板id -> 还没填nonce的区块，按发出的顺序最多保留limit个
+// 不同的使用方(比如getblocktemplate接口和矿池)各用各的TemplateStore，一方请求得再多也挤不掉另一方的模板
+// 零值可以直接用，最多保留maxBlockTemplates个
+type TemplateStore struct {
+	mu     sync.Mutex
+	limit  int
+	blocks map[string]Block
+	order  []string //模板id，按发出的顺序，用来限制数量
+}
+
+// NewTemplateStore limit不大于0的话用默认的maxBlockTemplates
+func NewTemplateStore(limit int) *TemplateStore {
+	return &TemplateStore{limit: limit}
+}
+
+func (templates *TemplateStore) add(id string, block Block) {
+	templates.mu.Lock()
+	defer templates.mu.Unlock()
+	if templates.blocks == nil {
+		templates.blocks = map[string]Block{}
+	}
+	if _, ok := templates.blocks[id]; ok {
+		return
+	}
+	templates.blocks[id] = block
+	templates.order = append(templates.order, id)
+	limit := templates.limit
+	if limit <= 0 {
+		limit = maxBlockTemplates
+	}
+	if len(templates.order) > limit {
+		delete(templates.blocks, templates.order[0])
+		templates.order = templates.order[1:]
+	}
+}
+
+func (templates *TemplateStore) get(id string) (Block, bool) {
+	templates.mu.Lock()
+	defer templates.mu.Unlock()
+	block, ok := templates.blocks[id]
+	return block, ok
+}
+
+// NewBlockTemplate 基于当前链的末端和交易池生成一个区块模板，交给外部矿工去挖，只有工作量证明支持
+// 模板存在链自带的TemplateStore里，用SubmitBlock提交
+func (blockchain *Blockchain) NewBlockTemplate(minerRewardAddress string) (BlockTemplate, error) {
+	return blockchain.NewBlockTemplateIn(&blockchain.templates, minerRewardAddress)
+}
+
+// NewBlockTemplateIn 和NewBlockTemplate一样，只是模板存在调用方自己的store里，之后也要从这个store提交
+func (blockchain *Blockchain) NewBlockTemplateIn(store *TemplateStore, minerRewardAddress string) (BlockTemplate, error) {
+	if minerRewardAddress == MinerRewardFromAddress {
+		return BlockTemplate{}, errors.New("miner reward address is required")
+	}
+	if _, ok := blockchain.engine.(*ProofOfWork); !ok {
+		return BlockTemplate{}, ErrExternalMiningUnsupported
+	}
+
+	newBlock, chain, _, _ := blockchain.newBlockTemplate(minerRewardAddress)
+	if err := blockchain.engine.Prepare(chain, &newBlock); err != nil {
+		return BlockTemplate{}, err
+	}
+	if err := blockchain.commitState(&newBlock); err != nil {
+		return BlockTemplate{}, err
+	}
+	newBlock.nonce = 0
+	newBlock.hash = newBlock.computeHash()
+	store.add(newBlock.hash, newBlock)
+
+	difficulty := blockchain.engine.CalcDifficulty(chain)
+	algorithm := chain.Params().PowAlgorithm
+	if algorithm == "" {
+		algorithm = PowAlgorithmSHA256
+	}
+	header := newBlock.header()
+	template := BlockTemplate{
+		TemplateID:   newBlock.hash,
+		Height:       chain.Height() + 1,
+		PrevHash:     newBlock.prevHash,
+		Timestamp:    newBlock.timestamp,
+		Difficulty:   difficulty,
+		Target:       newBlock.getAnswer(difficulty),
+		Algorithm:    algorithm,
+		Header:       hex.EncodeToString(header[:]),
+		Transactions: make([]TransactionInfo, 0, len(newBlock.transactions)),
+		StateRoot:    newBlock.stateRoot,
+	}
+	for _, t := range newBlock.transactions {
+		template.Transactions = append(template.Transactions, t.Info())
+	}
+	return template, nil
+}
+
+// SubmitBlock 外部矿工提交挖到的nonce，按ProcessBlock校验之后把区块接到链上，返回区块的hash
+// 模板生成之后链的末端变了的话返回ErrStaleTip，工作量不满足难度的话返回ErrInvalidProofOfWork
+func (blockchain *Blockchain) SubmitBlock(templateID string, nonce uint32) (string, error) {
+	return blockchain.SubmitBlockWithExtraNonce(&blockchain.templates, templateID, 0, nonce)
+}
+
+// templateWithExtraNonce 把store里模板的矿工奖励交易的extra nonce换成extraNonce，模板里原本的extra nonce是0
+func templateWithExtraNonce(store *TemplateStore, templateID string, extraNonce uint64) (Block, error) {
+	newBlock, ok := store.get(templateID)
+	if !ok {
+		return Block{}, fmt.Errorf("%w: %s", ErrUnknownTemplate, templateID)
+	}
+	if err := newBlock.setExtraNonce(extraNonce); err != nil {
+		return Block{}, err
+	}
+	return newBlock, nil
+}
+
+// TemplateHeader store里的模板的矿工奖励交易填上extraNonce之后的区块头，十六进制，nonce为0
+// 矿池给每个矿工分配不同的extra nonce，大家的区块头各不相同，不会在同一个nonce空间里重复劳动
+func (blockchain *Blockchain) TemplateHeader(store *TemplateStore, templateID string, extraNonce uint64) (string, error) {
+	newBlock, err := templateWithExtraNonce(store, templateID, extraNonce)
+	if err != nil {
+		return "", err
+	}
+	header := newBlock.header()
+	return hex.EncodeToString(header[:]), nil
+}
+
+// SubmitBlockWithExtraNonce 和SubmitBlock一样，只是模板从store里找，区块的矿工奖励交易带上挖矿时用的extraNonce(见TemplateHeader)
+func (blockchain *Blockchain) SubmitBlockWithExtraNonce(store *TemplateStore, templateID string, extraNonce uint64, nonce uint32) (string, error) {
+	newBlock, err := templateWithExtraNonce(store, templateID, extraNonce)
+	if err != nil {
+		return "", err
+	}
+	newBlock.nonce = nonce
+	newBlock.hash = newBlock.computeHash()
+	if err := blockchain.ProcessBlock(newBlock); err != nil {
+		return "", err
+	}
+	return newBlock.hash, nil
+}
@@ -0,0 +1,56 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrCheckpointMismatch = errors.New("block conflicts with checkpoint")
+
+// Checkpoint 写死在链参数里的可信区块，高度为Height的区块的hash必须是Hash
+// 最后一个checkpoint之前的区块不用再校验签名，和checkpoint冲突的分叉直接拒绝
+type Checkpoint struct {
+	Height int
+	Hash   string
+}
+
+// SetCheckpoints 设置链的checkpoint
+func (blockchain *Blockchain) SetCheckpoints(checkpoints []Checkpoint) {
+	blockchain.mu.Lock()
+	defer blockchain.mu.Unlock()
+	blockchain.setCheckpoints(checkpoints)
+}
+
+func (blockchain *Blockchain) setCheckpoints(checkpoints []Checkpoint) {
+	sorted := append([]Checkpoint{}, checkpoints...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Height < sorted[j].Height
+	})
+	blockchain.checkpoints = sorted
+}
+
+// checkCheckpoint 高度为height的区块如果有checkpoint，hash必须和checkpoint一致
+func (blockchain *Blockchain) checkCheckpoint(height int, hash string) error {
+	return checkCheckpoint(blockchain.checkpoints, height, hash)
+}
+
+func checkCheckpoint(checkpoints []Checkpoint, height int, hash string) error {
+	for _, checkpoint := range checkpoints {
+		if checkpoint.Height == height && checkpoint.Hash != hash {
+			return fmt.Errorf("%w: height %d hash %s, want %s", ErrCheckpointMismatch, height, hash, checkpoint.Hash)
+		}
+	}
+	return nil
+}
+
+// lastCheckpointHeight 长度为chainLength的链上已经到达的最后一个checkpoint的高度，没有的话返回-1
+func lastCheckpointHeight(checkpoints []Checkpoint, chainLength int) int {
+	last := -1
+	for _, checkpoint := range checkpoints {
+		if checkpoint.Height < chainLength {
+			last = checkpoint.Height
+		}
+	}
+	return last
+}
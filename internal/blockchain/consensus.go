@@ -0,0 +1,91 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// 内置的共识引擎
+const (
+	ConsensusProofOfWork      = "pow"
+	ConsensusProofOfAuthority = "poa"
+	ConsensusProofOfStake     = "pos"
+)
+
+// ChainReader 共识引擎读取链的接口
+// 传给引擎的chain都是"待处理区块的父区块为末端"的链，所以待处理区块的高度就是chain.Height()+1
+type ChainReader interface {
+	Params() ChainParams
+	Height() int
+	GetBlock(height int) (Block, bool)
+}
+
+// SealOptions 封装区块时的选项
+type SealOptions struct {
+	Workers    int    //并行的goroutine数，对工作量证明有用
+	NonceRange uint64 //每个extra nonce下尝试的nonce个数，0表示整个nonce空间，对工作量证明有用
+	telemetry  *miningTelemetry
+	verifier   signatureVerifier //打包前校验交易签名用
+}
+
+// ConsensusEngine 共识引擎：决定谁有权出块、怎么证明区块是合法产出的，以及分叉时哪条链更重
+// 默认是工作量证明(ProofOfWork)，也可以换成别的实现
+type ConsensusEngine interface {
+	Name() string
+	// Prepare 在封装之前填好区块头里和共识相关的字段
+	Prepare(chain ChainReader, block *Block) error
+	// Seal 封装区块，比如工作量证明就是寻找满足难度的nonce，ctx被取消时应立刻返回
+	Seal(ctx context.Context, chain ChainReader, block *Block, opts SealOptions) error
+	// VerifySeal 校验区块的封装是否合法
+	VerifySeal(chain ChainReader, block *Block) error
+	// CalcDifficulty 计算接在chain末端的下一个区块的难度
+	CalcDifficulty(chain ChainReader) int
+	// Weight 区块对链的权重的贡献，分叉的时候权重大的链胜出
+	Weight(chain ChainReader, block *Block) *big.Int
+}
+
+// NewConsensusEngine 根据链参数创建共识引擎
+func NewConsensusEngine(params ChainParams) (ConsensusEngine, error) {
+	switch params.Consensus {
+	case "", ConsensusProofOfWork:
+		if _, err := powHasherFor(params.PowAlgorithm); err != nil {
+			return nil, err
+		}
+		return NewProofOfWork(), nil
+	case ConsensusProofOfAuthority:
+		if len(params.Genesis.Signers) == 0 {
+			return nil, errors.New("proof of authority requires at least one genesis signer")
+		}
+		return NewProofOfAuthority(), nil
+	case ConsensusProofOfStake:
+		if len(params.Genesis.Stakes) == 0 {
+			return nil, errors.New("proof of stake requires at least one genesis stake")
+		}
+		return NewProofOfStake(), nil
+	default:
+		return nil, fmt.Errorf("unknown consensus engine %q", params.Consensus)
+	}
+}
+
+// chainSnapshot 链在某一时刻的只读快照，区块一旦上链就不会被修改，所以不需要加锁
+type chainSnapshot struct {
+	params ChainParams
+	blocks []Block
+}
+
+func (snapshot *chainSnapshot) Params() ChainParams {
+	return snapshot.params
+}
+
+func (snapshot *chainSnapshot) Height() int {
+	return len(snapshot.blocks) - 1
+}
+
+func (snapshot *chainSnapshot) GetBlock(height int) (Block, bool) {
+	if height < 0 || height >= len(snapshot.blocks) {
+		return Block{}, false
+	}
+	return snapshot.blocks[height], true
+}
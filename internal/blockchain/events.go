@@ -0,0 +1,125 @@
+package blockchain
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// EventType 链上事件的类型
+type EventType string
+
+const (
+	EventBlockConnected    EventType = "blockConnected"    //新区块接到了链的末端
+	EventBlockDisconnected EventType = "blockDisconnected" //链末端的区块被断开了
+	EventTxAccepted        EventType = "txAccepted"        //交易进了交易池
+	EventTxEvicted         EventType = "txEvicted"         //交易没上链就被移出了交易池，原因见Event.Reason
+)
+
+// 交易被移出交易池的原因
+const (
+	EvictReasonExpired   = "expired"   //在池子里待得太久过期了
+	EvictReasonSizeLimit = "sizeLimit" //池子满了，费率太低被驱逐
+	EvictReasonReplaced  = "replaced"  //被花同一份钱、费率更高的交易替换了
+	EvictReasonConflict  = "conflict"  //花同一份钱的另一笔交易上链了
+)
+
+// DefaultEventBufferSize 订阅默认能缓存的事件数
+const DefaultEventBufferSize = 256
+
+// Event 链上事件，区块事件带Height和Block，交易事件带Transaction
+type Event struct {
+	Type        EventType
+	Height      int
+	Block       Block
+	Transaction Transaction
+	Reason      string //交易被移出交易池的原因，只有EventTxEvicted才有
+}
+
+// Subscription 一个事件订阅，从Events()里读事件
+// 缓存是有限的：订阅者来不及读、缓存满了的话新事件会被丢掉(计入Dropped)，不会拖慢出块和交易进池
+type Subscription struct {
+	feed    *eventFeed
+	events  chan Event
+	types   map[EventType]bool //只关心这些类型的事件，为空表示全部
+	dropped atomic.Uint64
+}
+
+// Events 返回接收事件的channel，取消订阅后会被关闭
+func (sub *Subscription) Events() <-chan Event {
+	return sub.events
+}
+
+// Dropped 返回因为缓存满了而丢掉的事件数，不为0说明订阅者漏了事件，需要自己去链上补
+func (sub *Subscription) Dropped() uint64 {
+	return sub.dropped.Load()
+}
+
+// Unsubscribe 取消订阅，可以重复调用
+func (sub *Subscription) Unsubscribe() {
+	sub.feed.unsubscribe(sub)
+}
+
+// eventFeed 把事件分发给所有订阅者，发送都不阻塞，可以在持有链或者交易池的锁时调用
+type eventFeed struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func newEventFeed() *eventFeed {
+	return &eventFeed{subs: map[*Subscription]struct{}{}}
+}
+
+func (feed *eventFeed) subscribe(bufferSize int, types []EventType) *Subscription {
+	if bufferSize <= 0 {
+		bufferSize = DefaultEventBufferSize
+	}
+	sub := &Subscription{
+		feed:   feed,
+		events: make(chan Event, bufferSize),
+	}
+	if len(types) > 0 {
+		sub.types = map[EventType]bool{}
+		for _, eventType := range types {
+			sub.types[eventType] = true
+		}
+	}
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	feed.subs[sub] = struct{}{}
+	return sub
+}
+
+func (feed *eventFeed) unsubscribe(sub *Subscription) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	if _, ok := feed.subs[sub]; !ok {
+		return
+	}
+	delete(feed.subs, sub)
+	close(sub.events)
+}
+
+// publish feed为nil时什么都不做，单独使用的交易池没有订阅者
+func (feed *eventFeed) publish(event Event) {
+	if feed == nil {
+		return
+	}
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	for sub := range feed.subs {
+		if sub.types != nil && !sub.types[event.Type] {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribe 订阅链上事件，bufferSize是最多缓存的事件数(不大于0的话用DefaultEventBufferSize)，types为空表示订阅所有类型
+// 同一个goroutine里产生的事件按发生的顺序送达；用完之后要调用Unsubscribe
+func (blockchain *Blockchain) Subscribe(bufferSize int, types ...EventType) *Subscription {
+	return blockchain.events.subscribe(bufferSize, types)
+}
@@ -0,0 +1,138 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// 默认创世区块的参数
+const (
+	DefaultGenesisTimestamp = 1720000000 //2024-07-03 09:46:40 UTC
+	DefaultGenesisMessage   = "CcCoin genesis block"
+	DefaultMinerReward      = 50
+)
+
+var ErrGenesisMismatch = errors.New("genesis hash mismatch")
+
+// GenesisConfig 创世区块的配置，一般从配置文件里读出来
+// 创世区块的内容完全由配置决定，不依赖启动时间，所以同一份配置在任何节点上算出来的创世hash都一样
+type GenesisConfig struct {
+	Timestamp   uint64             `json:"timestamp"`   //固定的创世时间
+	Difficulty  int                `json:"difficulty"`  //初始挖矿难度
+	MinerReward float64            `json:"minerReward"` //出块奖励
+	Message     string             `json:"message"`     //写进创世区块的信息
+	Allocations map[string]float64 `json:"allocations"` //预挖：地址 -> 初始余额
+	Stakes      map[string]float64 `json:"stakes"`      //权益证明的初始验证者：地址 -> 从预挖的余额里锁定的权益
+	Signers     []string           `json:"signers"`     //权威证明的初始签名者(公钥)，会写进创世区块
+	Period      uint64             `json:"period"`      //权威证明两个区块之间至少间隔多少秒
+	Hash        string             `json:"hash"`        //期望的创世hash，不为空时启动会校验
+}
+
+func DefaultGenesisConfig() GenesisConfig {
+	return GenesisConfig{
+		Timestamp:   DefaultGenesisTimestamp,
+		Difficulty:  5,
+		MinerReward: DefaultMinerReward,
+		Message:     DefaultGenesisMessage,
+		Allocations: map[string]float64{},
+	}
+}
+
+// LoadGenesisConfig 从json文件读取创世配置，没填的字段使用默认值
+func LoadGenesisConfig(path string) (GenesisConfig, error) {
+	config := DefaultGenesisConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("parse genesis config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// rules 创世区块承诺的共识规则：初始难度、出块奖励、出块间隔和工作量证明的hash算法
+// 写进创世区块的信息里，规则不一样的两条链创世hash也不一样，节点不会把它们当成同一条链
+func (config GenesisConfig) rules(powAlgorithm string) string {
+	if powAlgorithm == "" {
+		powAlgorithm = PowAlgorithmSHA256
+	}
+	return fmt.Sprintf("difficulty=%d;minerReward=%v;period=%d;pow=%s", config.Difficulty, config.MinerReward, config.Period, powAlgorithm)
+}
+
+// Block 根据配置生成创世区块，预挖的余额以矿工奖励交易的形式写进区块，按地址排序保证结果确定
+// 初始权益以验证者转给StakeAddress的交易写在预挖之后，创世区块里的交易不校验签名
+// powAlgorithm是链参数里工作量证明的hash算法，和配置里的共识规则一起写在Message后面
+// 这里不检查配置，锁定的权益超过预挖余额这类问题由Validate拒绝，创建链的时候会先调用它
+func (config GenesisConfig) Block(powAlgorithm string) Block {
+	transactions := make([]Transaction, 0, len(config.Allocations)+len(config.Stakes))
+	for _, address := range sortedAddresses(config.Allocations) {
+		transactions = append(transactions, Transaction{
+			from:   MinerRewardFromAddress,
+			to:     address,
+			amount: config.Allocations[address],
+		})
+	}
+	for _, address := range sortedAddresses(config.Stakes) {
+		transactions = append(transactions, Transaction{
+			from:   address,
+			to:     StakeAddress,
+			amount: config.Stakes[address],
+		})
+	}
+
+	genesisBlock := Block{
+		transactions: transactions,
+		prevHash:     "0",
+		timestamp:    config.Timestamp,
+		message:      config.Message + "\n" + config.rules(powAlgorithm),
+		extra:        strings.Join(config.Signers, ","),
+	}
+	genesisBlock.hash = genesisBlock.computeHash()
+	return genesisBlock
+}
+
+// Validate 配置里写了期望的创世hash的话，和实际算出来的比较，不一致说明两个节点不在同一条链上
+// powAlgorithm是链参数里工作量证明的hash算法，创世hash承诺了它
+func (config GenesisConfig) Validate(powAlgorithm string) error {
+	if config.Difficulty < 0 {
+		return fmt.Errorf("invalid genesis difficulty %d", config.Difficulty)
+	}
+	//写成!(x >= 0)这样的形式，NaN也会被拒绝；锁定的权益不能超过预挖的余额，否则创世之后余额就是负的
+	for address, amount := range config.Allocations {
+		if address == MinerRewardFromAddress || !(amount >= 0) || math.IsInf(amount, 1) {
+			return fmt.Errorf("invalid genesis allocation %q: %v", address, amount)
+		}
+	}
+	for address, stake := range config.Stakes {
+		if !(stake > 0 && stake <= config.Allocations[address]) {
+			return fmt.Errorf("invalid genesis stake %q: %v", address, stake)
+		}
+	}
+	for _, signer := range config.Signers {
+		if signer == "" || strings.Contains(signer, ",") {
+			return fmt.Errorf("invalid genesis signer %q", signer)
+		}
+	}
+	if config.Hash != "" {
+		genesisBlock := config.Block(powAlgorithm)
+		if genesisBlock.hash != config.Hash {
+			return fmt.Errorf("%w: got %s want %s", ErrGenesisMismatch, genesisBlock.hash, config.Hash)
+		}
+	}
+	return nil
+}
+
+func sortedAddresses[V float64 | uint64](amounts map[string]V) []string {
+	addresses := make([]string, 0, len(amounts))
+	for address := range amounts {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
@@ -0,0 +1,122 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+)
+
+// 区块头的格式：前一个区块的hash(32字节) + 区块内容的hash(32字节) + 时间戳(8字节) + nonce(4字节)，整数都是大端
+// 挖矿时只有最后的nonce在变，前64字节正好是sha256的一个分组，算一次之后的中间状态可以一直复用
+const (
+	BlockHeaderSize   = 76
+	HeaderNonceOffset = 72 //nonce在区块头里的偏移
+	headerMidstateLen = 64 //固定不变、可以预先算好sha256中间状态的部分
+)
+
+// contentRoot 区块头之外参与hash计算的内容(状态承诺除外)的hash：交易、附带信息以及权威证明和权益证明用到的字段
+// prevHash的原文也算进来，因为创世区块的prevHash不是一个合法的hash，区块头里只能填0
+// 交易按数量加上每笔交易32字节的ID写进去，字符串都带长度前缀，和computeHash一样保证不同的区块内容拼不出同一串字节，
+// 否则可以把一笔转账和出块奖励改写成一笔收款地址里塞着转账原文的出块奖励，区块hash不变，余额却变了
+// 交易被裁剪掉的区块直接用裁剪前算好的
+func (block *Block) contentRoot() [sha256.Size]byte {
+	if block.prunedRoot != nil {
+		return *block.prunedRoot
+	}
+	digest := sha256.New()
+	writeField(digest, block.prevHash)
+	binary.Write(digest, binary.BigEndian, uint32(len(block.transactions)))
+	for i := range block.transactions {
+		id, _ := hex.DecodeString(block.transactions[i].ID())
+		digest.Write(id)
+	}
+	for _, field := range []string{block.message, block.extra, block.signer, block.voteData(), block.slashingData()} {
+		writeField(digest, field)
+	}
+	var root [sha256.Size]byte
+	digest.Sum(root[:0])
+	return root
+}
+
+// writeField 写入带4字节长度前缀的字符串
+func writeField(digest hash.Hash, field string) {
+	binary.Write(digest, binary.BigEndian, uint32(len(field)))
+	digest.Write([]byte(field))
+}
+
+// bodyRoot 区块头里的区块内容hash：没有状态承诺的话就是contentRoot，有的话把状态承诺拼在后面再算一次hash
+// 这样只有区块头、交易被裁剪掉的区块也能校验状态承诺有没有被改过，没有状态承诺的区块hash也和原来一样
+func (block *Block) bodyRoot() [sha256.Size]byte {
+	root := block.contentRoot()
+	if block.stateRoot == "" {
+		return root
+	}
+	return sha256.Sum256(append(root[:], block.stateRoot...))
+}
+
+// header 区块头，区块的hash就是区块头的sha256
+func (block *Block) header() [BlockHeaderSize]byte {
+	var header [BlockHeaderSize]byte
+	if prevHash, err := hex.DecodeString(block.prevHash); err == nil && len(prevHash) == sha256.Size {
+		copy(header[:32], prevHash)
+	}
+	root := block.bodyRoot()
+	copy(header[32:64], root[:])
+	binary.BigEndian.PutUint64(header[64:72], block.timestamp)
+	putHeaderNonce(&header, block.nonce)
+	return header
+}
+
+func putHeaderNonce(header *[BlockHeaderSize]byte, nonce uint32) {
+	binary.BigEndian.PutUint32(header[HeaderNonceOffset:], nonce)
+}
+
+// meetsDifficulty hash的十六进制表示是否以difficulty个0开头，直接看字节，不用先转成字符串
+func meetsDifficulty(hash []byte, difficulty int) bool {
+	for i := 0; i < difficulty; i++ {
+		if i/2 >= len(hash) {
+			return false
+		}
+		nibble := hash[i/2] >> 4
+		if i%2 == 1 {
+			nibble = hash[i/2] & 0x0f
+		}
+		if nibble != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MidstateHasher 对同一个区块头的不同nonce算sha256：前64字节的中间状态只算一次，之后每个nonce只要再算一个分组
+// 不是并发安全的，每个挖矿的goroutine用自己的一个；算hash的过程中不分配内存，外部矿工也可以直接用它
+type MidstateHasher struct {
+	digest   hash.Hash
+	restore  encoding.BinaryUnmarshaler
+	midstate []byte
+	sum      []byte
+}
+
+// NewMidstateHasher 之后传给Hash的区块头，前64字节必须和这里的一样
+func NewMidstateHasher(header *[BlockHeaderSize]byte) *MidstateHasher {
+	digest := sha256.New()
+	digest.Write(header[:headerMidstateLen])
+	//标准库的sha256支持导出和恢复中间状态
+	midstate, _ := digest.(encoding.BinaryMarshaler).MarshalBinary()
+	return &MidstateHasher{
+		digest:   digest,
+		restore:  digest.(encoding.BinaryUnmarshaler),
+		midstate: midstate,
+		sum:      make([]byte, 0, sha256.Size),
+	}
+}
+
+// Hash 返回区块头的sha256，返回的切片下一次调用时会被覆盖
+func (hasher *MidstateHasher) Hash(header *[BlockHeaderSize]byte) []byte {
+	hasher.restore.UnmarshalBinary(hasher.midstate)
+	hasher.digest.Write(header[headerMidstateLen:])
+	hasher.sum = hasher.digest.Sum(hasher.sum[:0])
+	return hasher.sum
+}
@@ -0,0 +1,728 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 交易池的默认参数
+const (
+	DefaultMempoolMaxSize              = 5 * 1024 * 1024 //交易池最多占用的字节数
+	DefaultMempoolExpiry               = 72 * time.Hour  //交易在池子里最多停留的时间
+	DefaultMinRelayFeeRate             = 0               //最低转发费率(每字节手续费)
+	DefaultIncrementalRelayFeeRate     = 0.00001         //池子满了驱逐交易后，最低费率需要额外抬高的幅度
+	DefaultRollingMinFeeRateHalfLife   = 12 * time.Hour  //被抬高的最低费率的半衰期
+	rollingMinFeeRateDecayCheckMinimum = 10 * time.Second
+	mempoolMaxClusterCount             = 64 //互相依赖的一簇交易最多有多少笔，打包和驱逐时沿着依赖关系遍历的开销就有了上限
+)
+
+var (
+	ErrMempoolDuplicateTx    = errors.New("transaction already in mempool")
+	ErrMempoolFeeTooLow      = errors.New("transaction fee rate below mempool minimum")
+	ErrMempoolTxTooLarge     = errors.New("transaction larger than mempool capacity")
+	ErrMempoolFull           = errors.New("mempool full")
+	ErrMempoolConflict       = errors.New("transaction conflicts with a transaction already in mempool")
+	ErrMempoolTooManyRelated = errors.New("transaction has too many related unconfirmed transactions in mempool")
+)
+
+// MempoolConfig 交易池的配置
+type MempoolConfig struct {
+	MaxSize                   int           //池子里所有交易加起来的最大字节数
+	Expiry                    time.Duration //交易的过期时间，超过这个时间还没被打包的交易会被丢弃
+	MinRelayFeeRate           float64       //静态的最低费率
+	IncrementalRelayFeeRate   float64       //驱逐后最低费率至少要比被驱逐交易的费率高出这么多
+	RollingMinFeeRateHalfLife time.Duration //动态最低费率回落的半衰期
+}
+
+func DefaultMempoolConfig() MempoolConfig {
+	return MempoolConfig{
+		MaxSize:                   DefaultMempoolMaxSize,
+		Expiry:                    DefaultMempoolExpiry,
+		MinRelayFeeRate:           DefaultMinRelayFeeRate,
+		IncrementalRelayFeeRate:   DefaultIncrementalRelayFeeRate,
+		RollingMinFeeRateHalfLife: DefaultRollingMinFeeRateHalfLife,
+	}
+}
+
+type mempoolEntry struct {
+	tx        Transaction
+	id        string
+	size      int
+	feeRate   float64
+	entryTime time.Time
+	sequence  uint64 //进池顺序，用来保证相同条件下先来先打包
+
+	//池子里的依赖关系，依赖的交易没上链之前这笔交易也没法上链：
+	//parent/child是同一个发送者nonce相邻的交易；
+	//funding是转钱给发送者、还没上链的交易，只有已确认的余额不够付的时候才会依赖它们，funded反过来。
+	//付得起的交易不会因为别人随手转来的一笔小钱多出依赖，所以别人拖不住、也连带驱逐不了它
+	parent  *mempoolEntry
+	child   *mempoolEntry
+	funding []*mempoolEntry
+	funded  []*mempoolEntry
+}
+
+func (e *mempoolEntry) parents() []*mempoolEntry {
+	if e.parent == nil {
+		return e.funding
+	}
+	return append([]*mempoolEntry{e.parent}, e.funding...)
+}
+
+func (e *mempoolEntry) children() []*mempoolEntry {
+	if e.child == nil {
+		return e.funded
+	}
+	return append([]*mempoolEntry{e.child}, e.funded...)
+}
+
+// walkPostOrder 从e出发沿着next深度优先遍历，按后序返回经过的交易(不含e)；skip返回true的交易不走，也不再往它后面走
+func walkPostOrder(e *mempoolEntry, next func(*mempoolEntry) []*mempoolEntry, skip func(*mempoolEntry) bool) []*mempoolEntry {
+	var order []*mempoolEntry
+	visited := map[*mempoolEntry]bool{e: true}
+	var visit func(cur *mempoolEntry)
+	visit = func(cur *mempoolEntry) {
+		for _, other := range next(cur) {
+			if visited[other] || (skip != nil && skip(other)) {
+				continue
+			}
+			visited[other] = true
+			visit(other)
+			order = append(order, other)
+		}
+	}
+	visit(e)
+	return order
+}
+
+// ancestors 池子里这笔交易直接或者间接依赖的交易，排在前面的交易不依赖后面的
+func (e *mempoolEntry) ancestors() []*mempoolEntry {
+	return walkPostOrder(e, (*mempoolEntry).parents, nil)
+}
+
+// descendants 池子里直接或者间接依赖这笔交易的交易，排在后面的交易不会被前面的依赖
+func (e *mempoolEntry) descendants() []*mempoolEntry {
+	descendants := walkPostOrder(e, (*mempoolEntry).children, nil)
+	slices.Reverse(descendants)
+	return descendants
+}
+
+// evictionScore 驱逐时用的分数：自己的费率和带上所有子孙交易的整体费率中取较大的，
+// 这样有高手续费子交易撑腰的父交易不会被先驱逐
+func (e *mempoolEntry) evictionScore() float64 {
+	fee, size := e.tx.fee, e.size
+	for _, descendant := range e.descendants() {
+		fee += descendant.tx.fee
+		size += descendant.size
+	}
+	return math.Max(e.feeRate, fee/float64(size))
+}
+
+// Mempool 交易池，保存等待被打包进区块的交易
+// 池子有最大容量，满了之后会把费率最低的交易驱逐出去，并抬高最低转发费率，
+// 这样就算有人不停地往池子里塞交易，也只能靠不断提高手续费来挤掉别人，而不能把节点的内存撑爆
+// 交易池可以被多个goroutine同时使用
+type Mempool struct {
+	mu      sync.Mutex
+	config  MempoolConfig
+	entries map[string]*mempoolEntry
+	senders map[string]map[uint64]*mempoolEntry //发送者 -> nonce -> 交易，同一个发送者的同一个nonce只能被花一次
+	payees  map[string]map[*mempoolEntry]bool   //收款地址 -> 转钱给它的交易
+	size    int
+	nextSeq uint64
+
+	rollingMinFeeRate  float64   //池子满了之后被抬高的动态最低费率
+	lastRollingFeeBump time.Time //上一次调整动态最低费率的时间
+
+	txAdded chan struct{} //每进来一笔新交易就关闭并换一个新的，后台矿工据此更新待挖的区块
+
+	events  *eventFeed //交易进池和被移出的事件发到这里，单独使用的交易池是nil
+	pending []Event    //持有锁期间产生、还没发出去的事件，按发生的顺序
+}
+
+func NewMempool(config MempoolConfig) *Mempool {
+	return &Mempool{
+		config:  config,
+		entries: map[string]*mempoolEntry{},
+		senders: map[string]map[uint64]*mempoolEntry{},
+		payees:  map[string]map[*mempoolEntry]bool{},
+		txAdded: make(chan struct{}),
+	}
+}
+
+// senderFunds 发送者付这笔交易之前手里有多少钱，borrowed是已经算进这笔钱里的、池子里转给发送者的交易
+type senderFunds struct {
+	balance  float64
+	borrowed map[*mempoolEntry]bool
+}
+
+// Add 把交易放进池子，调用方需要先校验好交易本身的合法性，余额也不检查，不会依赖池子里别人转给发送者的钱
+func (m *Mempool) Add(tx Transaction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.publishEvents()
+	now := time.Now()
+	m.expire(now)
+	return m.add(tx, now, nil, nil)
+}
+
+// addFunded 和Add一样，再检查发送者付不付得起：confirmed是发送者已确认的余额，confirmedNonce是链上的nonce
+// 已确认的余额减去同一个发送者排在前面的交易不够付的话，按进池顺序借用池子里转给发送者、还没上链的钱，
+// 借到的交易成为这笔交易的依赖(funding)，比如收款人可以花这笔钱、付高手续费把卡住的转账一起带上链
+// 余额不够的话返回ValidationError；替换掉旧交易之后，同一个发送者nonce更大的交易付不起了的话会被一起驱逐
+func (m *Mempool) addFunded(tx Transaction, confirmed float64, confirmedNonce uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.publishEvents()
+	now := time.Now()
+	m.expire(now)
+
+	balance := confirmed
+	for n := confirmedNonce; n < tx.nonce; n++ {
+		entry, ok := m.senders[tx.from][n]
+		if !ok {
+			break
+		}
+		balance -= entry.tx.amount + entry.tx.fee
+	}
+	var funding []*mempoolEntry
+	borrowed := map[*mempoolEntry]bool{}
+	if checkBalance(balance, tx) != nil {
+		//替换掉的交易和依赖它的交易都会被移出池子，nonce更大的交易会依赖这笔交易，它们转过来的钱都不能借
+		excluded := map[*mempoolEntry]bool{}
+		if next, ok := m.senders[tx.from][tx.nonce]; ok {
+			excluded[next] = true
+			for _, descendant := range next.descendants() {
+				excluded[descendant] = true
+			}
+		} else if next, ok := m.senders[tx.from][tx.nonce+1]; ok {
+			excluded[next] = true
+			for _, descendant := range next.descendants() {
+				excluded[descendant] = true
+			}
+		}
+		inherited := map[*mempoolEntry]bool{}
+		if parent, ok := m.senders[tx.from][tx.nonce-1]; ok && tx.nonce > 0 {
+			for _, ancestor := range append(parent.ancestors(), parent) {
+				inherited[ancestor] = true
+			}
+		}
+		incoming := make([]*mempoolEntry, 0, len(m.payees[tx.from]))
+		for entry := range m.payees[tx.from] {
+			if !excluded[entry] {
+				incoming = append(incoming, entry)
+			}
+		}
+		sort.Slice(incoming, func(i, j int) bool { return incoming[i].sequence < incoming[j].sequence })
+		for _, entry := range incoming {
+			if checkBalance(balance, tx) == nil {
+				break
+			}
+			balance += entry.tx.amount
+			borrowed[entry] = true
+			//同一个发送者前面的交易已经依赖了的，不用再记一遍
+			if !inherited[entry] {
+				funding = append(funding, entry)
+			}
+		}
+	}
+	if err := checkBalance(balance, tx); err != nil {
+		return newTxError(&tx, err)
+	}
+	return m.add(tx, now, funding, &senderFunds{balance: balance, borrowed: borrowed})
+}
+
+// add 调用方需要持有m.mu，并且已经丢掉了过期的交易
+// funds不是nil的话，替换之后按它重新检查同一个发送者nonce更大的交易付不付得起
+func (m *Mempool) add(tx Transaction, now time.Time, funding []*mempoolEntry, funds *senderFunds) error {
+	id := tx.ID()
+	if _, ok := m.entries[id]; ok {
+		return ErrMempoolDuplicateTx
+	}
+
+	entry := &mempoolEntry{
+		tx:        tx,
+		id:        id,
+		size:      tx.Size(),
+		feeRate:   tx.FeeRate(),
+		entryTime: now,
+		sequence:  m.nextSeq,
+	}
+	if entry.size > m.config.MaxSize {
+		return ErrMempoolTxTooLarge
+	}
+	minFeeRate := m.minFeeRate(now)
+	if entry.feeRate < minFeeRate {
+		return fmt.Errorf("%w: %v < %v", ErrMempoolFeeTooLow, entry.feeRate, minFeeRate)
+	}
+
+	//同一份钱已经被池子里的另一笔交易花掉了，只有满足替换规则才能把旧交易替换掉
+	conflict, replacing := m.senders[tx.from][tx.nonce]
+	if replacing {
+		if err := m.checkReplacement(conflict, entry); err != nil {
+			return err
+		}
+	}
+	if count := m.clusterCount(tx, conflict, funding); count > mempoolMaxClusterCount {
+		return fmt.Errorf("%w: %d > %d", ErrMempoolTooManyRelated, count, mempoolMaxClusterCount)
+	}
+	//替换可能会因为池子装不下新交易而撤销，先记下可能被连带驱逐的交易和它们原来的依赖，撤销的时候原样放回去
+	var replaced []*mempoolEntry
+	replacedFunding := map[*mempoolEntry][]*mempoolEntry{}
+	pendingCount := len(m.pending)
+	if replacing {
+		replaced = append([]*mempoolEntry{conflict}, conflict.descendants()...)
+		for _, e := range replaced {
+			replacedFunding[e] = slices.Clone(e.funding)
+		}
+		m.evictWithFunded(conflict.id, EvictReasonReplaced)
+	}
+
+	m.nextSeq++
+	m.link(entry, funding)
+	m.entries[id] = entry
+	m.size += entry.size
+	if replacing && funds != nil {
+		m.evictUnaffordable(entry, funds)
+	}
+	m.pending = append(m.pending, Event{Type: EventTxAccepted, Transaction: tx})
+
+	evicted, maxEvictedFeeRate := m.trimPlan()
+	if replacing && slices.Contains(evicted, entry) {
+		//替换之后新交易自己就是费率最低的，不能让新旧两笔交易都丢掉，撤销替换，对外来说什么都没发生过
+		m.remove(id)
+		m.restore(replaced, replacedFunding)
+		m.pending = m.pending[:pendingCount]
+		return ErrMempoolFull
+	}
+	m.trim(evicted, maxEvictedFeeRate, now)
+	if _, ok := m.entries[id]; !ok {
+		//新来的交易自己就是费率最低的，被驱逐掉了，对外来说它根本没进过池子
+		pending := m.pending[:0]
+		for _, event := range m.pending {
+			if event.Transaction.ID() != id {
+				pending = append(pending, event)
+			}
+		}
+		m.pending = pending
+		return ErrMempoolFull
+	}
+	close(m.txAdded)
+	m.txAdded = make(chan struct{})
+	return nil
+}
+
+// TxAddedNotify 返回一个channel，下一笔交易进池的时候会被关闭
+func (m *Mempool) TxAddedNotify() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.txAdded
+}
+
+// checkReplacement 替换规则(参考BIP125)：
+// 新交易的费率必须比旧交易高，而且多付的手续费至少要够新交易按增量费率转发一次，
+// 否则别人可以用几乎不加钱的交易不停地替换，白白消耗节点的带宽
+func (m *Mempool) checkReplacement(old, replacement *mempoolEntry) error {
+	if replacement.feeRate <= old.feeRate {
+		return fmt.Errorf("%w: replacement fee rate %v not higher than %v", ErrMempoolConflict, replacement.feeRate, old.feeRate)
+	}
+	minFee := old.tx.fee + m.config.IncrementalRelayFeeRate*float64(replacement.size)
+	if replacement.tx.fee < minFee {
+		return fmt.Errorf("%w: replacement fee %v less than %v", ErrMempoolConflict, replacement.tx.fee, minFee)
+	}
+	return nil
+}
+
+// clusterCount tx进池之后，它和直接或者间接有依赖关系的交易一共有多少笔，conflict是会被它替换掉的交易
+func (m *Mempool) clusterCount(tx Transaction, conflict *mempoolEntry, funding []*mempoolEntry) int {
+	neighbors := append([]*mempoolEntry{}, funding...)
+	if parent, ok := m.senders[tx.from][tx.nonce-1]; ok && tx.nonce > 0 {
+		neighbors = append(neighbors, parent)
+	}
+	if child, ok := m.senders[tx.from][tx.nonce+1]; ok {
+		neighbors = append(neighbors, child)
+	}
+	//被替换的交易连同依赖它转过来的钱的交易都会被移出池子
+	removed := map[*mempoolEntry]bool{}
+	if conflict != nil {
+		removed[conflict] = true
+		for _, funded := range conflict.funded {
+			removed[funded] = true
+			for _, descendant := range funded.descendants() {
+				removed[descendant] = true
+			}
+		}
+	}
+	visited := map[*mempoolEntry]bool{}
+	for len(neighbors) > 0 && len(visited) <= mempoolMaxClusterCount {
+		cur := neighbors[len(neighbors)-1]
+		neighbors = neighbors[:len(neighbors)-1]
+		if visited[cur] || removed[cur] {
+			continue
+		}
+		visited[cur] = true
+		neighbors = append(append(neighbors, cur.parents()...), cur.children()...)
+	}
+	return len(visited) + 1
+}
+
+// link 把新交易记进发送者和收款地址的索引，和同一个发送者nonce相邻的交易接成父子关系，再接上它借用的funding交易
+func (m *Mempool) link(entry *mempoolEntry, funding []*mempoolEntry) {
+	nonces, ok := m.senders[entry.tx.from]
+	if !ok {
+		nonces = map[uint64]*mempoolEntry{}
+		m.senders[entry.tx.from] = nonces
+	}
+	nonces[entry.tx.nonce] = entry
+	if entry.tx.to != StakeAddress && entry.tx.to != entry.tx.from {
+		payees, ok := m.payees[entry.tx.to]
+		if !ok {
+			payees = map[*mempoolEntry]bool{}
+			m.payees[entry.tx.to] = payees
+		}
+		payees[entry] = true
+	}
+
+	if child, ok := nonces[entry.tx.nonce+1]; ok {
+		entry.child, child.parent = child, entry
+	}
+	if parent, ok := nonces[entry.tx.nonce-1]; ok && entry.tx.nonce > 0 {
+		entry.parent, parent.child = parent, entry
+	}
+	entry.funding = funding
+	for _, parent := range funding {
+		parent.funded = append(parent.funded, entry)
+	}
+}
+
+// evictUnaffordable 替换之后发送者花掉的钱变了，按nonce顺序重新算nonce更大的交易的余额，
+// 从第一笔付不起的交易开始连同它的子孙交易一起驱逐
+func (m *Mempool) evictUnaffordable(entry *mempoolEntry, funds *senderFunds) {
+	balance := funds.balance - entry.tx.amount - entry.tx.fee
+	for child := entry.child; child != nil; child = child.child {
+		for _, parent := range child.funding {
+			if !funds.borrowed[parent] {
+				funds.borrowed[parent] = true
+				balance += parent.tx.amount
+			}
+		}
+		if checkBalance(balance, child.tx) != nil {
+			m.removeWithDescendants(child.id, EvictReasonReplaced)
+			return
+		}
+		balance -= child.tx.amount + child.tx.fee
+	}
+}
+
+// restore 把移出池子的交易按原来的依赖关系放回去，用来撤销一次没成功的替换
+func (m *Mempool) restore(entries []*mempoolEntry, funding map[*mempoolEntry][]*mempoolEntry) {
+	for _, entry := range entries {
+		if _, ok := m.entries[entry.id]; ok {
+			continue
+		}
+		m.link(entry, funding[entry])
+		m.entries[entry.id] = entry
+		m.size += entry.size
+	}
+}
+
+// trimPlan 池子超出容量时，从费率最低的交易开始，算出要驱逐哪些交易才能回到容量以内，并不真的驱逐
+// 父交易被驱逐的话，依赖它的子孙交易也没法上链了，要一起驱逐
+// 返回按驱逐顺序排好的交易，以及其中最高的驱逐分数
+func (m *Mempool) trimPlan() ([]*mempoolEntry, float64) {
+	if m.size <= m.config.MaxSize {
+		return nil, 0
+	}
+
+	scores := map[string]float64{}
+	for id, entry := range m.entries {
+		scores[id] = entry.evictionScore()
+	}
+	entries := m.sortedEntries(func(a, b *mempoolEntry) bool {
+		if scores[a.id] != scores[b.id] {
+			return scores[a.id] < scores[b.id]
+		}
+		//费率相同的情况下，先驱逐后来的
+		return a.sequence > b.sequence
+	})
+	size := m.size
+	planned := map[*mempoolEntry]bool{}
+	var evicted []*mempoolEntry
+	maxEvictedFeeRate := 0.0
+	for _, entry := range entries {
+		if size <= m.config.MaxSize {
+			break
+		}
+		if planned[entry] {
+			continue
+		}
+		for _, e := range append([]*mempoolEntry{entry}, entry.descendants()...) {
+			if !planned[e] {
+				planned[e] = true
+				evicted = append(evicted, e)
+				size -= e.size
+			}
+		}
+		maxEvictedFeeRate = math.Max(maxEvictedFeeRate, scores[entry.id])
+	}
+	return evicted, maxEvictedFeeRate
+}
+
+// trim 按trimPlan算出来的结果驱逐交易，并抬高动态最低费率
+func (m *Mempool) trim(evicted []*mempoolEntry, maxEvictedFeeRate float64, now time.Time) {
+	if len(evicted) == 0 {
+		return
+	}
+	for _, entry := range evicted {
+		m.evict(entry.id, EvictReasonSizeLimit)
+	}
+
+	//后来的交易至少要比被驱逐的交易费率更高才能进池子
+	bumped := maxEvictedFeeRate + m.config.IncrementalRelayFeeRate
+	if bumped > m.rollingMinFeeRate {
+		m.rollingMinFeeRate = bumped
+	}
+	m.lastRollingFeeBump = now
+}
+
+// minFeeRate 当前进池需要的最低费率，取静态最低费率和动态最低费率中较大的那个
+// 动态最低费率会随着时间按半衰期回落，压力过去后手续费低的交易又能进来了
+func (m *Mempool) minFeeRate(now time.Time) float64 {
+	if m.rollingMinFeeRate > 0 && m.config.RollingMinFeeRateHalfLife > 0 {
+		elapsed := now.Sub(m.lastRollingFeeBump)
+		if elapsed > rollingMinFeeRateDecayCheckMinimum {
+			halvings := float64(elapsed) / float64(m.config.RollingMinFeeRateHalfLife)
+			m.rollingMinFeeRate /= math.Pow(2, halvings)
+			m.lastRollingFeeBump = now
+			if m.rollingMinFeeRate < m.config.IncrementalRelayFeeRate/2 {
+				m.rollingMinFeeRate = 0
+			}
+		}
+	}
+	return math.Max(m.config.MinRelayFeeRate, m.rollingMinFeeRate)
+}
+
+// MinFeeRate 当前进池需要的最低费率
+func (m *Mempool) MinFeeRate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.minFeeRate(time.Now())
+}
+
+// Expire 丢弃在now之前已经过期的交易，返回被丢弃的交易数
+func (m *Mempool) Expire(now time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.publishEvents()
+	return m.expire(now)
+}
+
+func (m *Mempool) expire(now time.Time) int {
+	if m.config.Expiry <= 0 {
+		return 0
+	}
+	removed := 0
+	for id, entry := range m.entries {
+		if _, ok := m.entries[id]; ok && now.Sub(entry.entryTime) > m.config.Expiry {
+			removed += m.removeWithDescendants(id, EvictReasonExpired)
+		}
+	}
+	return removed
+}
+
+// evictWithFunded 因为reason驱逐交易，借用它转过来的钱的交易连同它们的子孙交易也付不起了，一起驱逐
+// 同一个发送者nonce更大的交易不受影响，比如被替换或者冲突的交易上链之后，它们还能接着上链
+func (m *Mempool) evictWithFunded(id string, reason string) {
+	entry, ok := m.entries[id]
+	if !ok {
+		return
+	}
+	funded := append([]*mempoolEntry{}, entry.funded...)
+	m.evict(id, reason)
+	for _, e := range funded {
+		m.removeWithDescendants(e.id, reason)
+	}
+}
+
+// removeWithDescendants 因为reason驱逐交易以及依赖它的所有子孙交易，返回移除的交易数
+func (m *Mempool) removeWithDescendants(id string, reason string) int {
+	entry, ok := m.entries[id]
+	if !ok {
+		return 0
+	}
+	descendants := entry.descendants()
+	removed := len(m.entries)
+	m.evict(id, reason)
+	for _, descendant := range descendants {
+		m.evict(descendant.id, reason)
+	}
+	return removed - len(m.entries)
+}
+
+// evict 因为reason把交易移出池子，并记下一个EventTxEvicted事件
+func (m *Mempool) evict(id string, reason string) {
+	entry, ok := m.entries[id]
+	if !ok {
+		return
+	}
+	m.remove(id)
+	m.pending = append(m.pending, Event{Type: EventTxEvicted, Transaction: entry.tx, Reason: reason})
+}
+
+// publishEvents 把持有锁期间产生的事件发出去，调用方需要持有m.mu
+func (m *Mempool) publishEvents() {
+	for _, event := range m.pending {
+		m.events.publish(event)
+	}
+	m.pending = m.pending[:0]
+}
+
+func (m *Mempool) remove(id string) {
+	entry, ok := m.entries[id]
+	if !ok {
+		return
+	}
+	m.size -= entry.size
+	delete(m.entries, id)
+	nonces := m.senders[entry.tx.from]
+	delete(nonces, entry.tx.nonce)
+	if len(nonces) == 0 {
+		delete(m.senders, entry.tx.from)
+	}
+	if payees, ok := m.payees[entry.tx.to]; ok {
+		delete(payees, entry)
+		if len(payees) == 0 {
+			delete(m.payees, entry.tx.to)
+		}
+	}
+	//上链了的话，借用它的钱的交易从此花的是已确认的余额，不再依赖它
+	if entry.parent != nil {
+		entry.parent.child = nil
+	}
+	if entry.child != nil {
+		entry.child.parent = nil
+	}
+	for _, parent := range entry.funding {
+		parent.funded = slices.DeleteFunc(parent.funded, func(e *mempoolEntry) bool { return e == entry })
+	}
+	for _, child := range entry.funded {
+		child.funding = slices.DeleteFunc(child.funding, func(e *mempoolEntry) bool { return e == entry })
+	}
+	entry.parent, entry.child, entry.funding, entry.funded = nil, nil, nil, nil
+}
+
+// Remove 把交易移出池子，比如交易已经被打包进区块了
+func (m *Mempool) Remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(id)
+}
+
+// RemoveConflicts 移除池子里和tx花同一份钱的其他交易，tx已经上链之后这些交易就不可能再有效了
+func (m *Mempool) RemoveConflicts(tx Transaction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.publishEvents()
+	if conflict, ok := m.senders[tx.from][tx.nonce]; ok && conflict.id != tx.ID() {
+		m.evictWithFunded(conflict.id, EvictReasonConflict)
+	}
+}
+
+// HasSpend 池子里是否已经有from发出的、nonce为指定值的交易
+func (m *Mempool) HasSpend(from string, nonce uint64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.senders[from][nonce]
+	return ok
+}
+
+// Ancestors 返回池子里交易id直接或者间接依赖的所有祖先交易(同一个发送者nonce更小的交易，以及它借用的钱的来源)，
+// 排在前面的交易不依赖后面的，这些交易都上链之后交易id才能上链
+func (m *Mempool) Ancestors(id string) []Transaction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.related(id, (*mempoolEntry).ancestors)
+}
+
+// Descendants 返回池子里直接或者间接依赖交易id的所有子孙交易(同一个发送者nonce更大的交易，以及借用它转过来的钱的交易)，
+// 排在后面的交易不会被前面的依赖
+func (m *Mempool) Descendants(id string) []Transaction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.related(id, (*mempoolEntry).descendants)
+}
+
+func (m *Mempool) related(id string, walk func(*mempoolEntry) []*mempoolEntry) []Transaction {
+	entry, ok := m.entries[id]
+	if !ok {
+		return nil
+	}
+	related := walk(entry)
+	transactions := make([]Transaction, 0, len(related))
+	for _, other := range related {
+		transactions = append(transactions, other.tx)
+	}
+	return transactions
+}
+
+func (m *Mempool) Has(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.entries[id]
+	return ok
+}
+
+// Count 池子里的交易数
+func (m *Mempool) Count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// Size 池子里所有交易占用的字节数
+func (m *Mempool) Size() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.size
+}
+
+// Transactions 按进池顺序返回池子里的交易
+func (m *Mempool) Transactions() []Transaction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.publishEvents()
+	m.expire(time.Now())
+	entries := sortEntriesBySequence(m.entries)
+	transactions := make([]Transaction, 0, len(entries))
+	for _, entry := range entries {
+		transactions = append(transactions, entry.tx)
+	}
+	return transactions
+}
+
+func sortEntriesBySequence(entries map[string]*mempoolEntry) []*mempoolEntry {
+	sorted := make([]*mempoolEntry, 0, len(entries))
+	for _, entry := range entries {
+		sorted = append(sorted, entry)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].sequence < sorted[j].sequence
+	})
+	return sorted
+}
+
+func (m *Mempool) sortedEntries(less func(a, b *mempoolEntry) bool) []*mempoolEntry {
+	entries := make([]*mempoolEntry, 0, len(m.entries))
+	for _, entry := range m.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return less(entries[i], entries[j])
+	})
+	return entries
+}
@@ -0,0 +1,97 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// ctxCheckInterval 每个worker每尝试这么多个nonce检查一次是否需要停下来
+const ctxCheckInterval = 1024
+
+// DefaultMiningWorkers 默认的挖矿goroutine数，每个CPU核一个
+func DefaultMiningWorkers() int {
+	return runtime.NumCPU()
+}
+
+var ErrNonceSpaceExhausted = errors.New("nonce space exhausted")
+
+type miningResult struct {
+	nonce uint32
+	hash  string
+}
+
+// searchNonce 让workers个goroutine分头寻找满足难度要求的nonce
+// 第i个worker尝试 i, i+workers, i+2*workers ...，互相不会重复
+// 所有worker把nonceRange个nonce(0或者超过4字节nonce空间的话就是整个nonce空间)都试完了还没找到的话返回ErrNonceSpaceExhausted
+// 任何一个worker找到答案后其他worker立刻停下；ctx被取消的话返回取消的原因
+// telemetry不为nil时，各个worker会把进度汇报上去
+// 区块头只算一次，每个worker只改自己那份区块头里的nonce，用newHasher创建的hasher算工作量hash，循环里不分配内存
+// 返回的是找到的nonce和区块的hash
+func searchNonce(ctx context.Context, block Block, difficulty int, workers int, nonceRange uint64, telemetry *miningTelemetry, newHasher newPowHasherFunc) (uint32, string, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	if nonceRange == 0 || nonceRange > math.MaxUint32+1 {
+		nonceRange = math.MaxUint32 + 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	header := block.header()
+	found := make(chan miningResult, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(header [BlockHeaderSize]byte, start uint64) {
+			defer wg.Done()
+			hasher := newHasher(&header)
+			tried := uint64(0)
+			for nonce := start; nonce < nonceRange; nonce += uint64(workers) {
+				if tried == ctxCheckInterval {
+					telemetry.record(tried, int(nonce))
+					tried = 0
+					if ctx.Err() != nil {
+						return
+					}
+				}
+				putHeaderNonce(&header, uint32(nonce))
+				hashRes := hasher.Hash(&header)
+				tried++
+				if meetsDifficulty(hashRes, difficulty) {
+					telemetry.record(tried, int(nonce))
+					candidate := block
+					candidate.nonce = uint32(nonce)
+					found <- miningResult{nonce: candidate.nonce, hash: candidate.computeHash()}
+					return
+				}
+			}
+			telemetry.record(tried, int(nonceRange-1))
+		}(header, uint64(i))
+	}
+	exhausted := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(exhausted)
+	}()
+
+	select {
+	case res := <-found:
+		cancel()
+		<-exhausted
+		return res.nonce, res.hash, nil
+	case <-exhausted:
+		//最后一个worker可能刚好找到了答案
+		select {
+		case res := <-found:
+			return res.nonce, res.hash, nil
+		default:
+			return 0, "", ErrNonceSpaceExhausted
+		}
+	case <-ctx.Done():
+		<-exhausted
+		return 0, "", context.Cause(ctx)
+	}
+}
@@ -0,0 +1,99 @@
+package blockchain
+
+import (
+	"fmt"
+	"time"
+)
+
+// ChainParams 一条链的共识参数和运行参数，不同的网络(主网、测试网、回归测试网)各有一套
+type ChainParams struct {
+	Name         string
+	Consensus    string        //共识引擎，默认是工作量证明
+	PowAlgorithm string        //工作量证明的hash算法，默认是sha256
+	Genesis      GenesisConfig //创世区块，初始难度和出块奖励也在里面
+	MaxBlockSize int           //每个区块能容纳的交易字节数
+	Checkpoints  []Checkpoint  //写死的可信区块
+	Mempool      MempoolConfig //交易池配置
+	DefaultPort  int           //http服务默认监听的端口
+
+	//是否允许随时按需出块，只有回归测试网打开，CI里不用等挖矿就能拿到区块
+	GenerateOnDemand bool
+	//每隔多少个区块在区块里承诺一次账本状态，新节点可以从这些高度的快照启动，0表示不承诺
+	SnapshotInterval int
+}
+
+// commitsState 高度为height的区块里是否要承诺账本状态
+func (params ChainParams) commitsState(height int) bool {
+	return params.SnapshotInterval > 0 && height > 0 && height%params.SnapshotInterval == 0
+}
+
+// MainNetParams 主网参数，难度最高
+var MainNetParams = ChainParams{
+	Name:         "mainnet",
+	Consensus:    ConsensusProofOfWork,
+	PowAlgorithm: PowAlgorithmSHA256,
+	Genesis:      DefaultGenesisConfig(),
+	MaxBlockSize: DefaultMaxBlockSize,
+	Checkpoints: []Checkpoint{
+		{Height: 0, Hash: "c39f5ca25507eb5fc9993061cf971a10cb75c1d7cdba79837c90801996000ce0"},
+	},
+	Mempool:          DefaultMempoolConfig(),
+	DefaultPort:      5000,
+	SnapshotInterval: 1000,
+}
+
+// TestNetParams 测试网参数，和主网的规则一样，只是难度低一些，给预发布环境用
+var TestNetParams = ChainParams{
+	Name:         "testnet",
+	Consensus:    ConsensusProofOfWork,
+	PowAlgorithm: PowAlgorithmSHA256,
+	Genesis: GenesisConfig{
+		Timestamp:   DefaultGenesisTimestamp,
+		Difficulty:  4,
+		MinerReward: DefaultMinerReward,
+		Message:     "CcCoin testnet genesis block",
+		Allocations: map[string]float64{},
+	},
+	MaxBlockSize: DefaultMaxBlockSize,
+	Checkpoints: []Checkpoint{
+		{Height: 0, Hash: "b3f856a2324f551a59eca32e41ed8261b62df71e3eb166ab188934ed4f32cee1"},
+	},
+	Mempool:          DefaultMempoolConfig(),
+	DefaultPort:      15000,
+	SnapshotInterval: 1000,
+}
+
+// RegTestParams 回归测试网参数，难度几乎为0，可以按需立刻出块，交易也不会过期
+var RegTestParams = ChainParams{
+	Name:         "regtest",
+	Consensus:    ConsensusProofOfWork,
+	PowAlgorithm: PowAlgorithmSHA256,
+	Genesis: GenesisConfig{
+		Timestamp:   DefaultGenesisTimestamp,
+		Difficulty:  1,
+		MinerReward: DefaultMinerReward,
+		Message:     "CcCoin regtest genesis block",
+		Allocations: map[string]float64{},
+	},
+	MaxBlockSize: DefaultMaxBlockSize,
+	Mempool: MempoolConfig{
+		MaxSize:                   DefaultMempoolMaxSize,
+		Expiry:                    0,
+		MinRelayFeeRate:           DefaultMinRelayFeeRate,
+		IncrementalRelayFeeRate:   DefaultIncrementalRelayFeeRate,
+		RollingMinFeeRateHalfLife: time.Hour,
+	},
+	DefaultPort:      25000,
+	GenerateOnDemand: true,
+	SnapshotInterval: 10,
+}
+
+// ParamsForNetwork 根据网络名返回对应的链参数
+func ParamsForNetwork(name string) (ChainParams, error) {
+	for _, params := range []ChainParams{MainNetParams, TestNetParams, RegTestParams} {
+		if params.Name == name {
+			return params, nil
+		}
+	}
+	return ChainParams{}, fmt.Errorf("unknown network %q", name)
+}
@@ -0,0 +1,299 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnauthorizedSigner    = errors.New("signer is not authorized")
+	ErrNotInTurn             = errors.New("signer is not in turn")
+	ErrInvalidBlockSignature = errors.New("invalid block signature")
+	ErrInvalidBlockTimestamp = errors.New("invalid block timestamp")
+)
+
+// authoritySnapshotCacheSize 最多缓存多少个区块之后的签名者状态
+const authoritySnapshotCacheSize = 1024
+
+// ProofOfAuthority 权威证明：由一组事先指定的签名者轮流出块，不需要挖矿
+// 高度为h的区块只能由排好序的签名者列表里的第h%n个签名者来出，出块时用它的私钥对区块hash签名
+// 签名者出块的时候可以顺带投票加入或踢出一个地址，超过半数的签名者同意就生效
+type ProofOfAuthority struct {
+	blockKeys //本节点持有的签名者密钥
+
+	mu        sync.Mutex
+	proposals map[string]bool               //本节点的签名者要投的票：地址 -> 加入(true)还是踢出(false)
+	snapshots map[string]*authoritySnapshot //区块hash -> 这个区块之后的签名者状态
+	recent    []string                      //snapshots里的区块hash，按加入的顺序，用来限制缓存的大小
+}
+
+func NewProofOfAuthority() *ProofOfAuthority {
+	return &ProofOfAuthority{
+		proposals: map[string]bool{},
+		snapshots: map[string]*authoritySnapshot{},
+	}
+}
+
+// Propose 提议加入(authorize为true)或踢出一个签名者，本节点的签名者之后出块的时候会投这一票
+func (poa *ProofOfAuthority) Propose(address string, authorize bool) error {
+	if address == "" || strings.Contains(address, ",") {
+		return fmt.Errorf("invalid signer address %q", address)
+	}
+	poa.mu.Lock()
+	defer poa.mu.Unlock()
+	poa.proposals[address] = authorize
+	return nil
+}
+
+// Discard 撤回对一个地址的提议
+func (poa *ProofOfAuthority) Discard(address string) {
+	poa.mu.Lock()
+	defer poa.mu.Unlock()
+	delete(poa.proposals, address)
+}
+
+// Proposals 返回本节点还没生效的提议
+func (poa *ProofOfAuthority) Proposals() map[string]bool {
+	poa.mu.Lock()
+	defer poa.mu.Unlock()
+	proposals := make(map[string]bool, len(poa.proposals))
+	for address, authorize := range poa.proposals {
+		proposals[address] = authorize
+	}
+	return proposals
+}
+
+// Signers 返回chain末端之后的签名者，按出块顺序排列
+func (poa *ProofOfAuthority) Signers(chain ChainReader) ([]string, error) {
+	snapshot, err := poa.snapshot(chain, chain.Height())
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.sortedSigners(), nil
+}
+
+func (poa *ProofOfAuthority) Name() string {
+	return ConsensusProofOfAuthority
+}
+
+// Prepare 填上轮到出块的签名者和要投的票，本节点没有这个签名者的密钥时返回ErrNotInTurn
+func (poa *ProofOfAuthority) Prepare(chain ChainReader, block *Block) error {
+	snapshot, err := poa.snapshot(chain, chain.Height())
+	if err != nil {
+		return err
+	}
+	height := chain.Height() + 1
+	signer := snapshot.inTurn(height)
+
+	if _, ok := poa.privateKey(signer); !ok {
+		return fmt.Errorf("%w: block %d belongs to %s", ErrNotInTurn, height, signer)
+	}
+
+	poa.mu.Lock()
+	defer poa.mu.Unlock()
+	block.signer = signer
+	block.vote, block.voteAuthorize = "", false
+	//按地址顺序挑一个还没生效、这个签名者也还没投过的提议
+	addresses := make([]string, 0, len(poa.proposals))
+	for address := range poa.proposals {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		authorize := poa.proposals[address]
+		if snapshot.signers[address] == authorize {
+			//已经生效了
+			delete(poa.proposals, address)
+			continue
+		}
+		if voted, ok := snapshot.votes[address][signer]; ok && voted == authorize {
+			continue
+		}
+		block.vote, block.voteAuthorize = address, authorize
+		break
+	}
+	block.timestamp = nextBlockTimestamp(chain)
+	return nil
+}
+
+// Seal 等到出块时间后用签名者的私钥对区块签名，不需要挖矿
+func (poa *ProofOfAuthority) Seal(ctx context.Context, chain ChainReader, block *Block, opts SealOptions) error {
+	privateKey, ok := poa.privateKey(block.signer)
+	if !ok {
+		return fmt.Errorf("%w: no key for %s", ErrUnauthorizedSigner, block.signer)
+	}
+	return signBlock(ctx, block, privateKey, opts.verifier)
+}
+
+// VerifySeal 校验区块是轮到出块的签名者出的，签名有效，而且和上一个区块的间隔不小于出块周期
+func (poa *ProofOfAuthority) VerifySeal(chain ChainReader, block *Block) error {
+	if err := verifyBlockSignature(block); err != nil {
+		return err
+	}
+	snapshot, err := poa.snapshot(chain, chain.Height())
+	if err != nil {
+		return err
+	}
+	if !snapshot.signers[block.signer] {
+		return fmt.Errorf("%w: %s", ErrUnauthorizedSigner, block.signer)
+	}
+	height := chain.Height() + 1
+	if inTurn := snapshot.inTurn(height); block.signer != inTurn {
+		return fmt.Errorf("%w: block %d belongs to %s", ErrNotInTurn, height, inTurn)
+	}
+	if block.vote == "" && block.voteAuthorize {
+		return fmt.Errorf("%w: empty vote", ErrInvalidBlockSignature)
+	}
+	return verifyBlockTimestamp(chain, block)
+}
+
+// CalcDifficulty 权威证明不需要挖矿，难度没有意义
+func (poa *ProofOfAuthority) CalcDifficulty(chain ChainReader) int {
+	return 0
+}
+
+// Weight 每个区块都是轮到的签名者出的，权重都一样，链越长越重
+func (poa *ProofOfAuthority) Weight(chain ChainReader, block *Block) *big.Int {
+	return big.NewInt(1)
+}
+
+// snapshot 返回高度为height的区块之后的签名者状态
+// 从height往回找到最近一个缓存过的区块(最远找到创世区块)，再把后面区块里的投票依次算上
+func (poa *ProofOfAuthority) snapshot(chain ChainReader, height int) (*authoritySnapshot, error) {
+	poa.mu.Lock()
+	defer poa.mu.Unlock()
+
+	var pending []Block
+	var snapshot *authoritySnapshot
+	var tipHash string
+	for h := height; snapshot == nil; h-- {
+		block, ok := chain.GetBlock(h)
+		if !ok {
+			return nil, fmt.Errorf("block %d not found", h)
+		}
+		if h == height {
+			tipHash = block.hash
+		}
+		if cached, ok := poa.snapshots[block.hash]; ok {
+			snapshot = cached
+		} else if h == 0 {
+			snapshot = newAuthoritySnapshot(strings.Split(block.extra, ","))
+		} else {
+			pending = append(pending, block)
+		}
+	}
+	for i := len(pending) - 1; i >= 0; i-- {
+		snapshot = snapshot.apply(&pending[i])
+	}
+	if len(snapshot.signers) == 0 {
+		return nil, errors.New("no authorized signers")
+	}
+
+	if _, ok := poa.snapshots[tipHash]; !ok {
+		poa.snapshots[tipHash] = snapshot
+		poa.recent = append(poa.recent, tipHash)
+		if len(poa.recent) > authoritySnapshotCacheSize {
+			delete(poa.snapshots, poa.recent[0])
+			poa.recent = poa.recent[1:]
+		}
+	}
+	return snapshot, nil
+}
+
+// authoritySnapshot 某个区块之后的签名者集合以及进行中的投票，缓存起来以后就不再修改
+type authoritySnapshot struct {
+	signers map[string]bool
+	votes   map[string]map[string]bool //被投票的地址 -> 投票的签名者 -> 加入还是踢出
+}
+
+func newAuthoritySnapshot(signers []string) *authoritySnapshot {
+	snapshot := &authoritySnapshot{
+		signers: map[string]bool{},
+		votes:   map[string]map[string]bool{},
+	}
+	for _, signer := range signers {
+		if signer != "" {
+			snapshot.signers[signer] = true
+		}
+	}
+	return snapshot
+}
+
+func (snapshot *authoritySnapshot) copy() *authoritySnapshot {
+	next := &authoritySnapshot{
+		signers: make(map[string]bool, len(snapshot.signers)),
+		votes:   make(map[string]map[string]bool, len(snapshot.votes)),
+	}
+	for signer := range snapshot.signers {
+		next.signers[signer] = true
+	}
+	for address, tally := range snapshot.votes {
+		next.votes[address] = make(map[string]bool, len(tally))
+		for voter, authorize := range tally {
+			next.votes[address][voter] = authorize
+		}
+	}
+	return next
+}
+
+// apply 算上区块里的投票，返回新的状态
+func (snapshot *authoritySnapshot) apply(block *Block) *authoritySnapshot {
+	next := snapshot.copy()
+	//加入已经是签名者的地址，或者踢出不是签名者的地址，这种票没有意义
+	if block.vote == "" || next.signers[block.vote] == block.voteAuthorize {
+		return next
+	}
+	tally, ok := next.votes[block.vote]
+	if !ok {
+		tally = map[string]bool{}
+		next.votes[block.vote] = tally
+	}
+	tally[block.signer] = block.voteAuthorize
+
+	count := 0
+	for _, authorize := range tally {
+		if authorize == block.voteAuthorize {
+			count++
+		}
+	}
+	if count <= len(next.signers)/2 {
+		return next
+	}
+
+	//超过半数同意，投票生效
+	delete(next.votes, block.vote)
+	if block.voteAuthorize {
+		next.signers[block.vote] = true
+		return next
+	}
+	//最后一个签名者不能被踢出，不然就没人能出块了
+	if len(next.signers) == 1 {
+		return next
+	}
+	delete(next.signers, block.vote)
+	//被踢出的签名者投过的票也不再算数
+	for _, tally := range next.votes {
+		delete(tally, block.vote)
+	}
+	return next
+}
+
+func (snapshot *authoritySnapshot) sortedSigners() []string {
+	signers := make([]string, 0, len(snapshot.signers))
+	for signer := range snapshot.signers {
+		signers = append(signers, signer)
+	}
+	sort.Strings(signers)
+	return signers
+}
+
+// inTurn 返回应该出高度为height的区块的签名者
+func (snapshot *authoritySnapshot) inTurn(height int) string {
+	signers := snapshot.sortedSigners()
+	return signers[height%len(signers)]
+}
@@ -0,0 +1,295 @@
+package blockchain
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// StakeAddress 转给这个地址的钱会锁定成发送者的权益，锁定之后不能再花
+const StakeAddress = "stake"
+
+// stakeSnapshotCacheSize 最多缓存多少个区块之后的权益分布
+const stakeSnapshotCacheSize = 1024
+
+var (
+	ErrNoValidators    = errors.New("no validators with stake")
+	ErrInvalidEvidence = errors.New("invalid double sign evidence")
+)
+
+// DoubleSign 同一个验证者基于同一个父区块(也就是在同一个高度)签了两个不同区块的证据
+// 证据被打包进区块之后，这个验证者锁定的权益会被全部罚没
+type DoubleSign struct {
+	First  Block
+	Second Block
+}
+
+func (evidence *DoubleSign) validator() string {
+	return evidence.First.signer
+}
+
+// verify 校验两个区块都是同一个验证者签的，而且确实是同一个高度上的两个不同区块
+func (evidence *DoubleSign) verify() error {
+	first, second := &evidence.First, &evidence.Second
+	if first.signer != second.signer {
+		return fmt.Errorf("%w: blocks signed by different validators", ErrInvalidEvidence)
+	}
+	if first.prevHash != second.prevHash {
+		return fmt.Errorf("%w: blocks have different parents", ErrInvalidEvidence)
+	}
+	if first.hash == second.hash {
+		return fmt.Errorf("%w: blocks are identical", ErrInvalidEvidence)
+	}
+	for _, block := range []*Block{first, second} {
+		if err := verifyBlockSignature(block); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidEvidence, err)
+		}
+	}
+	return nil
+}
+
+// ProofOfStake 权益证明：验证者把钱锁定成权益，每个高度以父区块hash为种子，按权益比例随机选出一个验证者来签名出块
+// 同一个高度签了两个不同区块的验证者，被举报后会罚没全部权益
+// 这是一个实验性的实现：出块的验证者能影响下一个高度的种子，也没有解锁权益的办法
+type ProofOfStake struct {
+	blockKeys //本节点持有的验证者密钥
+
+	mu        sync.Mutex
+	evidences map[string]DoubleSign         //等着打包进区块的双签证据：验证者 -> 证据
+	seen      map[string]Block              //见过的区块：验证者和父区块hash -> 区块，用来发现双签
+	seenOrder []string                      //seen里的key，按加入的顺序，用来限制缓存的大小
+	snapshots map[string]map[string]float64 //区块hash -> 这个区块之后的权益分布
+	recent    []string                      //snapshots里的区块hash，按加入的顺序，用来限制缓存的大小
+}
+
+func NewProofOfStake() *ProofOfStake {
+	return &ProofOfStake{
+		evidences: map[string]DoubleSign{},
+		seen:      map[string]Block{},
+		snapshots: map[string]map[string]float64{},
+	}
+}
+
+func (pos *ProofOfStake) Name() string {
+	return ConsensusProofOfStake
+}
+
+// Validators 返回chain末端之后每个验证者锁定的权益
+func (pos *ProofOfStake) Validators(chain ChainReader) (map[string]float64, error) {
+	return pos.stakes(chain, chain.Height())
+}
+
+// ReportDoubleSign 举报一个验证者在同一个高度签了两个不同区块，本节点之后出块的时候会把证据打包进去
+func (pos *ProofOfStake) ReportDoubleSign(first, second Block) error {
+	evidence := DoubleSign{First: first, Second: second}
+	if err := evidence.verify(); err != nil {
+		return err
+	}
+	pos.mu.Lock()
+	defer pos.mu.Unlock()
+	pos.evidences[evidence.validator()] = evidence
+	return nil
+}
+
+// Prepare 填上这个高度被选中的验证者，以及还没罚没的双签证据，本节点没有这个验证者的密钥时返回ErrNotInTurn
+func (pos *ProofOfStake) Prepare(chain ChainReader, block *Block) error {
+	stakes, err := pos.stakes(chain, chain.Height())
+	if err != nil {
+		return err
+	}
+	parent, _ := chain.GetBlock(chain.Height())
+	height := chain.Height() + 1
+	validator, err := selectValidator(stakes, parent.hash, height)
+	if err != nil {
+		return err
+	}
+	if _, ok := pos.privateKey(validator); !ok {
+		return fmt.Errorf("%w: block %d belongs to %s", ErrNotInTurn, height, validator)
+	}
+	block.signer = validator
+
+	pos.mu.Lock()
+	defer pos.mu.Unlock()
+	block.slashings = nil
+	offenders := make([]string, 0, len(pos.evidences))
+	for offender := range pos.evidences {
+		offenders = append(offenders, offender)
+	}
+	sort.Strings(offenders)
+	for _, offender := range offenders {
+		if stakes[offender] <= 0 {
+			//已经罚没过了
+			delete(pos.evidences, offender)
+			continue
+		}
+		block.slashings = append(block.slashings, pos.evidences[offender])
+	}
+	block.timestamp = nextBlockTimestamp(chain)
+	return nil
+}
+
+// Seal 等到出块时间后用验证者的私钥对区块签名
+func (pos *ProofOfStake) Seal(ctx context.Context, chain ChainReader, block *Block, opts SealOptions) error {
+	privateKey, ok := pos.privateKey(block.signer)
+	if !ok {
+		return fmt.Errorf("%w: no key for %s", ErrUnauthorizedSigner, block.signer)
+	}
+	return signBlock(ctx, block, privateKey, opts.verifier)
+}
+
+// VerifySeal 校验区块是这个高度被选中的验证者签的，带的双签证据也都有效
+// 同一个验证者在同一个高度签的另一个区块会被记下来，作为双签证据
+func (pos *ProofOfStake) VerifySeal(chain ChainReader, block *Block) error {
+	if err := verifyBlockSignature(block); err != nil {
+		return err
+	}
+	stakes, err := pos.stakes(chain, chain.Height())
+	if err != nil {
+		return err
+	}
+	parent, _ := chain.GetBlock(chain.Height())
+	height := chain.Height() + 1
+	validator, err := selectValidator(stakes, parent.hash, height)
+	if err != nil {
+		return err
+	}
+	if block.signer != validator {
+		return fmt.Errorf("%w: block %d belongs to %s", ErrNotInTurn, height, validator)
+	}
+	if err := verifyBlockTimestamp(chain, block); err != nil {
+		return err
+	}
+
+	slashed := map[string]bool{}
+	for _, evidence := range block.slashings {
+		if err := evidence.verify(); err != nil {
+			return err
+		}
+		offender := evidence.validator()
+		if stakes[offender] <= 0 || slashed[offender] {
+			return fmt.Errorf("%w: %s has no stake to slash", ErrInvalidEvidence, offender)
+		}
+		slashed[offender] = true
+	}
+
+	pos.observe(block)
+	return nil
+}
+
+// CalcDifficulty 权益证明不需要挖矿，难度没有意义
+func (pos *ProofOfStake) CalcDifficulty(chain ChainReader) int {
+	return 0
+}
+
+// Weight 每个高度只有一个被选中的验证者能出块，权重都一样，链越长越重
+func (pos *ProofOfStake) Weight(chain ChainReader, block *Block) *big.Int {
+	return big.NewInt(1)
+}
+
+// observe 记下验证过的区块，同一个验证者在同一个高度出现第二个不同的区块时生成双签证据
+func (pos *ProofOfStake) observe(block *Block) {
+	pos.mu.Lock()
+	defer pos.mu.Unlock()
+	key := block.signer + ":" + block.prevHash
+	seen, ok := pos.seen[key]
+	if !ok {
+		pos.seen[key] = *block
+		pos.seenOrder = append(pos.seenOrder, key)
+		if len(pos.seenOrder) > stakeSnapshotCacheSize {
+			delete(pos.seen, pos.seenOrder[0])
+			pos.seenOrder = pos.seenOrder[1:]
+		}
+		return
+	}
+	if seen.hash == block.hash {
+		return
+	}
+	if _, ok := pos.evidences[block.signer]; !ok {
+		pos.evidences[block.signer] = DoubleSign{First: seen, Second: *block}
+	}
+}
+
+// stakes 返回高度为height的区块之后的权益分布，每次返回的都是新的map，调用方可以随便改
+// 从height往回找到最近一个缓存过的区块(最远找到创世区块)，再用账本状态把后面的区块依次应用上去
+func (pos *ProofOfStake) stakes(chain ChainReader, height int) (map[string]float64, error) {
+	pos.mu.Lock()
+	defer pos.mu.Unlock()
+
+	var pending []Block
+	var stakes map[string]float64
+	var tipHash string
+	for h := height; h >= 0; h-- {
+		block, ok := chain.GetBlock(h)
+		if !ok {
+			return nil, fmt.Errorf("block %d not found", h)
+		}
+		if h == height {
+			tipHash = block.hash
+		}
+		if cached, ok := pos.snapshots[block.hash]; ok {
+			stakes = cached
+			break
+		}
+		pending = append(pending, block)
+	}
+
+	//权益只取决于锁定权益的交易和罚没，余额和nonce用不上，从空的开始算就行
+	state := newLedgerState()
+	for validator, stake := range stakes {
+		state.stakes[validator] = stake
+	}
+	for i := len(pending) - 1; i >= 0; i-- {
+		state.applyBlock(pending[i])
+	}
+
+	if _, ok := pos.snapshots[tipHash]; !ok {
+		//缓存里放一份单独的拷贝，调用方改返回的map不会影响缓存
+		cached := make(map[string]float64, len(state.stakes))
+		for validator, stake := range state.stakes {
+			cached[validator] = stake
+		}
+		pos.snapshots[tipHash] = cached
+		pos.recent = append(pos.recent, tipHash)
+		if len(pos.recent) > stakeSnapshotCacheSize {
+			delete(pos.snapshots, pos.recent[0])
+			pos.recent = pos.recent[1:]
+		}
+	}
+	return state.stakes, nil
+}
+
+// selectValidator 以父区块hash和高度为种子，按权益比例随机选出这个高度的验证者
+func selectValidator(stakes map[string]float64, parentHash string, height int) (string, error) {
+	validators := make([]string, 0, len(stakes))
+	for validator, stake := range stakes {
+		if stake > 0 {
+			validators = append(validators, validator)
+		}
+	}
+	if len(validators) == 0 {
+		return "", ErrNoValidators
+	}
+	//按地址顺序累加，保证每个节点算出来的浮点数完全一样
+	sort.Strings(validators)
+	total := 0.0
+	for _, validator := range validators {
+		total += stakes[validator]
+	}
+
+	seed := sha256.Sum256([]byte(parentHash + strconv.Itoa(height)))
+	//取种子的前53位，得到[0,1)之间均匀分布的数
+	target := float64(binary.BigEndian.Uint64(seed[:8])>>11) / (1 << 53) * total
+	for _, validator := range validators {
+		target -= stakes[validator]
+		if target < 0 {
+			return validator, nil
+		}
+	}
+	return validators[len(validators)-1], nil
+}
@@ -0,0 +1,128 @@
+package blockchain
+
+import (
+	"CcCoin-go-version/internal/encryption"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var ErrInvalidProofOfWork = errors.New("invalid proof of work")
+
+// 工作量证明可选的hash算法，用哪一种记录在链参数里
+const (
+	PowAlgorithmSHA256 = "sha256" //工作量hash就是区块hash本身
+	PowAlgorithmScrypt = "scrypt" //内存开销大的scrypt，专用硬件的优势小一些
+)
+
+// scrypt的参数，每算一次hash需要128*r*N=128KB内存
+const (
+	scryptN = 1024
+	scryptR = 1
+	scryptP = 1
+)
+
+// powHasher 对同一个区块头的不同nonce计算工作量hash，hash(十六进制)开头的0的个数要满足难度要求
+// 不是并发安全的，每个挖矿的goroutine用自己的一个
+type powHasher interface {
+	Hash(header *[BlockHeaderSize]byte) []byte
+}
+
+// newPowHasherFunc 为一个区块头创建powHasher，区块头里除了nonce之外的部分之后都不能再变
+type newPowHasherFunc func(header *[BlockHeaderSize]byte) powHasher
+
+func powHasherFor(algorithm string) (newPowHasherFunc, error) {
+	switch algorithm {
+	case "", PowAlgorithmSHA256:
+		//工作量hash就是区块hash，可以复用区块头前半部分的sha256中间状态
+		return func(header *[BlockHeaderSize]byte) powHasher { return NewMidstateHasher(header) }, nil
+	case PowAlgorithmScrypt:
+		return func(header *[BlockHeaderSize]byte) powHasher { return scryptHasher{} }, nil
+	default:
+		return nil, fmt.Errorf("unknown proof of work algorithm %q", algorithm)
+	}
+}
+
+// scryptHasher 用区块头同时作为scrypt的密码和盐，区块本身的hash还是sha256
+type scryptHasher struct{}
+
+func (scryptHasher) Hash(header *[BlockHeaderSize]byte) []byte {
+	hash, _ := encryption.Scrypt(header[:], header[:], scryptN, scryptR, scryptP, 32)
+	return hash
+}
+
+// PowHash 用工作量证明算法algorithm计算区块头data的工作量hash(十六进制)，外部矿工可以直接用它
+func PowHash(algorithm string, data []byte) (string, error) {
+	switch algorithm {
+	case "", PowAlgorithmSHA256:
+		hash := sha256.Sum256(data)
+		return hex.EncodeToString(hash[:]), nil
+	case PowAlgorithmScrypt:
+		hash, err := encryption.Scrypt(data, data, scryptN, scryptR, scryptP, 32)
+		return hex.EncodeToString(hash), err
+	default:
+		return "", fmt.Errorf("unknown proof of work algorithm %q", algorithm)
+	}
+}
+
+// ProofOfWork 工作量证明：区块的工作量hash(十六进制)开头要有difficulty个0，hash算法由链参数决定
+type ProofOfWork struct{}
+
+func NewProofOfWork() *ProofOfWork {
+	return &ProofOfWork{}
+}
+
+func (pow *ProofOfWork) Name() string {
+	return ConsensusProofOfWork
+}
+
+func (pow *ProofOfWork) Prepare(chain ChainReader, block *Block) error {
+	block.timestamp = uint64(time.Now().Unix())
+	return nil
+}
+
+func (pow *ProofOfWork) Seal(ctx context.Context, chain ChainReader, block *Block, opts SealOptions) error {
+	newHasher, err := powHasherFor(chain.Params().PowAlgorithm)
+	if err != nil {
+		return err
+	}
+	difficulty := pow.CalcDifficulty(chain)
+	if opts.telemetry != nil {
+		opts.telemetry.start(difficulty, opts.Workers)
+	}
+	err = block.mine(ctx, difficulty, opts, newHasher)
+	if opts.telemetry != nil {
+		opts.telemetry.finish(err == nil)
+	}
+	return err
+}
+
+func (pow *ProofOfWork) VerifySeal(chain ChainReader, block *Block) error {
+	if block.hash != block.computeHash() {
+		return fmt.Errorf("%w: hash does not match block content", ErrInvalidProofOfWork)
+	}
+	newHasher, err := powHasherFor(chain.Params().PowAlgorithm)
+	if err != nil {
+		return err
+	}
+	difficulty := pow.CalcDifficulty(chain)
+	header := block.header()
+	if hashRes := newHasher(&header).Hash(&header); !meetsDifficulty(hashRes, difficulty) {
+		return fmt.Errorf("%w: %s hash %x does not meet difficulty %d", ErrInvalidProofOfWork, chain.Params().PowAlgorithm, hashRes, difficulty)
+	}
+	return nil
+}
+
+// CalcDifficulty 目前难度是固定的，由链参数决定
+func (pow *ProofOfWork) CalcDifficulty(chain ChainReader) int {
+	return chain.Params().Genesis.Difficulty
+}
+
+// Weight 挖出区块平均需要的hash次数，也就是16^difficulty
+func (pow *ProofOfWork) Weight(chain ChainReader, block *Block) *big.Int {
+	return new(big.Int).Exp(big.NewInt(16), big.NewInt(int64(pow.CalcDifficulty(chain))), nil)
+}
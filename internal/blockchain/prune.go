@@ -0,0 +1,80 @@
+package blockchain
+
+import "errors"
+
+// pruneBatchSize 可以裁剪的区块攒够这么多个才裁剪一次
+// 快照可能还引用着区块切片，裁剪时要复制一份再改，攒一批再复制可以摊薄开销
+const pruneBatchSize = 64
+
+var (
+	// ErrPruned 需要的交易已经被裁剪掉了
+	ErrPruned = errors.New("block body pruned")
+	// ErrPruneUnsupported 权益证明要从历史区块的交易里算出验证者的权益，不能裁剪
+	ErrPruneUnsupported = errors.New("consensus engine does not support pruning")
+)
+
+// SetPruneDepth 只保留最近depth个区块的交易，更早的区块只留下区块头，不大于0表示不裁剪
+// 账本状态不受影响，余额、nonce和权益照常查询；已经裁剪掉的交易不会再恢复，调大depth也一样
+// 区块头和裁剪点的账本状态会一直保留，ValidateChain照样能校验hash、链接、工作量或签名和checkpoint，
+// 交易则只从裁剪点之后开始校验；交易被裁剪掉的区块也不能再用DisconnectTip断开
+func (blockchain *Blockchain) SetPruneDepth(depth int) error {
+	if _, ok := blockchain.engine.(*ProofOfStake); ok && depth > 0 {
+		return ErrPruneUnsupported
+	}
+	blockchain.mu.Lock()
+	defer blockchain.mu.Unlock()
+	blockchain.pruneDepth = depth
+	blockchain.prune(true)
+	return nil
+}
+
+// PrunedHeight 返回交易已经被裁剪掉的最高区块，0表示没有裁剪过(创世区块不会被裁剪)
+func (blockchain *Blockchain) PrunedHeight() int {
+	blockchain.mu.RLock()
+	defer blockchain.mu.RUnlock()
+	return blockchain.prunedHeight
+}
+
+// prune 把离末端超过pruneDepth的区块的交易裁剪掉，force为false时攒够pruneBatchSize个区块才裁剪
+// 调用方需要持有链的写锁
+func (blockchain *Blockchain) prune(force bool) {
+	if blockchain.pruneDepth <= 0 {
+		return
+	}
+	target := len(blockchain.blocks) - 1 - blockchain.pruneDepth
+	if target <= blockchain.prunedHeight || (!force && target-blockchain.prunedHeight < pruneBatchSize) {
+		return
+	}
+
+	//区块一旦上链就不能再改，快照里的区块得保持原样，所以改的是复制出来的切片
+	blocks := append(make([]Block, 0, cap(blockchain.blocks)), blockchain.blocks...)
+	state := prunedBaseState(blocks[0], blockchain.prunedState)
+	for height := blockchain.prunedHeight + 1; height <= target; height++ {
+		state.applyBlock(blocks[height])
+		blocks[height].pruneBody()
+	}
+	blockchain.blocks = blocks
+	blockchain.prunedHeight = target
+	blockchain.prunedState = state
+}
+
+// pruneBody 裁剪掉区块的交易，只留下区块头，区块的hash不变
+func (block *Block) pruneBody() {
+	if block.prunedRoot != nil {
+		return
+	}
+	root := block.contentRoot()
+	block.transactions = nil
+	block.prunedRoot = &root
+}
+
+// prunedBaseState 返回裁剪点的账本状态的副本，从裁剪点之后的区块开始重放就能得到当前状态
+// 没有裁剪过的话裁剪点就是创世区块
+func prunedBaseState(genesis Block, prunedState *ledgerState) *ledgerState {
+	if prunedState != nil {
+		return prunedState.clone()
+	}
+	state := newLedgerState()
+	state.applyBlock(genesis)
+	return state
+}
@@ -0,0 +1,133 @@
+package blockchain
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultSignatureCacheSize 默认最多缓存多少笔已经验过签名的交易
+const DefaultSignatureCacheSize = 100000
+
+// sigCache 已经验过签名的交易，同一笔交易进池的时候验过一次，打包、接收区块、校验整条链时就不用再验了
+// 交易id是交易内容和签名一起算出来的hash，id相同就说明(交易, 签名)都相同，所以只用记id
+// 容量是有限的，满了之后按放进来的顺序淘汰最早的
+type sigCache struct {
+	mu      sync.Mutex
+	maxSize int
+	entries map[string]struct{}
+	order   []string //环形缓冲区，next指向下一个要被淘汰的位置
+	next    int
+}
+
+func newSigCache(maxSize int) *sigCache {
+	return &sigCache{maxSize: maxSize, entries: map[string]struct{}{}}
+}
+
+// setMaxSize 调整容量，已经缓存的内容会被清空，不大于0表示不缓存
+func (cache *sigCache) setMaxSize(maxSize int) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.maxSize = maxSize
+	cache.entries = map[string]struct{}{}
+	cache.order = nil
+	cache.next = 0
+}
+
+func (cache *sigCache) has(id string) bool {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	_, ok := cache.entries[id]
+	return ok
+}
+
+func (cache *sigCache) add(id string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.maxSize <= 0 {
+		return
+	}
+	if _, ok := cache.entries[id]; ok {
+		return
+	}
+	if len(cache.order) < cache.maxSize {
+		cache.order = append(cache.order, id)
+	} else {
+		delete(cache.entries, cache.order[cache.next])
+		cache.order[cache.next] = id
+		cache.next = (cache.next + 1) % cache.maxSize
+	}
+	cache.entries[id] = struct{}{}
+}
+
+// verify 校验交易的签名，cache为nil时不缓存；只有验证通过的交易才会放进缓存
+func (cache *sigCache) verify(t *Transaction) error {
+	if t.from == MinerRewardFromAddress {
+		return nil
+	}
+	if cache == nil {
+		return t.verifySignature()
+	}
+	id := t.ID()
+	if cache.has(id) {
+		return nil
+	}
+	if err := t.verifySignature(); err != nil {
+		return err
+	}
+	cache.add(id)
+	return nil
+}
+
+// signatureVerifier 用workers个goroutine并行地校验一批交易的签名，验过的交易记在cache里
+// 零值也能用：不缓存，goroutine数取GOMAXPROCS
+type signatureVerifier struct {
+	cache   *sigCache
+	workers int
+}
+
+// verify 返回每笔交易签名校验的结果，和transactions一一对应
+func (verifier signatureVerifier) verify(transactions []Transaction) []error {
+	errs := make([]error, len(transactions))
+	workers := verifier.workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, len(transactions))
+
+	//交易的校验时间差不多，不过矿工奖励和缓存命中的交易几乎不花时间，所以按顺序领任务而不是事先平分
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= len(transactions) {
+					return
+				}
+				errs[i] = verifier.cache.verify(&transactions[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// SetSignatureCacheSize 设置最多缓存多少笔验过签名的交易，已经缓存的内容会被清空，不大于0表示不缓存
+func (blockchain *Blockchain) SetSignatureCacheSize(size int) {
+	blockchain.sigCache.setMaxSize(size)
+}
+
+// SetValidationWorkers 设置校验区块和整条链时并行验签名的goroutine数，不大于0表示用GOMAXPROCS
+func (blockchain *Blockchain) SetValidationWorkers(workers int) {
+	blockchain.mu.Lock()
+	defer blockchain.mu.Unlock()
+	blockchain.verifyWorkers = workers
+}
+
+// signatureVerifier 调用方需要持有链的锁
+func (blockchain *Blockchain) signatureVerifier() signatureVerifier {
+	return signatureVerifier{cache: blockchain.sigCache, workers: blockchain.verifyWorkers}
+}
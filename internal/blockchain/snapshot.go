@@ -0,0 +1,179 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNoStateCommitment 这个高度的区块里没有状态承诺，不能导出或者导入快照
+	ErrNoStateCommitment = errors.New("no state commitment at height")
+	// ErrSnapshotMismatch 快照和链上的状态承诺对不上，快照被改过或者不是这条链的
+	ErrSnapshotMismatch = errors.New("snapshot does not match the chain")
+	// ErrUntrustedSnapshot 快照的高度没有checkpoint，没法确认快照在大家认的那条链上
+	ErrUntrustedSnapshot = errors.New("no checkpoint at snapshot height")
+)
+
+// StateSnapshot 账本在某个高度的状态，加上创世区块之后到这个高度的区块头，新节点拿到它就能快速启动
+// Root是账本状态的hash，必须和这个高度的区块里的状态承诺一致，而这个高度的区块必须和checkpoint一致
+// 区块头的工作量只按固定的难度校验，光靠它挡不住提供快照的节点伪造一串区块头和任意的状态承诺，所以checkpoint是必须的
+type StateSnapshot struct {
+	Height   int                `json:"height"`
+	Hash     string             `json:"hash"` //快照高度的区块hash
+	Root     string             `json:"root"` //账本状态的hash
+	Balances map[string]float64 `json:"balances"`
+	Nonces   map[string]uint64  `json:"nonces"`
+	Stakes   map[string]float64 `json:"stakes"`
+	Headers  []BlockInfo        `json:"headers"` //高度1到Height的区块头
+}
+
+func (snapshot *StateSnapshot) state() *ledgerState {
+	state := newLedgerState()
+	for address, balance := range snapshot.Balances {
+		state.balances[address] = balance
+	}
+	for address, nonce := range snapshot.Nonces {
+		state.nonces[address] = nonce
+	}
+	for address, stake := range snapshot.Stakes {
+		state.stakes[address] = stake
+	}
+	return state
+}
+
+// HeaderInfo 只有区块头的区块信息，和交易被裁剪掉的区块一样，用来在节点之间同步区块头
+func (block *Block) HeaderInfo(height int) BlockInfo {
+	header := *block
+	header.pruneBody()
+	return header.Info(height)
+}
+
+// stateRootAfter 在before上应用区块之后的账本状态的hash，before不会被修改
+func stateRootAfter(before *ledgerState, block *Block) string {
+	after := before.clone()
+	after.applyBlock(*block)
+	return after.root()
+}
+
+// checkStateRoot 高度是SnapshotInterval倍数的区块必须承诺应用它之后的账本状态，其他区块不能带状态承诺
+// before是应用这个区块之前的账本状态
+func checkStateRoot(params ChainParams, height int, before *ledgerState, block *Block) error {
+	want := ""
+	if params.commitsState(height) {
+		want = stateRootAfter(before, block)
+	}
+	if block.stateRoot != want {
+		return fmt.Errorf("%w: got %q want %q", ErrStateRootMismatch, block.stateRoot, want)
+	}
+	return nil
+}
+
+// commitState 需要承诺账本状态的高度，在区块里写上应用这个区块之后的账本状态的hash
+// 必须在Prepare之后、算区块hash之前调用；链的末端已经变了的话返回ErrStaleTip
+func (blockchain *Blockchain) commitState(block *Block) error {
+	blockchain.mu.RLock()
+	defer blockchain.mu.RUnlock()
+	if !blockchain.params.commitsState(len(blockchain.blocks)) {
+		return nil
+	}
+	if block.prevHash != blockchain.getLatestBlock().hash {
+		return ErrStaleTip
+	}
+	block.stateRoot = stateRootAfter(blockchain.state, block)
+	return nil
+}
+
+// ExportSnapshot 导出高度height的快照，height必须是有状态承诺的高度，不大于0表示最近一个有状态承诺的高度
+// 这个高度之前的区块交易已经被裁剪掉的话，算不出那时的账本状态，返回ErrPruned
+func (blockchain *Blockchain) ExportSnapshot(height int) (StateSnapshot, error) {
+	blockchain.mu.RLock()
+	defer blockchain.mu.RUnlock()
+
+	tip := len(blockchain.blocks) - 1
+	if height <= 0 && blockchain.params.SnapshotInterval > 0 {
+		height = tip - tip%blockchain.params.SnapshotInterval
+	}
+	if height <= 0 || height > tip || !blockchain.params.commitsState(height) {
+		return StateSnapshot{}, fmt.Errorf("%w: %d", ErrNoStateCommitment, height)
+	}
+	if height < blockchain.prunedHeight {
+		return StateSnapshot{}, fmt.Errorf("%w: ledger state at height %d", ErrPruned, height)
+	}
+
+	state := prunedBaseState(blockchain.blocks[0], blockchain.prunedState)
+	for _, block := range blockchain.blocks[blockchain.prunedHeight+1 : height+1] {
+		state.applyBlock(block)
+	}
+	block := blockchain.blocks[height]
+	//和链上的承诺对不上说明本地的数据有问题，不能导出去
+	if root := state.root(); root != block.stateRoot {
+		return StateSnapshot{}, fmt.Errorf("%w: ledger state %s at height %d, committed %s", ErrSnapshotMismatch, root, height, block.stateRoot)
+	}
+	snapshot := StateSnapshot{
+		Height:   height,
+		Hash:     block.hash,
+		Root:     block.stateRoot,
+		Balances: state.balances,
+		Nonces:   state.nonces,
+		Stakes:   state.stakes,
+		Headers:  make([]BlockInfo, 0, height),
+	}
+	for h := 1; h <= height; h++ {
+		snapshot.Headers = append(snapshot.Headers, blockchain.blocks[h].HeaderInfo(h))
+	}
+	return snapshot, nil
+}
+
+// NewBlockchainFromSnapshot 用快照启动一个节点，不用从创世区块开始重放和校验所有交易
+// 快照里的区块头会逐个校验hash、链接、共识规则和checkpoint，快照的账本状态要和快照高度的区块里的状态承诺一致
+// params里必须有快照高度的checkpoint，没有的话返回ErrUntrustedSnapshot：checkpoint要从可信的来源拿，不能来自提供快照的节点
+// 快照高度及之前的区块和被裁剪掉交易的区块一样只有区块头；之后的区块用ProcessBlock接上来，从快照的账本状态开始校验
+// 权益证明要从历史区块的交易里算出验证者的权益，不能从快照启动
+func NewBlockchainFromSnapshot(params ChainParams, engine ConsensusEngine, snapshot StateSnapshot) (*Blockchain, error) {
+	if _, ok := engine.(*ProofOfStake); ok {
+		return nil, ErrPruneUnsupported
+	}
+	blockchain, err := NewBlockchainWithEngine(params, engine)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.Height <= 0 || len(snapshot.Headers) != snapshot.Height {
+		return nil, fmt.Errorf("%w: %d headers for snapshot at height %d", ErrSnapshotMismatch, len(snapshot.Headers), snapshot.Height)
+	}
+	if lastCheckpointHeight(blockchain.checkpoints, snapshot.Height+1) != snapshot.Height {
+		return nil, fmt.Errorf("%w: %d", ErrUntrustedSnapshot, snapshot.Height)
+	}
+
+	//节点还没有对外提供服务，不用加锁
+	for i, info := range snapshot.Headers {
+		height := i + 1
+		block := NewBlockFromInfo(info)
+		if block.hash != block.computeHash() {
+			return nil, newBlockError(height, ErrTamperedBlock)
+		}
+		block.pruneBody()
+		if block.prevHash != blockchain.getLatestBlock().hash {
+			return nil, newBlockError(height, ErrBrokenLink)
+		}
+		if err := blockchain.engine.VerifySeal(blockchain.snapshot(height-1), &block); err != nil {
+			return nil, newBlockError(height, err)
+		}
+		if err := blockchain.checkCheckpoint(height, block.hash); err != nil {
+			return nil, newBlockError(height, err)
+		}
+		blockchain.blocks = append(blockchain.blocks, block)
+	}
+
+	tip := blockchain.getLatestBlock()
+	if !params.commitsState(snapshot.Height) || tip.stateRoot == "" {
+		return nil, fmt.Errorf("%w: %d", ErrNoStateCommitment, snapshot.Height)
+	}
+	state := snapshot.state()
+	if root := state.root(); snapshot.Hash != tip.hash || root != tip.stateRoot {
+		return nil, fmt.Errorf("%w: ledger state %s for block %s, committed %s for block %s", ErrSnapshotMismatch, root, snapshot.Hash, tip.stateRoot, tip.hash)
+	}
+	blockchain.state = state
+	blockchain.prunedHeight = snapshot.Height
+	blockchain.prunedState = state.clone()
+	return blockchain, nil
+}
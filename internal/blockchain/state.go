@@ -0,0 +1,134 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+)
+
+// ledgerState 账本状态：每个地址的余额、锁定的权益，以及已经上链的交易数(下一笔交易应该使用的nonce)
+// 按顺序把区块里的交易应用到账本上就能得到当前状态
+type ledgerState struct {
+	balances map[string]float64
+	nonces   map[string]uint64
+	stakes   map[string]float64
+}
+
+func newLedgerState() *ledgerState {
+	return &ledgerState{
+		balances: map[string]float64{},
+		nonces:   map[string]uint64{},
+		stakes:   map[string]float64{},
+	}
+}
+
+// applyTransaction 把一笔交易记到账本上，发送者付出金额和手续费，收款人收到金额
+// 手续费会记在矿工奖励交易里，所以这里不用单独处理
+// 转给StakeAddress的钱会锁定成发送者的权益
+func (state *ledgerState) applyTransaction(t Transaction) {
+	if t.from != MinerRewardFromAddress {
+		state.balances[t.from] -= t.amount + t.fee
+		state.nonces[t.from] = t.nonce + 1
+	}
+	if t.to == StakeAddress && t.from != MinerRewardFromAddress {
+		state.stakes[t.from] += t.amount
+		return
+	}
+	state.balances[t.to] += t.amount
+}
+
+// applyBlock 应用区块里的交易，区块里带的双签证据会罚没对应验证者锁定的全部权益
+func (state *ledgerState) applyBlock(block Block) {
+	for _, t := range block.transactions {
+		state.applyTransaction(t)
+	}
+	for _, evidence := range block.slashings {
+		delete(state.stakes, evidence.validator())
+	}
+}
+
+// pendingBalances 在账本状态之上按顺序记下一串交易之后的余额，不会修改底下的账本状态
+// 校验区块和挑选要打包的交易时，用它检查每笔交易的发送者在前面的交易之后还付不付得起
+type pendingBalances struct {
+	state    *ledgerState
+	parent   *pendingBalances //不为nil时叠在parent上面，state不用
+	balances map[string]float64
+}
+
+func newPendingBalances(state *ledgerState) *pendingBalances {
+	return &pendingBalances{state: state, balances: map[string]float64{}}
+}
+
+// child 在p上面再叠一层，用来试算一批交易，确定要用的时候commit回p
+func (p *pendingBalances) child() *pendingBalances {
+	return &pendingBalances{parent: p, balances: map[string]float64{}}
+}
+
+func (p *pendingBalances) commit() {
+	for address, balance := range p.balances {
+		p.parent.balances[address] = balance
+	}
+}
+
+func (p *pendingBalances) balance(address string) float64 {
+	if balance, ok := p.balances[address]; ok {
+		return balance
+	}
+	if p.parent != nil {
+		return p.parent.balance(address)
+	}
+	return p.state.balances[address]
+}
+
+// spend 检查发送者付不付得起，付得起的话和applyTransaction一样记下余额的变化
+func (p *pendingBalances) spend(t Transaction) error {
+	if t.from != MinerRewardFromAddress {
+		balance := p.balance(t.from)
+		if err := checkBalance(balance, t); err != nil {
+			return err
+		}
+		p.balances[t.from] = balance - (t.amount + t.fee)
+	}
+	if t.to != StakeAddress || t.from == MinerRewardFromAddress {
+		p.balances[t.to] = p.balance(t.to) + t.amount
+	}
+	return nil
+}
+
+// clone 复制一份账本状态，改副本不会影响原来的
+func (state *ledgerState) clone() *ledgerState {
+	copied := newLedgerState()
+	for address, balance := range state.balances {
+		copied.balances[address] = balance
+	}
+	for address, nonce := range state.nonces {
+		copied.nonces[address] = nonce
+	}
+	for address, stake := range state.stakes {
+		copied.stakes[address] = stake
+	}
+	return copied
+}
+
+// root 账本状态的hash，区块里的状态承诺和快照的校验都用它
+// 地址排好序逐项写进去，值为0的项和没有这一项是一样的，跳过，这样不管状态是重放出来的还是从快照导入的，算出来的结果都一致
+func (state *ledgerState) root() string {
+	digest := sha256.New()
+	for _, address := range sortedAddresses(state.balances) {
+		if balance := state.balances[address]; balance != 0 {
+			fmt.Fprintf(digest, "balance %s %s\n", address, strconv.FormatFloat(balance, 'g', -1, 64))
+		}
+	}
+	for _, address := range sortedAddresses(state.nonces) {
+		if nonce := state.nonces[address]; nonce != 0 {
+			fmt.Fprintf(digest, "nonce %s %d\n", address, nonce)
+		}
+	}
+	for _, address := range sortedAddresses(state.stakes) {
+		if stake := state.stakes[address]; stake != 0 {
+			fmt.Fprintf(digest, "stake %s %s\n", address, strconv.FormatFloat(stake, 'g', -1, 64))
+		}
+	}
+	return hex.EncodeToString(digest.Sum(nil))
+}
@@ -0,0 +1,134 @@
+package blockchain
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MiningStats 矿工的运行状态，用来判断矿工是否健康
+type MiningStats struct {
+	Mining          bool    `json:"mining"`          //当前是否正在挖矿
+	Difficulty      int     `json:"difficulty"`      //当前(或上一次)挖矿的难度
+	Workers         int     `json:"workers"`         //并行的goroutine数
+	Attempts        uint64  `json:"attempts"`        //当前(或上一次)挖矿已经尝试的nonce数
+	HashRate        float64 `json:"hashRate"`        //每秒计算的hash数
+	NonceRangeStart int     `json:"nonceRangeStart"` //已经尝试过的nonce范围，worker都是从0开始试的
+	NonceRangeEnd   int     `json:"nonceRangeEnd"`
+	ElapsedSeconds  float64 `json:"elapsedSeconds"`  //当前(或上一次)挖矿花的时间
+	ExpectedSeconds float64 `json:"expectedSeconds"` //按当前算力，挖出一个区块平均需要的时间
+	BlocksMined     uint64  `json:"blocksMined"`     //一共挖出并且接到链上的区块数
+	TotalAttempts   uint64  `json:"totalAttempts"`   //一共尝试过的nonce数
+	ExtraNonce      uint64  `json:"extraNonce"`      //当前(或上一次)挖矿用到的extra nonce，nonce试完一轮加一
+}
+
+// miningTelemetry 挖矿过程中由各个worker更新的统计数据
+type miningTelemetry struct {
+	attempts      atomic.Uint64
+	maxNonce      atomic.Int64
+	totalAttempts atomic.Uint64
+	extraNonce    atomic.Uint64
+
+	mu          sync.Mutex
+	mining      bool
+	difficulty  int
+	workers     int
+	started     time.Time
+	finished    time.Time
+	sealed      bool //上一次挖矿找到了nonce，区块还没接到链上
+	blocksMined uint64
+}
+
+func (telemetry *miningTelemetry) start(difficulty, workers int) {
+	telemetry.mu.Lock()
+	defer telemetry.mu.Unlock()
+	telemetry.mining = true
+	telemetry.difficulty = difficulty
+	telemetry.workers = workers
+	telemetry.started = time.Now()
+	telemetry.sealed = false
+	telemetry.attempts.Store(0)
+	telemetry.maxNonce.Store(0)
+	telemetry.extraNonce.Store(0)
+}
+
+func (telemetry *miningTelemetry) finish(found bool) {
+	telemetry.mu.Lock()
+	defer telemetry.mu.Unlock()
+	telemetry.mining = false
+	telemetry.finished = time.Now()
+	telemetry.sealed = found
+}
+
+// blockConnected 挖出来的区块接到链上之后才算数，封装好了但末端已经变了或者校验没通过的不算
+// 不用挖矿的共识引擎封装的区块也不算
+func (telemetry *miningTelemetry) blockConnected() {
+	telemetry.mu.Lock()
+	defer telemetry.mu.Unlock()
+	if telemetry.sealed {
+		telemetry.blocksMined++
+		telemetry.sealed = false
+	}
+}
+
+// record worker每尝试一批nonce汇报一次，避免每个hash都去竞争同一个计数器
+func (telemetry *miningTelemetry) record(attempts uint64, nonce int) {
+	if telemetry == nil {
+		return
+	}
+	telemetry.attempts.Add(attempts)
+	telemetry.totalAttempts.Add(attempts)
+	for {
+		cur := telemetry.maxNonce.Load()
+		if int64(nonce) <= cur || telemetry.maxNonce.CompareAndSwap(cur, int64(nonce)) {
+			return
+		}
+	}
+}
+
+// rollExtraNonce 换了extra nonce之后nonce又从头开始试
+func (telemetry *miningTelemetry) rollExtraNonce(extraNonce uint64) {
+	if telemetry == nil {
+		return
+	}
+	telemetry.extraNonce.Store(extraNonce)
+	telemetry.maxNonce.Store(0)
+}
+
+func (telemetry *miningTelemetry) stats() MiningStats {
+	telemetry.mu.Lock()
+	defer telemetry.mu.Unlock()
+
+	stats := MiningStats{
+		Mining:        telemetry.mining,
+		Difficulty:    telemetry.difficulty,
+		Workers:       telemetry.workers,
+		Attempts:      telemetry.attempts.Load(),
+		BlocksMined:   telemetry.blocksMined,
+		TotalAttempts: telemetry.totalAttempts.Load(),
+		ExtraNonce:    telemetry.extraNonce.Load(),
+	}
+	if telemetry.started.IsZero() {
+		return stats
+	}
+
+	end := time.Now()
+	if !telemetry.mining {
+		end = telemetry.finished
+	}
+	elapsed := end.Sub(telemetry.started).Seconds()
+	stats.ElapsedSeconds = elapsed
+	//worker都从0开始试，NonceRangeStart就是0
+	if stats.Attempts > 0 {
+		stats.NonceRangeEnd = int(telemetry.maxNonce.Load())
+	}
+	if elapsed > 0 {
+		stats.HashRate = float64(stats.Attempts) / elapsed
+	}
+	if stats.HashRate > 0 {
+		//hash是十六进制的，开头要有difficulty个0，平均要尝试16^difficulty次
+		stats.ExpectedSeconds = math.Pow(16, float64(telemetry.difficulty)) / stats.HashRate
+	}
+	return stats
+}
@@ -0,0 +1,111 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// 区块和交易校验失败的原因，都会包在ValidationError里返回，可以用errors.Is判断
+var (
+	ErrTamperedBlock        = errors.New("block hash does not match its contents")
+	ErrBrokenLink           = errors.New("block does not link to the previous block")
+	ErrInvalidTxSignature   = errors.New("invalid transaction signature")
+	ErrInvalidAmount        = errors.New("negative or non-finite amount or fee")
+	ErrOverspend            = errors.New("transaction spends more than allowed")
+	ErrNonceGap             = errors.New("transaction nonce skips ahead of sender's confirmed transactions")
+	ErrMisplacedMinerReward = errors.New("miner reward transaction must be the last transaction of a mined block")
+	ErrStateRootMismatch    = errors.New("block state commitment does not match the ledger state")
+)
+
+// ValidationError 校验失败的位置和原因，用errors.As取出来就能知道是哪个区块、哪笔交易出了问题
+type ValidationError struct {
+	BlockIndex int    //出问题的区块高度，-1表示交易还没进区块
+	TxID       string //出问题的交易，为空表示是区块本身的问题
+	Err        error  //具体原因，一般是上面的某个错误
+}
+
+func (e *ValidationError) Error() string {
+	prefix := ""
+	if e.BlockIndex >= 0 {
+		prefix = fmt.Sprintf("block %d: ", e.BlockIndex)
+	}
+	if e.TxID != "" {
+		prefix += fmt.Sprintf("transaction %s: ", e.TxID)
+	}
+	return prefix + e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+func newBlockError(index int, err error) error {
+	return &ValidationError{BlockIndex: index, Err: err}
+}
+
+func newTxError(t *Transaction, err error) error {
+	return &ValidationError{BlockIndex: -1, TxID: t.ID(), Err: err}
+}
+
+// atBlock 把交易的校验错误标上所在区块的高度
+func atBlock(err error, index int) error {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		validationErr.BlockIndex = index
+		return validationErr
+	}
+	return newBlockError(index, err)
+}
+
+// validAmount 金额和手续费必须是有限的非负数
+// NaN和任何数比较都是false，写成x < 0的话NaN会混过去，之后余额检查也拦不住它，账上的余额就永远是NaN了
+func validAmount(x float64) bool {
+	return x >= 0 && !math.IsInf(x, 1)
+}
+
+// checkBalance 发送者的余额要够付交易的金额加手续费，锁定权益也一样
+// balance是发送者在这笔交易之前的余额，要把同一个区块(或者交易池)里排在前面的交易都算上
+func checkBalance(balance float64, t Transaction) error {
+	if !(balance >= t.amount+t.fee) {
+		return fmt.Errorf("%w: %w: balance %v", ErrOverspend, ErrInsufficientBalance, balance)
+	}
+	return nil
+}
+
+// validateBlockAgainstState 按区块之前的账本状态校验区块里的交易：
+// 每个发送者的nonce必须从已确认的交易数开始连续递增，余额要够付前面的交易之后的金额加手续费，矿工奖励不能超过出块奖励加手续费
+func validateBlockAgainstState(state *ledgerState, block Block, minerReward float64) error {
+	nextNonce := map[string]uint64{}
+	balances := newPendingBalances(state)
+	reward := minerReward
+	for _, t := range block.transactions {
+		if t.from == MinerRewardFromAddress {
+			//负数的奖励会从收款地址扣钱，谁都能拿它烧掉别人的余额
+			if !validAmount(t.amount) {
+				return newTxError(&t, ErrInvalidAmount)
+			}
+			//和生成区块模板时一样的顺序累加，浮点数的结果才能完全一致
+			if t.amount > reward {
+				return newTxError(&t, fmt.Errorf("%w: miner reward %v exceeds %v", ErrOverspend, t.amount, reward))
+			}
+			continue
+		}
+		expected, ok := nextNonce[t.from]
+		if !ok {
+			expected = state.nonces[t.from]
+		}
+		if t.nonce < expected {
+			return newTxError(&t, fmt.Errorf("%w: nonce %d already confirmed", ErrDoubleSpend, t.nonce))
+		}
+		if t.nonce > expected {
+			return newTxError(&t, fmt.Errorf("%w: nonce %d want %d", ErrNonceGap, t.nonce, expected))
+		}
+		nextNonce[t.from] = expected + 1
+		if err := balances.spend(t); err != nil {
+			return newTxError(&t, err)
+		}
+		reward += t.fee
+	}
+	return nil
+}
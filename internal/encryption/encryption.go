@@ -48,8 +48,11 @@ func SignMessage(privateKey string, message string) (string, error) {
 		return "", err
 	}
 
-	// 将签名转换为字节数组
-	signature := append(r.Bytes(), s.Bytes()...)
+	// 将签名转换为字节数组，r和s各补齐到曲线的字节长度，校验时才能从中间正确地分开
+	byteLen := (privateKeyECDSA.PublicKey.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*byteLen)
+	r.FillBytes(signature[:byteLen])
+	s.FillBytes(signature[byteLen:])
 
 	// 将签名转换为十六进制字符串
 	return hex.EncodeToString(signature), nil
@@ -0,0 +1,150 @@
+package encryption
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// Scrypt 按RFC 7914实现的scrypt密钥派生函数
+// N是CPU/内存开销(必须是大于1的2的幂)，r是块大小，p是并行度，计算一次需要 128*r*N 字节的内存
+// 内存开销大，用专用硬件也很难比普通电脑快多少，所以适合用作工作量证明的hash
+func Scrypt(password, salt []byte, N, r, p, keyLen int) ([]byte, error) {
+	if N <= 1 || N&(N-1) != 0 {
+		return nil, errors.New("scrypt: N must be a power of 2 greater than 1")
+	}
+	if r <= 0 || p <= 0 || uint64(r)*uint64(p) >= 1<<30 || r > (1<<31-1)/128/p || N > (1<<31-1)/128/r {
+		return nil, errors.New("scrypt: parameters are too large")
+	}
+	if keyLen <= 0 {
+		return nil, errors.New("scrypt: key length must be positive")
+	}
+
+	words := 32 * r
+	b := pbkdf2SHA256(password, salt, 1, p*128*r)
+	xy := make([]uint32, 2*words)
+	v := make([]uint32, N*words)
+	for i := 0; i < p; i++ {
+		romix(b[i*128*r:(i+1)*128*r], r, N, v, xy)
+	}
+	return pbkdf2SHA256(password, b, 1, keyLen), nil
+}
+
+// romix 先按顺序生成N个块存起来，再按数据决定的顺序随机读回来，这一步决定了scrypt要用多少内存
+func romix(b []byte, r, N int, v, xy []uint32) {
+	words := 32 * r
+	x, y := xy[:words], xy[words:]
+	for i := range x {
+		x[i] = binary.LittleEndian.Uint32(b[i*4:])
+	}
+	for i := 0; i < N; i++ {
+		copy(v[i*words:], x)
+		blockMix(x, y, r)
+	}
+	for i := 0; i < N; i++ {
+		j := int(integerify(x, r) & uint64(N-1))
+		for k, w := range v[j*words : (j+1)*words] {
+			x[k] ^= w
+		}
+		blockMix(x, y, r)
+	}
+	for i, w := range x {
+		binary.LittleEndian.PutUint32(b[i*4:], w)
+	}
+}
+
+// blockMix 对2r个64字节的块依次做Salsa20/8，结果偶数块放前半部分、奇数块放后半部分，y是临时空间
+func blockMix(b, y []uint32, r int) {
+	var x [16]uint32
+	copy(x[:], b[(2*r-1)*16:])
+	for i := 0; i < 2*r; i++ {
+		for j := range x {
+			x[j] ^= b[i*16+j]
+		}
+		salsa208(&x)
+		offset := (i / 2) * 16
+		if i%2 == 1 {
+			offset += r * 16
+		}
+		copy(y[offset:], x[:])
+	}
+	copy(b, y)
+}
+
+// integerify 把最后一个64字节块的开头当作小端整数
+func integerify(b []uint32, r int) uint64 {
+	j := (2*r - 1) * 16
+	return uint64(b[j]) | uint64(b[j+1])<<32
+}
+
+// salsa208 Salsa20/8核心函数
+func salsa208(b *[16]uint32) {
+	x := *b
+	for i := 0; i < 8; i += 2 {
+		//列
+		x[4] ^= bits.RotateLeft32(x[0]+x[12], 7)
+		x[8] ^= bits.RotateLeft32(x[4]+x[0], 9)
+		x[12] ^= bits.RotateLeft32(x[8]+x[4], 13)
+		x[0] ^= bits.RotateLeft32(x[12]+x[8], 18)
+		x[9] ^= bits.RotateLeft32(x[5]+x[1], 7)
+		x[13] ^= bits.RotateLeft32(x[9]+x[5], 9)
+		x[1] ^= bits.RotateLeft32(x[13]+x[9], 13)
+		x[5] ^= bits.RotateLeft32(x[1]+x[13], 18)
+		x[14] ^= bits.RotateLeft32(x[10]+x[6], 7)
+		x[2] ^= bits.RotateLeft32(x[14]+x[10], 9)
+		x[6] ^= bits.RotateLeft32(x[2]+x[14], 13)
+		x[10] ^= bits.RotateLeft32(x[6]+x[2], 18)
+		x[3] ^= bits.RotateLeft32(x[15]+x[11], 7)
+		x[7] ^= bits.RotateLeft32(x[3]+x[15], 9)
+		x[11] ^= bits.RotateLeft32(x[7]+x[3], 13)
+		x[15] ^= bits.RotateLeft32(x[11]+x[7], 18)
+		//行
+		x[1] ^= bits.RotateLeft32(x[0]+x[3], 7)
+		x[2] ^= bits.RotateLeft32(x[1]+x[0], 9)
+		x[3] ^= bits.RotateLeft32(x[2]+x[1], 13)
+		x[0] ^= bits.RotateLeft32(x[3]+x[2], 18)
+		x[6] ^= bits.RotateLeft32(x[5]+x[4], 7)
+		x[7] ^= bits.RotateLeft32(x[6]+x[5], 9)
+		x[4] ^= bits.RotateLeft32(x[7]+x[6], 13)
+		x[5] ^= bits.RotateLeft32(x[4]+x[7], 18)
+		x[11] ^= bits.RotateLeft32(x[10]+x[9], 7)
+		x[8] ^= bits.RotateLeft32(x[11]+x[10], 9)
+		x[9] ^= bits.RotateLeft32(x[8]+x[11], 13)
+		x[10] ^= bits.RotateLeft32(x[9]+x[8], 18)
+		x[12] ^= bits.RotateLeft32(x[15]+x[14], 7)
+		x[13] ^= bits.RotateLeft32(x[12]+x[15], 9)
+		x[14] ^= bits.RotateLeft32(x[13]+x[12], 13)
+		x[15] ^= bits.RotateLeft32(x[14]+x[13], 18)
+	}
+	for i := range b {
+		b[i] += x[i]
+	}
+}
+
+// pbkdf2SHA256 以HMAC-SHA256为伪随机函数的PBKDF2
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	blocks := (keyLen + sha256.Size - 1) / sha256.Size
+	key := make([]byte, 0, blocks*sha256.Size)
+	var counter [4]byte
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sseKeepAliveInterval 没有事件的时候隔多久发一个注释行，避免连接被代理当成空闲断开
+const sseKeepAliveInterval = 15 * time.Second
+
+// SSE里的事件名
+const (
+	sseEventBlock = "block" //新区块，id是区块高度
+	sseEventTx    = "tx"    //交易进了交易池
+	sseEventReorg = "reorg" //链末端的区块被断开了，id是断开之后的链高度
+)
+
+// eventFilter 只推送和这些地址有关的区块和交易，为空表示全部推送
+type eventFilter map[string]bool
+
+func newEventFilter(r *http.Request) eventFilter {
+	filter := eventFilter{}
+	for _, value := range r.URL.Query()["address"] {
+		for _, address := range strings.Split(value, ",") {
+			if address != "" {
+				filter[address] = true
+			}
+		}
+	}
+	return filter
+}
+
+func (filter eventFilter) matchTransaction(tx blockchain.TransactionInfo) bool {
+	return len(filter) == 0 || filter[tx.From] || filter[tx.To]
+}
+
+// matchBlock 交易被裁剪掉的区块看不出和哪些地址有关，照样推送，客户端看到pruned就知道这部分数据没有了
+func (filter eventFilter) matchBlock(block blockchain.BlockInfo) bool {
+	if len(filter) == 0 || block.Pruned {
+		return true
+	}
+	for _, tx := range block.Transactions {
+		if filter.matchTransaction(tx) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastSeenHeight 客户端已经收到的最后一个区块的高度：断线重连时浏览器会带上Last-Event-ID，否则看since参数
+// 返回-1表示不需要补发历史区块
+func lastSeenHeight(r *http.Request) (int, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("since")
+	}
+	if value == "" {
+		return -1, nil
+	}
+	height, err := strconv.Atoi(value)
+	if err != nil || height < 0 {
+		return 0, fmt.Errorf("invalid last seen height %q", value)
+	}
+	return height, nil
+}
+
+// writeSSE 写一个事件，id为空的事件不会改变客户端记住的Last-Event-ID
+func writeSSE(w http.ResponseWriter, id, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
+
+// eventsHandler 用Server-Sent Events推送新区块、进池的交易和区块回滚
+// address参数(可以重复或者用逗号分隔)只推送和这些地址有关的区块和交易，回滚事件总是推送
+// 带上since参数或者Last-Event-ID的话，先补发这个高度之后的区块，再推送新的事件；补发的区块交易被裁剪掉的话pruned为true
+// 客户端读得太慢漏了事件的话连接会被断开，客户端带上Last-Event-ID重连就能补上漏掉的区块
+func (p *BlockchainServer) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	since, err := lastSeenHeight(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter := newEventFilter(r)
+
+	//先订阅再补发历史区块，补发期间接上的区块不会漏掉
+	sub := p.blockchain.Subscribe(blockchain.DefaultEventBufferSize,
+		blockchain.EventBlockConnected, blockchain.EventBlockDisconnected, blockchain.EventTxAccepted)
+	defer sub.Unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	//已经补发到的区块高度，订阅里高度不超过它的区块事件是重复的
+	sent := -1
+	if since >= 0 {
+		sent = p.blockchain.Height()
+		for height := since + 1; height <= sent; height++ {
+			if r.Context().Err() != nil {
+				return
+			}
+			block, ok := p.blockchain.GetBlock(height)
+			if !ok {
+				break
+			}
+			if err := writeBlockEvent(w, filter, block.Info(height)); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			switch event.Type {
+			case blockchain.EventBlockConnected:
+				if event.Height <= sent {
+					continue
+				}
+				sent = event.Height
+				err = writeBlockEvent(w, filter, event.Block.Info(event.Height))
+			case blockchain.EventBlockDisconnected:
+				if sent >= event.Height {
+					sent = event.Height - 1
+				}
+				err = writeSSE(w, strconv.Itoa(event.Height-1), sseEventReorg, event.Block.Info(event.Height))
+			case blockchain.EventTxAccepted:
+				if tx := event.Transaction.Info(); filter.matchTransaction(tx) {
+					err = writeSSE(w, "", sseEventTx, tx)
+				}
+			}
+			if err != nil {
+				return
+			}
+			if sub.Dropped() > 0 {
+				//漏了事件，断开让客户端带着Last-Event-ID重连补发
+				fmt.Fprint(w, ": events dropped, reconnect to resume\n\n")
+				flusher.Flush()
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeBlockEvent 被地址过滤掉的区块也要发一个只带id的空事件，客户端重连时才能从最新的高度接着收
+func writeBlockEvent(w http.ResponseWriter, filter eventFilter, block blockchain.BlockInfo) error {
+	id := strconv.Itoa(block.Height)
+	if filter.matchBlock(block) {
+		return writeSSE(w, id, sseEventBlock, block)
+	}
+	_, err := fmt.Fprintf(w, "id: %s\n\n", id)
+	return err
+}
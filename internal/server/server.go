@@ -4,21 +4,53 @@ import (
 	"CcCoin-go-version/internal/blockchain"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 )
 
 type BlockchainServer struct {
-	blockchain blockchain.Blockchain
+	blockchain *blockchain.Blockchain
+	miner      *blockchain.BackgroundMiner //后台矿工，需要通过接口或者启动参数打开
 	http.Handler
 }
 
-func NewBlockchainServer(blockchain blockchain.Blockchain) *BlockchainServer {
+func NewBlockchainServer(chain *blockchain.Blockchain) *BlockchainServer {
 	p := new(BlockchainServer)
-	p.blockchain = blockchain
+	p.blockchain = chain
+	p.miner = blockchain.NewBackgroundMiner(chain)
 
 	router := http.NewServeMux()
 	router.Handle("/transction/", http.HandlerFunc(p.transactionHandler))
 	router.Handle("/mine/", http.HandlerFunc(p.mineHandler))
+	router.Handle("/miner/start/", http.HandlerFunc(p.minerStartHandler))
+	router.Handle("/miner/stop/", http.HandlerFunc(p.minerStopHandler))
+	router.Handle("/miner/status/", http.HandlerFunc(p.minerStatusHandler))
+	//给外部矿工用的：领取区块模板，提交挖到的nonce
+	router.Handle("/getblocktemplate/", http.HandlerFunc(p.getBlockTemplateHandler))
+	router.Handle("/submitblock/", http.HandlerFunc(p.submitBlockHandler))
+	//按高度查询区块，或者接收别的节点、矿工产出的完整区块
+	router.Handle("/block/", http.HandlerFunc(p.blockHandler))
+	//用Server-Sent Events推送新区块、新交易和区块回滚
+	router.Handle("/events/", http.HandlerFunc(p.eventsHandler))
+	//导出账本快照和区块头，新节点可以从快照快速启动
+	router.Handle("/snapshot/", http.HandlerFunc(p.snapshotHandler))
+	//从创世区块开始校验整条链，不合法的话说明是哪个区块、哪笔交易出了什么问题
+	router.Handle("/validate/", http.HandlerFunc(p.validateHandler))
+	//回归测试网可以按需出块
+	if chain.Params().GenerateOnDemand {
+		router.Handle("/generate/", http.HandlerFunc(p.generateHandler))
+	}
+	//权威证明下可以查看签名者，以及投票加入或踢出签名者
+	if _, ok := chain.Engine().(*blockchain.ProofOfAuthority); ok {
+		router.Handle("/signers/", http.HandlerFunc(p.signersHandler))
+		router.Handle("/signers/propose/", http.HandlerFunc(p.signersProposeHandler))
+		router.Handle("/signers/discard/", http.HandlerFunc(p.signersDiscardHandler))
+	}
+	//权益证明下可以查看每个验证者锁定的权益
+	if _, ok := chain.Engine().(*blockchain.ProofOfStake); ok {
+		router.Handle("/validators/", http.HandlerFunc(p.validatorsHandler))
+	}
 
 	p.Handler = router
 	return p
@@ -32,6 +64,8 @@ func (p *BlockchainServer) addTransaction(w http.ResponseWriter, r *http.Request
 		SenderPrivateKey  string  `json:"SenderPrivateKey"`
 		ReceiverPublicKey string  `json:"ReceiverPublicKey"`
 		Amount            float64 `json:"Amount"`
+		Fee               float64 `json:"Fee"`
+		Nonce             *uint64 `json:"Nonce"` //不传的话就用发送者下一个可用的nonce
 	}
 	err := json.NewDecoder(r.Body).Decode(&txData)
 	if err != nil {
@@ -45,22 +79,38 @@ func (p *BlockchainServer) addTransaction(w http.ResponseWriter, r *http.Request
 	}
 
 	// 使用NewTransaction方法创建Transaction对象
-	tx, err := blockchain.NewTransaction(txData.SenderPublicKey, txData.SenderPrivateKey, txData.ReceiverPublicKey, txData.Amount)
+	nonce := p.blockchain.PendingNonce(txData.SenderPublicKey)
+	if txData.Nonce != nil {
+		nonce = *txData.Nonce
+	}
+	tx, err := blockchain.NewTransactionWithNonce(txData.SenderPublicKey, txData.SenderPrivateKey, txData.ReceiverPublicKey, txData.Amount, txData.Fee, nonce)
 	if err != nil {
 		http.Error(w, "Failed to create transaction", http.StatusBadRequest)
 		return err
 	}
 
 	// 验证交易
-	if !tx.IsValid() {
-		http.Error(w, "Invalid transaction", http.StatusBadRequest)
-		return errors.New("invalid transaction")
+	if err := tx.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
 	}
 
 	// 添加交易到交易池
 	err = p.blockchain.AddTransction2Pool(tx)
 	if err != nil {
-		http.Error(w, "Failed to add transaction to pool", http.StatusInternalServerError)
+		switch {
+		//手续费不够或者池子满了属于客户端的问题，需要提高手续费再重试
+		case errors.Is(err, blockchain.ErrMempoolFeeTooLow), errors.Is(err, blockchain.ErrMempoolFull),
+			errors.Is(err, blockchain.ErrMempoolDuplicateTx), errors.Is(err, blockchain.ErrMempoolTxTooLarge),
+			errors.Is(err, blockchain.ErrInsufficientBalance), errors.Is(err, blockchain.ErrInvalidTxSignature),
+			errors.Is(err, blockchain.ErrInvalidAmount), errors.Is(err, blockchain.ErrMisplacedMinerReward):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		//双花交易和池子里或链上已有的交易冲突
+		case errors.Is(err, blockchain.ErrMempoolConflict), errors.Is(err, blockchain.ErrDoubleSpend):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "Failed to add transaction to pool", http.StatusInternalServerError)
+		}
 		return err
 	}
 
@@ -82,7 +132,8 @@ func (p *BlockchainServer) startMineTask(w http.ResponseWriter, r *http.Request)
 		return err
 	}
 
-	err = p.blockchain.MineTransctionFromPool(mineData.MinerPublicKey)
+	//客户端断开连接的话，没必要继续挖了
+	err = p.blockchain.MineTransctionFromPoolWithContext(r.Context(), mineData.MinerPublicKey)
 	if err != nil {
 		http.Error(w, "mine data failed", http.StatusBadRequest)
 		return err
@@ -91,6 +142,354 @@ func (p *BlockchainServer) startMineTask(w http.ResponseWriter, r *http.Request)
 	return nil
 }
 
+// Miner 返回后台矿工
+func (p *BlockchainServer) Miner() *blockchain.BackgroundMiner {
+	return p.miner
+}
+
+// minerStartHandler 启动后台矿工，奖励发给请求里的RewardAddress
+func (p *BlockchainServer) minerStartHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var startData struct {
+		RewardAddress string `json:"RewardAddress"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&startData); err != nil || startData.RewardAddress == "" {
+		http.Error(w, "Invalid miner start data", http.StatusBadRequest)
+		return
+	}
+	if err := p.miner.Start(startData.RewardAddress); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(p.miner.Status())
+}
+
+// minerStopHandler 停止后台矿工
+func (p *BlockchainServer) minerStopHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := p.miner.Stop(); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.miner.Status())
+}
+
+// minerStatusHandler 返回后台矿工的状态
+func (p *BlockchainServer) minerStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.miner.Status())
+}
+
+// getBlockTemplateHandler 生成一个区块模板给外部矿工，奖励发给请求里的RewardAddress
+func (p *BlockchainServer) getBlockTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var templateData struct {
+		RewardAddress string `json:"RewardAddress"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&templateData); err != nil || templateData.RewardAddress == "" {
+		http.Error(w, "Invalid block template data", http.StatusBadRequest)
+		return
+	}
+	template, err := p.blockchain.NewBlockTemplate(templateData.RewardAddress)
+	if err != nil {
+		if errors.Is(err, blockchain.ErrExternalMiningUnsupported) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(template)
+}
+
+// submitBlockHandler 外部矿工提交挖到的nonce，校验通过后区块接到链上
+func (p *BlockchainServer) submitBlockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var submitData struct {
+		TemplateID string  `json:"TemplateID"`
+		Nonce      *uint32 `json:"Nonce"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&submitData); err != nil || submitData.TemplateID == "" || submitData.Nonce == nil {
+		http.Error(w, "Invalid submit block data", http.StatusBadRequest)
+		return
+	}
+	hash, err := p.blockchain.SubmitBlock(submitData.TemplateID, *submitData.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, blockchain.ErrUnknownTemplate):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		//模板过期了(或者已经提交过了)，矿工需要重新领取模板
+		case errors.Is(err, blockchain.ErrStaleTip), errors.Is(err, blockchain.ErrKnownBlock):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, blockchain.ErrInvalidProofOfWork), errors.Is(err, blockchain.ErrCheckpointMismatch),
+			errors.As(err, new(*blockchain.ValidationError)):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"hash": hash})
+}
+
+func (p *BlockchainServer) blockHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		p.getBlock(w, r)
+	case http.MethodPost:
+		p.processBlock(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getBlock 返回height参数指定高度的区块，交易已经被裁剪掉的区块pruned为true，transactions为空
+func (p *BlockchainServer) getBlock(w http.ResponseWriter, r *http.Request) {
+	height, err := strconv.Atoi(r.URL.Query().Get("height"))
+	if err != nil || height < 0 {
+		http.Error(w, "Invalid block height", http.StatusBadRequest)
+		return
+	}
+	block, ok := p.blockchain.GetBlock(height)
+	if !ok {
+		http.Error(w, fmt.Sprintf("block %d not found", height), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(block.Info(height))
+}
+
+// processBlock 接收一个完整的区块(比如别的节点挖出来的)，校验通过后接到链上
+func (p *BlockchainServer) processBlock(w http.ResponseWriter, r *http.Request) {
+	var info blockchain.BlockInfo
+	if err := json.NewDecoder(r.Body).Decode(&info); err != nil || info.Hash == "" {
+		http.Error(w, "Invalid block data", http.StatusBadRequest)
+		return
+	}
+	block := blockchain.NewBlockFromInfo(info)
+	if err := p.blockchain.ProcessBlock(block); err != nil {
+		switch {
+		//已经有了或者接不到末端上，不是区块本身的问题
+		case errors.Is(err, blockchain.ErrKnownBlock), errors.Is(err, blockchain.ErrStaleTip):
+			http.Error(w, err.Error(), http.StatusConflict)
+		//只有区块头的区块也算校验失败
+		case errors.As(err, new(*blockchain.ValidationError)):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"hash": block.Hash()})
+}
+
+// maxGenerateBlocks 一次按需出块最多能出的区块数
+const maxGenerateBlocks = 1000
+
+// generateBlocks 立刻挖出指定数量的区块，奖励发给指定地址，只在回归测试网可用
+func (p *BlockchainServer) generateBlocks(w http.ResponseWriter, r *http.Request) error {
+	var generateData struct {
+		Address string `json:"Address"`
+		Blocks  int    `json:"Blocks"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&generateData)
+	if err != nil {
+		http.Error(w, "Invalid generate data", http.StatusBadRequest)
+		return err
+	}
+	if generateData.Blocks == 0 {
+		generateData.Blocks = 1
+	}
+	if generateData.Address == "" || generateData.Blocks < 0 || generateData.Blocks > maxGenerateBlocks {
+		err = errors.New("invalid generate address or block count")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	hashes := []string{}
+	for i := 0; i < generateData.Blocks; i++ {
+		err = p.blockchain.MineTransctionFromPoolWithContext(r.Context(), generateData.Address)
+		if err != nil {
+			http.Error(w, "generate block failed", http.StatusInternalServerError)
+			return err
+		}
+		block, _ := p.blockchain.GetBlock(p.blockchain.Height())
+		hashes = append(hashes, block.Hash())
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string][]string{"hashes": hashes})
+	return nil
+}
+
+func (p *BlockchainServer) generateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p.generateBlocks(w, r)
+}
+
+// signersHandler 返回当前的签名者，以及本节点还没生效的提议
+func (p *BlockchainServer) signersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	poa := p.blockchain.Engine().(*blockchain.ProofOfAuthority)
+	signers, err := poa.Signers(p.blockchain)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"signers":   signers,
+		"proposals": poa.Proposals(),
+	})
+}
+
+// signersProposeHandler 提议加入(Authorize为true)或踢出一个签名者，本节点的签名者出块时会投这一票
+func (p *BlockchainServer) signersProposeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var proposeData struct {
+		Address   string `json:"Address"`
+		Authorize bool   `json:"Authorize"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&proposeData); err != nil {
+		http.Error(w, "Invalid propose data", http.StatusBadRequest)
+		return
+	}
+	poa := p.blockchain.Engine().(*blockchain.ProofOfAuthority)
+	if err := poa.Propose(proposeData.Address, proposeData.Authorize); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(poa.Proposals())
+}
+
+// signersDiscardHandler 撤回对一个地址的提议
+func (p *BlockchainServer) signersDiscardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var discardData struct {
+		Address string `json:"Address"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&discardData); err != nil || discardData.Address == "" {
+		http.Error(w, "Invalid discard data", http.StatusBadRequest)
+		return
+	}
+	poa := p.blockchain.Engine().(*blockchain.ProofOfAuthority)
+	poa.Discard(discardData.Address)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(poa.Proposals())
+}
+
+// validatorsHandler 返回每个验证者锁定的权益
+func (p *BlockchainServer) validatorsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pos := p.blockchain.Engine().(*blockchain.ProofOfStake)
+	validators, err := pos.Validators(p.blockchain)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"validators": validators})
+}
+
+// snapshotHandler 导出height参数指定高度的账本快照和区块头，不指定的话导出最近一个有状态承诺的高度
+// 那时的账本状态因为交易被裁剪掉而算不出来的话返回410
+func (p *BlockchainServer) snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	height := 0
+	if value := r.URL.Query().Get("height"); value != "" {
+		var err error
+		if height, err = strconv.Atoi(value); err != nil || height <= 0 {
+			http.Error(w, "Invalid snapshot height", http.StatusBadRequest)
+			return
+		}
+	}
+	snapshot, err := p.blockchain.ExportSnapshot(height)
+	if err != nil {
+		switch {
+		case errors.Is(err, blockchain.ErrNoStateCommitment):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, blockchain.ErrPruned):
+			http.Error(w, err.Error(), http.StatusGone)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// validateHandler 校验整条链，返回第一个问题所在的区块高度和交易id
+func (p *BlockchainServer) validateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	result := struct {
+		Valid      bool   `json:"valid"`
+		Error      string `json:"error,omitempty"`
+		BlockIndex *int   `json:"blockIndex,omitempty"`
+		TxID       string `json:"txId,omitempty"`
+		//这个高度及之前的区块交易已经被裁剪掉了，只校验了区块头
+		PrunedHeight int `json:"prunedHeight,omitempty"`
+	}{Valid: true, PrunedHeight: p.blockchain.PrunedHeight()}
+	if err := p.blockchain.ValidateChain(); err != nil {
+		result.Valid = false
+		result.Error = err.Error()
+		var validationErr *blockchain.ValidationError
+		if errors.As(err, &validationErr) {
+			result.BlockIndex = &validationErr.BlockIndex
+			result.TxID = validationErr.TxID
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
 func (p *BlockchainServer) transactionHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
@@ -118,8 +517,9 @@ func (p *BlockchainServer) mineHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		w.WriteHeader(http.StatusCreated)
 	case http.MethodGet:
-		// 处理获取交易的逻辑
-		// 例如：返回所有交易或特定交易的信息
+		// 返回矿工的算力、尝试次数、进度等统计数据
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.blockchain.MiningStats())
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
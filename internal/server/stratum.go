@@ -0,0 +1,625 @@
+package server
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 矿池协议的默认参数
+const (
+	stratumMaxJobs          = 16               //最多保留多少个任务，更早的任务提交上来会被当作过期的
+	stratumMaxLineSize      = 64 * 1024        //一行请求最长多少字节
+	stratumWriteTimeout     = 10 * time.Second //给矿工写一条消息最多等多久，超时就断开，免得慢矿工拖住所有人
+	stratumRetryDelay       = time.Second      //生成任务出错后等多久再重试
+	stratumSubscriptionLen  = 8                //订阅id的字节数
+	stratumMaxWorkerNameLen = 64               //矿工名最长多少字节
+	stratumMaxConnWorkers   = 16               //一个连接上最多登记多少个矿工名
+	stratumMaxJobShares     = 1024             //一个连接在一个任务下最多记多少个份额，份额难度设得合适的话等不到用完就有新任务了
+)
+
+// 矿池协议的错误码，和Stratum协议保持一致
+const (
+	StratumErrOther          = 20
+	StratumErrJobNotFound    = 21 //任务过期了，链的末端已经变了
+	StratumErrDuplicateShare = 22
+	StratumErrLowDifficulty  = 23
+	StratumErrUnauthorized   = 24
+	StratumErrNotSubscribed  = 25
+)
+
+var ErrStratumServerClosed = errors.New("stratum server closed")
+
+// StratumError 矿池协议返回给矿工的错误
+type StratumError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *StratumError) Error() string {
+	return fmt.Sprintf("stratum error %d: %s", e.Code, e.Message)
+}
+
+// StratumJob 下发给矿工的任务
+// 工作量hash和区块模板一样，是在区块头Header的最后4个字节填上大端的nonce之后用Algorithm算hash，
+// 以Target开头就是一个有效的份额(share)，以BlockTarget开头就是挖到了区块
+// 同一个任务发给每个连接的Header都不一样，里面的出块奖励交易带着这个连接订阅时分到的extra nonce
+type StratumJob struct {
+	JobID       string `json:"jobId"`
+	Height      int    `json:"height"`
+	PrevHash    string `json:"prevHash"`
+	Timestamp   uint64 `json:"timestamp"`
+	Algorithm   string `json:"algorithm"`
+	Header      string `json:"header"` //十六进制，nonce为0
+	Target      string `json:"target"` //份额的难度要求，不高于区块的难度
+	BlockTarget string `json:"blockTarget"`
+	CleanJobs   bool   `json:"cleanJobs"` //链的末端变了，之前的任务都作废了
+}
+
+// StratumWorkerStats 每个矿工提交份额的统计
+type StratumWorkerStats struct {
+	AcceptedShares uint64 `json:"acceptedShares"`
+	RejectedShares uint64 `json:"rejectedShares"`
+	Blocks         uint64 `json:"blocks"` //挖到的区块数
+}
+
+// stratumRequest 矿工发来的请求，一行一个json
+type stratumRequest struct {
+	ID     interface{}     `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type stratumResponse struct {
+	ID     interface{}   `json:"id"`
+	Result interface{}   `json:"result"`
+	Error  *StratumError `json:"error"`
+}
+
+// stratumNotification 服务端主动推给矿工的消息，id固定是null
+type stratumNotification struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+type stratumJob struct {
+	StratumJob
+	seq         uint64
+	shares      map[stratumShare]bool //已经接受的份额，用来拒绝重复的份额
+	shareCounts map[uint64]int        //extra nonce -> 这个连接在这个任务下已经接受的份额数
+}
+
+// stratumShare 同一个任务下，不同连接的区块头不一样，同一个nonce算出来的是不同的份额
+type stratumShare struct {
+	extraNonce uint64
+	nonce      uint32
+}
+
+// StratumServer 矿池协议服务：基于TCP，一行一个json，消息格式参考Stratum
+// 矿工先mining.subscribe订阅任务，再mining.authorize登记矿工名，之后用mining.submit提交份额；
+// 链的末端或者交易池变了，服务端用mining.notify推送新任务。区块由和MineTransctionFromPool相同的逻辑组装，奖励发给矿池的地址
+// 每个连接订阅时分到一个不同的extra nonce，写进发给它的任务的出块奖励交易里，这样各个连接挖的区块头互不重复
+type StratumServer struct {
+	chain           *blockchain.Blockchain
+	rewardAddress   string
+	shareDifficulty int
+	refreshInterval time.Duration
+	templates       *blockchain.TemplateStore //任务对应的区块模板，和getblocktemplate接口的模板分开存，免得被挤掉
+
+	mu       sync.Mutex
+	listener net.Listener
+	closed   bool
+	done     chan struct{}
+	wg       sync.WaitGroup
+	conns    map[*stratumConn]struct{}
+	jobs     map[string]*stratumJob
+	jobOrder []string //任务id，按生成的顺序，用来限制数量
+	current  *stratumJob
+	nextSeq  uint64
+	workers  map[string]*stratumWorker
+
+	nextExtraNonce uint64 //最近分给订阅的连接的extra nonce，从1开始分，0留给直接用区块模板挖矿的矿工
+}
+
+func NewStratumServer(chain *blockchain.Blockchain, rewardAddress string) (*StratumServer, error) {
+	if rewardAddress == blockchain.MinerRewardFromAddress {
+		return nil, errors.New("miner reward address is required")
+	}
+	if _, ok := chain.Engine().(*blockchain.ProofOfWork); !ok {
+		return nil, blockchain.ErrExternalMiningUnsupported
+	}
+	return &StratumServer{
+		chain:           chain,
+		rewardAddress:   rewardAddress,
+		refreshInterval: blockchain.DefaultTemplateRefreshInterval,
+		templates:       blockchain.NewTemplateStore(stratumMaxJobs),
+		done:            make(chan struct{}),
+		conns:           map[*stratumConn]struct{}{},
+		jobs:            map[string]*stratumJob{},
+		workers:         map[string]*stratumWorker{},
+	}, nil
+}
+
+// SetShareDifficulty 设置份额的难度(前导0的个数)，不大于0或者超过区块难度的话就用区块难度，也就是只接受能出块的份额
+func (s *StratumServer) SetShareDifficulty(difficulty int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shareDifficulty = difficulty
+}
+
+// SetTemplateRefreshInterval 设置有新交易时重新下发任务的最小间隔
+func (s *StratumServer) SetTemplateRefreshInterval(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshInterval = interval
+}
+
+// Workers 返回每个矿工提交份额的统计，按矿工名汇总，不同连接登记了同一个名字的话统计会合在一起
+// 只统计还连着的矿工，登记了某个名字的连接都断开之后，这个名字的统计也就清掉了
+func (s *StratumServer) Workers() map[string]StratumWorkerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	workers := make(map[string]StratumWorkerStats, len(s.workers))
+	for name, worker := range s.workers {
+		workers[name] = worker.StratumWorkerStats
+	}
+	return workers
+}
+
+// ListenAndServe 监听addr并开始服务，直到Close才返回
+func (s *StratumServer) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve 在listener上接受矿工的连接，直到Close才返回
+func (s *StratumServer) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrStratumServerClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	tipChanged := s.chain.TipChangedNotify()
+	txAdded := s.chain.Mempool().TxAddedNotify()
+	if err := s.newJob(true); err != nil {
+		listener.Close()
+		return err
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrStratumServerClosed
+	}
+	s.wg.Add(1)
+	s.mu.Unlock()
+	go s.watch(tipChanged, txAdded)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return ErrStratumServerClosed
+			default:
+				return err
+			}
+		}
+		c := &stratumConn{conn: conn}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrStratumServerClosed
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(c)
+	}
+}
+
+// Close 停止服务，断开所有矿工，等处理连接的goroutine都退出之后才返回
+func (s *StratumServer) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrStratumServerClosed
+	}
+	s.closed = true
+	close(s.done)
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for c := range s.conns {
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// watch 链的末端变了立刻下发新任务；有新交易进池时，等refreshInterval之后再下发，好把新交易也打包进去
+// tipChanged和txAdded要在生成上一个任务之前取，这样生成任务期间的变化也不会漏掉
+func (s *StratumServer) watch(tipChanged, txAdded <-chan struct{}) {
+	defer s.wg.Done()
+	for {
+		clean := false
+		select {
+		case <-s.done:
+			return
+		case <-tipChanged:
+			clean = true
+		case <-txAdded:
+			s.mu.Lock()
+			refreshInterval := s.refreshInterval
+			s.mu.Unlock()
+			select {
+			case <-s.done:
+				return
+			case <-tipChanged:
+				clean = true
+			case <-time.After(refreshInterval):
+			}
+		}
+
+		tipChanged = s.chain.TipChangedNotify()
+		txAdded = s.chain.Mempool().TxAddedNotify()
+		for {
+			err := s.newJob(clean)
+			if err == nil {
+				break
+			}
+			select {
+			case <-s.done:
+				return
+			case <-time.After(stratumRetryDelay):
+			}
+		}
+	}
+}
+
+// newJob 用当前链的末端和交易池生成一个新任务，推送给所有订阅了的矿工
+func (s *StratumServer) newJob(clean bool) error {
+	template, err := s.chain.NewBlockTemplateIn(s.templates, s.rewardAddress)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	shareDifficulty := s.shareDifficulty
+	if shareDifficulty <= 0 || shareDifficulty > template.Difficulty {
+		shareDifficulty = template.Difficulty
+	}
+	s.nextSeq++
+	job := &stratumJob{
+		StratumJob: StratumJob{
+			JobID:       template.TemplateID,
+			Height:      template.Height,
+			PrevHash:    template.PrevHash,
+			Timestamp:   template.Timestamp,
+			Algorithm:   template.Algorithm,
+			Header:      template.Header,
+			Target:      strings.Repeat("0", shareDifficulty),
+			BlockTarget: template.Target,
+			CleanJobs:   clean,
+		},
+		seq:         s.nextSeq,
+		shares:      map[stratumShare]bool{},
+		shareCounts: map[uint64]int{},
+	}
+	if clean {
+		//之前的任务都是基于旧的末端，提交上来也接不到链上了
+		s.jobs = map[string]*stratumJob{}
+		s.jobOrder = nil
+	}
+	s.jobs[job.JobID] = job
+	s.jobOrder = append(s.jobOrder, job.JobID)
+	if len(s.jobOrder) > stratumMaxJobs {
+		delete(s.jobs, s.jobOrder[0])
+		s.jobOrder = s.jobOrder[1:]
+	}
+	s.current = job
+	//每个连接单独推送，写得慢的矿工只耽误它自己
+	if !s.closed {
+		for c := range s.conns {
+			s.wg.Add(1)
+			go func(c *stratumConn) {
+				defer s.wg.Done()
+				s.notify(c, job)
+			}(c)
+		}
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *StratumServer) serveConn(c *stratumConn) {
+	defer s.wg.Done()
+	defer func() {
+		c.mu.Lock()
+		names := c.workers
+		c.mu.Unlock()
+		s.mu.Lock()
+		delete(s.conns, c)
+		for name := range names {
+			if worker := s.workers[name]; worker != nil {
+				if worker.conns--; worker.conns <= 0 {
+					delete(s.workers, name)
+				}
+			}
+		}
+		s.mu.Unlock()
+		c.conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 0, 4096), stratumMaxLineSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var req stratumRequest
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			if c.send(stratumResponse{Error: &StratumError{Code: StratumErrOther, Message: "invalid request"}}) != nil {
+				return
+			}
+			continue
+		}
+		result, stratumErr := s.handle(c, req)
+		if c.send(stratumResponse{ID: req.ID, Result: result, Error: stratumErr}) != nil {
+			return
+		}
+		if req.Method == "mining.subscribe" && stratumErr == nil {
+			//订阅成功之后马上把当前的任务发过去
+			s.mu.Lock()
+			job := s.current
+			s.mu.Unlock()
+			s.notify(c, job)
+		}
+	}
+}
+
+func (s *StratumServer) handle(c *stratumConn, req stratumRequest) (interface{}, *StratumError) {
+	switch req.Method {
+	case "mining.subscribe":
+		id := make([]byte, stratumSubscriptionLen)
+		if _, err := rand.Read(id); err != nil {
+			return nil, &StratumError{Code: StratumErrOther, Message: err.Error()}
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.subscription = hex.EncodeToString(id)
+		if c.extraNonce == 0 {
+			//重复订阅的话沿用原来的extra nonce，已经推送过的任务还能接着挖
+			s.mu.Lock()
+			s.nextExtraNonce++
+			c.extraNonce = s.nextExtraNonce
+			s.mu.Unlock()
+		}
+		return map[string]interface{}{"subscriptionId": c.subscription, "extraNonce": c.extraNonce}, nil
+	case "mining.authorize":
+		//矿工名只是用来分开统计份额的标签，不做认证，也不检查密码，奖励都发给矿池的地址
+		var params []string
+		if err := json.Unmarshal(req.Params, &params); err != nil || len(params) == 0 || params[0] == "" {
+			return nil, &StratumError{Code: StratumErrOther, Message: "worker name is required"}
+		}
+		if !validWorkerName(params[0]) {
+			return nil, &StratumError{Code: StratumErrOther, Message: fmt.Sprintf("worker name must be at most %d printable characters without spaces", stratumMaxWorkerNameLen)}
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.workers[params[0]] {
+			return true, nil
+		}
+		if len(c.workers) >= stratumMaxConnWorkers {
+			return nil, &StratumError{Code: StratumErrOther, Message: fmt.Sprintf("at most %d workers per connection", stratumMaxConnWorkers)}
+		}
+		if c.workers == nil {
+			c.workers = map[string]bool{}
+		}
+		c.workers[params[0]] = true
+		s.mu.Lock()
+		s.worker(params[0]).conns++
+		s.mu.Unlock()
+		return true, nil
+	case "mining.submit":
+		return s.submit(c, req.Params)
+	default:
+		return nil, &StratumError{Code: StratumErrOther, Message: "unknown method " + req.Method}
+	}
+}
+
+// submit 处理矿工提交的份额，参数是[矿工名, 任务id, nonce]，满足区块难度的份额会被当作区块接到链上
+func (s *StratumServer) submit(c *stratumConn, raw json.RawMessage) (interface{}, *StratumError) {
+	var params []json.RawMessage
+	if err := json.Unmarshal(raw, &params); err != nil || len(params) < 3 {
+		return nil, &StratumError{Code: StratumErrOther, Message: "params must be [worker, jobId, nonce]"}
+	}
+	var worker, jobID string
+	var nonce uint32
+	if json.Unmarshal(params[0], &worker) != nil || json.Unmarshal(params[1], &jobID) != nil || json.Unmarshal(params[2], &nonce) != nil {
+		return nil, &StratumError{Code: StratumErrOther, Message: "params must be [worker, jobId, nonce]"}
+	}
+
+	c.mu.Lock()
+	subscribed, authorized, extraNonce := c.subscription != "", c.workers[worker], c.extraNonce
+	c.mu.Unlock()
+	if !subscribed {
+		return nil, &StratumError{Code: StratumErrNotSubscribed, Message: "not subscribed"}
+	}
+	if !authorized {
+		return nil, &StratumError{Code: StratumErrUnauthorized, Message: "unauthorized worker " + worker}
+	}
+
+	s.mu.Lock()
+	job, ok := s.jobs[jobID]
+	share := stratumShare{extraNonce: extraNonce, nonce: nonce}
+	duplicate := ok && job.shares[share]
+	full := ok && job.shareCounts[extraNonce] >= stratumMaxJobShares
+	s.mu.Unlock()
+	if !ok {
+		return s.reject(worker, StratumErrJobNotFound, "job not found")
+	}
+	if duplicate {
+		return s.reject(worker, StratumErrDuplicateShare, "duplicate share")
+	}
+	if full {
+		return s.reject(worker, StratumErrOther, "too many shares for this job")
+	}
+
+	//份额要按发给这个连接的区块头来算
+	headerHex, err := s.chain.TemplateHeader(s.templates, jobID, extraNonce)
+	if err != nil {
+		return s.reject(worker, StratumErrJobNotFound, "job not found")
+	}
+	header, err := hex.DecodeString(headerHex)
+	if err != nil {
+		return s.reject(worker, StratumErrOther, err.Error())
+	}
+	binary.BigEndian.PutUint32(header[blockchain.HeaderNonceOffset:], nonce)
+	hash, err := blockchain.PowHash(job.Algorithm, header)
+	if err != nil {
+		return s.reject(worker, StratumErrOther, err.Error())
+	}
+	if !strings.HasPrefix(hash, job.Target) {
+		return s.reject(worker, StratumErrLowDifficulty, "low difficulty share")
+	}
+	//只记下满足难度的份额，不做工作量的请求占不了内存
+	s.mu.Lock()
+	duplicate = job.shares[share]
+	if !duplicate {
+		job.shares[share] = true
+		job.shareCounts[extraNonce]++
+	}
+	s.mu.Unlock()
+	if duplicate {
+		return s.reject(worker, StratumErrDuplicateShare, "duplicate share")
+	}
+
+	block := false
+	if strings.HasPrefix(hash, job.BlockTarget) {
+		if _, err := s.chain.SubmitBlockWithExtraNonce(s.templates, jobID, extraNonce, nonce); err != nil {
+			if errors.Is(err, blockchain.ErrStaleTip) || errors.Is(err, blockchain.ErrKnownBlock) || errors.Is(err, blockchain.ErrUnknownTemplate) {
+				return s.reject(worker, StratumErrJobNotFound, "job not found")
+			}
+			return s.reject(worker, StratumErrOther, err.Error())
+		}
+		block = true
+	}
+
+	s.mu.Lock()
+	stats := &s.worker(worker).StratumWorkerStats
+	stats.AcceptedShares++
+	if block {
+		stats.Blocks++
+	}
+	s.mu.Unlock()
+	return true, nil
+}
+
+func (s *StratumServer) reject(worker string, code int, message string) (interface{}, *StratumError) {
+	s.mu.Lock()
+	s.worker(worker).RejectedShares++
+	s.mu.Unlock()
+	return nil, &StratumError{Code: code, Message: message}
+}
+
+// validWorkerName 矿工名不能太长，只能是可打印、不含空白的字符，免得把统计和日志弄乱
+func validWorkerName(name string) bool {
+	if len(name) > stratumMaxWorkerNameLen || !utf8.ValidString(name) {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) || unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// stratumWorker 一个矿工名的统计，conns是登记了这个名字、还连着的连接数，减到0就不再统计
+type stratumWorker struct {
+	StratumWorkerStats
+	conns int
+}
+
+// worker 调用方需要持有s.mu
+func (s *StratumServer) worker(name string) *stratumWorker {
+	worker, ok := s.workers[name]
+	if !ok {
+		worker = &stratumWorker{}
+		s.workers[name] = worker
+	}
+	return worker
+}
+
+// stratumConn 一个矿工的连接，写消息需要持有mu，保证一行一行地写出去
+type stratumConn struct {
+	conn net.Conn
+
+	mu           sync.Mutex
+	subscription string
+	extraNonce   uint64          //订阅时分到的extra nonce，推送给这个连接的任务都用它
+	workers      map[string]bool //这个连接上登记过的矿工名
+	lastJob      uint64          //最近推送过的任务序号，避免乱序推送旧任务
+}
+
+func (c *stratumConn) send(message interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.write(message)
+}
+
+// write 调用方需要持有c.mu
+func (c *stratumConn) write(message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(stratumWriteTimeout))
+	if _, err := c.conn.Write(append(data, '\n')); err != nil {
+		c.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// notify 把任务推送给已经订阅了的矿工，区块头换成带这个连接的extra nonce的，比已经推送过的任务旧的就不推了
+func (s *StratumServer) notify(c *stratumConn, job *stratumJob) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subscription == "" || job == nil || job.seq <= c.lastJob {
+		return
+	}
+	header, err := s.chain.TemplateHeader(s.templates, job.JobID, c.extraNonce)
+	if err != nil {
+		//模板已经被更新的模板挤掉了，等下一个任务
+		return
+	}
+	c.lastJob = job.seq
+	notification := job.StratumJob
+	notification.Header = header
+	c.write(stratumNotification{Method: "mining.notify", Params: []interface{}{notification}})
+}
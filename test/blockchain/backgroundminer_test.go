@@ -0,0 +1,81 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"errors"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestBackgroundMiner_StartStop(t *testing.T) {
+	myChain, _ := blockchain.NewBlockchainWithParams(fundedParams(blockchain.RegTestParams))
+	miner := blockchain.NewBackgroundMiner(myChain)
+	miner.SetTemplateRefreshInterval(10 * time.Millisecond)
+
+	if err := miner.Start("minerPublicKey"); err != nil {
+		t.Fatalf("Start failed err: %v", err)
+	}
+	if err := miner.Start("minerPublicKey"); !errors.Is(err, blockchain.ErrMinerAlreadyRunning) {
+		t.Errorf("second Start got err %v want %v", err, blockchain.ErrMinerAlreadyRunning)
+	}
+	waitFor(t, "blocks to be mined", func() bool { return myChain.Height() >= 3 })
+
+	//后台矿工会把新进池的交易打包进后面的区块
+	tx := newSignedTx(t, 1, 0.01)
+	if err := myChain.AddTransction2Pool(tx); err != nil {
+		t.Fatalf("Failed to add transaction to pool: %v", err)
+	}
+	waitFor(t, "transaction to be mined", func() bool { return !myChain.Mempool().Has(tx.ID()) })
+
+	if err := miner.Stop(); err != nil {
+		t.Fatalf("Stop failed err: %v", err)
+	}
+	status := miner.Status()
+	if status.Running || status.BlocksMined == 0 || status.RewardAddress != "minerPublicKey" {
+		t.Errorf("unexpected status after stop %+v", status)
+	}
+	height := myChain.Height()
+	time.Sleep(50 * time.Millisecond)
+	if myChain.Height() != height {
+		t.Errorf("stopped miner should not produce blocks")
+	}
+	if err := miner.Stop(); !errors.Is(err, blockchain.ErrMinerNotRunning) {
+		t.Errorf("second Stop got err %v want %v", err, blockchain.ErrMinerNotRunning)
+	}
+	if !myChain.IsValidChain() {
+		t.Errorf("chain should be valid")
+	}
+}
+
+func TestBackgroundMiner_RestartOnNewTransactions(t *testing.T) {
+	//难度高到挖不出来，只能看到因为新交易而重新生成区块
+	myChain := newFundedChain(t, 32)
+	myChain.SetMiningWorkers(1)
+	miner := blockchain.NewBackgroundMiner(myChain)
+	miner.SetTemplateRefreshInterval(10 * time.Millisecond)
+	if err := miner.Start("minerPublicKey"); err != nil {
+		t.Fatalf("Start failed err: %v", err)
+	}
+	defer miner.Stop()
+	waitFor(t, "miner to start mining", func() bool { return miner.Status().Stats.Mining })
+
+	if err := myChain.AddTransction2Pool(newSignedTx(t, 1, 0.01)); err != nil {
+		t.Fatalf("Failed to add transaction to pool: %v", err)
+	}
+	waitFor(t, "miner to restart with new transactions", func() bool { return miner.Status().Restarts >= 1 })
+	if !miner.Status().Stats.Mining {
+		t.Errorf("miner should keep mining after restart")
+	}
+}
@@ -10,10 +10,10 @@ import (
 func TestBlockChain(t *testing.T) {
 	difficulty := 3
 
-	myChain := blockchain.NewBlockchain(difficulty)
+	myChain := newFundedChain(t, difficulty)
 
 	// 生成两个交易者身份的密钥对，也就是对应了钱包地址
-	senderPrivateKey, senderPublicKey := encryption.GenerateKeyPair()
+	senderPrivateKey, senderPublicKey := fundedKeyPair()
 	_, receiverPublicKey := encryption.GenerateKeyPair()
 
 	//公钥作为钱包的地址，标记转账时哪个钱包地址->另外一个钱包地址
@@ -28,7 +28,8 @@ func TestBlockChain(t *testing.T) {
 		t.Errorf("Failed to add transaction to pool: %v", err)
 	}
 
-	t2, err := blockchain.NewTransaction(senderPublicKey, senderPrivateKey, receiverPublicKey, 99)
+	//同一个发送者的第二笔交易要用下一个nonce，否则就是在花同一份钱
+	t2, err := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, 99, 0, 1)
 	if err != nil {
 		t.Errorf("NewTransaction failed err: %v", err)
 	}
@@ -0,0 +1,254 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"CcCoin-go-version/internal/encryption"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// relayBlock 像从网络上收到区块一样，把区块编码成JSON再还原
+func relayBlock(t *testing.T, chain *blockchain.Blockchain, height int) blockchain.BlockInfo {
+	t.Helper()
+	block, ok := chain.GetBlock(height)
+	if !ok {
+		t.Fatalf("GetBlock(%d) not found", height)
+	}
+	data, err := json.Marshal(block.Info(height))
+	if err != nil {
+		t.Fatalf("Marshal failed err: %v", err)
+	}
+	var info blockchain.BlockInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		t.Fatalf("Unmarshal failed err: %v", err)
+	}
+	return info
+}
+
+// craftBlock 像不守规矩的矿工那样，不经过交易池和打包规则，把txs和一笔出块奖励直接拼成接在chain末端的区块，再找到满足难度的nonce
+// 区块头照着blockchain包的格式拼，只适用于sha256工作量证明、不需要承诺账本状态的高度
+func craftBlock(t *testing.T, chain *blockchain.Blockchain, minerPublicKey string, txs ...blockchain.Transaction) blockchain.Block {
+	t.Helper()
+	return craftBlockWithReward(t, chain, minerPublicKey, chain.Params().Genesis.MinerReward, txs...)
+}
+
+// craftBlockWithReward 和craftBlock一样，只是出块奖励交易的金额由调用方指定
+func craftBlockWithReward(t *testing.T, chain *blockchain.Blockchain, minerPublicKey string, reward float64, txs ...blockchain.Transaction) blockchain.Block {
+	t.Helper()
+	tip, _ := chain.GetBlock(chain.Height())
+	info := blockchain.BlockInfo{PrevHash: tip.Hash(), Timestamp: uint64(time.Now().Unix())}
+	for _, tx := range txs {
+		info.Transactions = append(info.Transactions, tx.Info())
+	}
+	info.Transactions = append(info.Transactions, blockchain.TransactionInfo{
+		From:   blockchain.MinerRewardFromAddress,
+		To:     minerPublicKey,
+		Amount: reward,
+	})
+
+	block := blockchain.NewBlockFromInfo(info)
+	content := sha256.New()
+	binary.Write(content, binary.BigEndian, uint32(len(info.PrevHash)))
+	content.Write([]byte(info.PrevHash))
+	binary.Write(content, binary.BigEndian, uint32(len(block.Transactions())))
+	for _, tx := range block.Transactions() {
+		id, _ := hex.DecodeString(tx.ID())
+		content.Write(id)
+	}
+	//message、extra、signer、投票和举报证据都是空的，各写一个0长度前缀
+	content.Write(make([]byte, 4*5))
+	var header [blockchain.BlockHeaderSize]byte
+	prevHash, _ := hex.DecodeString(info.PrevHash)
+	copy(header[:32], prevHash)
+	copy(header[32:64], content.Sum(nil))
+	binary.BigEndian.PutUint64(header[64:72], info.Timestamp)
+	zeros := strings.Repeat("0", chain.Params().Genesis.Difficulty)
+	for nonce := uint32(0); ; nonce++ {
+		binary.BigEndian.PutUint32(header[blockchain.HeaderNonceOffset:], nonce)
+		hash := sha256.Sum256(header[:])
+		if info.Hash = hex.EncodeToString(hash[:]); strings.HasPrefix(info.Hash, zeros) {
+			info.Nonce = nonce
+			return blockchain.NewBlockFromInfo(info)
+		}
+	}
+}
+
+func expectValidationError(t *testing.T, err error, want error, blockIndex int) {
+	t.Helper()
+	var validationErr *blockchain.ValidationError
+	if !errors.Is(err, want) || !errors.As(err, &validationErr) {
+		t.Fatalf("got err %v want %v", err, want)
+	}
+	if validationErr.BlockIndex != blockIndex {
+		t.Errorf("got block index %d want %d", validationErr.BlockIndex, blockIndex)
+	}
+}
+
+func TestProcessBlock_Sync(t *testing.T) {
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	source := newFundedChain(t, 1)
+	target := newFundedChain(t, 1)
+
+	tx := newSignedTx(t, 10, 0.01)
+	for _, chain := range []*blockchain.Blockchain{source, target} {
+		if err := chain.AddTransction2Pool(tx); err != nil {
+			t.Fatalf("Failed to add transaction to pool: %v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := source.MineTransctionFromPool(minerPublicKey); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+	}
+
+	//父区块还没收到的区块接不上
+	err := target.ProcessBlock(blockchain.NewBlockFromInfo(relayBlock(t, source, 2)))
+	expectValidationError(t, err, blockchain.ErrBrokenLink, 1)
+
+	for height := 1; height <= 3; height++ {
+		if err := target.ProcessBlock(blockchain.NewBlockFromInfo(relayBlock(t, source, height))); err != nil {
+			t.Fatalf("ProcessBlock(%d) failed err: %v", height, err)
+		}
+	}
+	sourceTip, _ := source.GetBlock(3)
+	targetTip, _ := target.GetBlock(3)
+	if target.Height() != 3 || targetTip.Hash() != sourceTip.Hash() {
+		t.Fatalf("target should follow source, height %d", target.Height())
+	}
+	if target.Mempool().Has(tx.ID()) {
+		t.Errorf("imported transaction should leave the pool")
+	}
+	if err := target.ValidateChain(); err != nil {
+		t.Errorf("ValidateChain got err %v want nil", err)
+	}
+	if target.GetBalance(minerPublicKey) != source.GetBalance(minerPublicKey) {
+		t.Errorf("miner balance got %v want %v", target.GetBalance(minerPublicKey), source.GetBalance(minerPublicKey))
+	}
+
+	for _, height := range []int{2, 3} {
+		if err := target.ProcessBlock(blockchain.NewBlockFromInfo(relayBlock(t, source, height))); !errors.Is(err, blockchain.ErrKnownBlock) {
+			t.Errorf("ProcessBlock(%d) got err %v want %v", height, err, blockchain.ErrKnownBlock)
+		}
+	}
+}
+
+func TestProcessBlock_RejectTampered(t *testing.T) {
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	source := newFundedChain(t, 1)
+	target := newFundedChain(t, 1)
+	if err := source.AddTransction2Pool(newSignedTx(t, 10, 0.01)); err != nil {
+		t.Fatalf("Failed to add transaction to pool: %v", err)
+	}
+	if err := source.MineTransctionFromPool(minerPublicKey); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+
+	info := relayBlock(t, source, 1)
+	//矿工想多拿一点奖励
+	info.Transactions[len(info.Transactions)-1].Amount += 100
+	err := target.ProcessBlock(blockchain.NewBlockFromInfo(info))
+	expectValidationError(t, err, blockchain.ErrTamperedBlock, 1)
+	if target.Height() != 0 {
+		t.Errorf("tampered block should not be connected, height %d", target.Height())
+	}
+}
+
+func TestProcessBlock_RejectReencodedTransactions(t *testing.T) {
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	chain := newFundedChain(t, 1)
+	tx := newSignedTx(t, 10, 0)
+	block := craftBlock(t, chain, minerPublicKey, tx)
+
+	//把转账和出块奖励改写成一笔出块奖励，收款地址里塞着转账的原文，按%v打印出来和原来的两笔交易一模一样
+	rendering := fmt.Sprintf("%v", block.Transactions())
+	reward := chain.Params().Genesis.MinerReward
+	suffix := fmt.Sprintf("  %v 0 0  0}]", reward)
+	if !strings.HasPrefix(rendering, "[{") || !strings.HasSuffix(rendering, suffix) {
+		t.Fatalf("unexpected transactions rendering %q", rendering)
+	}
+	info := block.Info(1)
+	info.Transactions = []blockchain.TransactionInfo{{
+		From:   blockchain.MinerRewardFromAddress,
+		To:     strings.TrimSuffix(strings.TrimPrefix(rendering, "[{"), suffix),
+		Amount: reward,
+	}}
+	forged := blockchain.NewBlockFromInfo(info)
+	if got := fmt.Sprintf("%v", forged.Transactions()); got != rendering {
+		t.Fatalf("forged rendering got %q want %q", got, rendering)
+	}
+
+	err := chain.ProcessBlock(forged)
+	expectValidationError(t, err, blockchain.ErrTamperedBlock, 1)
+	if err := chain.ProcessBlock(block); err != nil {
+		t.Fatalf("ProcessBlock failed err: %v", err)
+	}
+	if balance := chain.GetBalance(tx.Info().To); balance != 10 {
+		t.Errorf("receiver balance got %v want 10", balance)
+	}
+}
+
+func TestProcessBlock_ExternalMiner(t *testing.T) {
+	chain := newPowChain(t, blockchain.PowAlgorithmSHA256, 2)
+	tx := newSignedTx(t, 10, 0.5)
+	if err := chain.AddTransction2Pool(tx); err != nil {
+		t.Fatalf("AddTransction2Pool failed err: %v", err)
+	}
+	template, err := chain.NewBlockTemplate("miner")
+	if err != nil {
+		t.Fatalf("NewBlockTemplate failed err: %v", err)
+	}
+
+	//外部矿工只根据模板拼出完整的区块，不走SubmitBlock
+	blockFromTemplate := func(nonce uint32) blockchain.Block {
+		header, _ := hex.DecodeString(template.Header)
+		binary.BigEndian.PutUint32(header[blockchain.HeaderNonceOffset:], nonce)
+		hash := sha256.Sum256(header)
+		return blockchain.NewBlockFromInfo(blockchain.BlockInfo{
+			Hash:         hex.EncodeToString(hash[:]),
+			PrevHash:     template.PrevHash,
+			Timestamp:    template.Timestamp,
+			Nonce:        nonce,
+			Transactions: template.Transactions,
+		})
+	}
+
+	err = chain.ProcessBlock(blockFromTemplate(solveTemplate(t, template, false)))
+	expectValidationError(t, err, blockchain.ErrInvalidProofOfWork, 1)
+
+	block := blockFromTemplate(solveTemplate(t, template, true))
+	if err := chain.ProcessBlock(block); err != nil {
+		t.Fatalf("ProcessBlock failed err: %v", err)
+	}
+	if tip, _ := chain.GetBlock(1); chain.Height() != 1 || tip.Hash() != block.Hash() {
+		t.Errorf("block should be connected, height %d", chain.Height())
+	}
+	if chain.Mempool().Has(tx.ID()) {
+		t.Errorf("mined transaction should leave the pool")
+	}
+}
+
+func TestProcessBlock_RejectNegativeMinerReward(t *testing.T) {
+	_, victim := fundedKeyPair()
+	chain := newPowChain(t, blockchain.PowAlgorithmSHA256, 1)
+	before := chain.GetBalance(victim)
+
+	//负数的出块奖励会从收款地址扣钱，不能拿来烧掉别人的余额
+	for _, reward := range []float64{-900, math.NaN(), math.Inf(-1)} {
+		err := chain.ProcessBlock(craftBlockWithReward(t, chain, victim, reward))
+		expectValidationError(t, err, blockchain.ErrInvalidAmount, 1)
+	}
+	if chain.Height() != 0 || chain.GetBalance(victim) != before {
+		t.Errorf("block with a negative miner reward should not be connected, height %d balance %v want %v", chain.Height(), chain.GetBalance(victim), before)
+	}
+	if err := chain.ProcessBlock(craftBlockWithReward(t, chain, victim, 0)); err != nil {
+		t.Errorf("ProcessBlock with a zero miner reward failed err: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// solveTemplate 像外部矿工一样只根据模板里的信息找nonce，want为false时找一个不满足难度的nonce
+func solveTemplate(t *testing.T, template blockchain.BlockTemplate, want bool) uint32 {
+	t.Helper()
+	header, _ := hex.DecodeString(template.Header)
+	if len(header) != blockchain.BlockHeaderSize {
+		t.Fatalf("template header got %d bytes want %d", len(header), blockchain.BlockHeaderSize)
+	}
+	for nonce := uint32(0); ; nonce++ {
+		binary.BigEndian.PutUint32(header[blockchain.HeaderNonceOffset:], nonce)
+		hash, err := blockchain.PowHash(template.Algorithm, header)
+		if err != nil {
+			t.Fatalf("PowHash failed err: %v", err)
+		}
+		if strings.HasPrefix(hash, template.Target) == want {
+			return nonce
+		}
+	}
+}
+
+func TestBlockTemplate_SubmitBlock(t *testing.T) {
+	for _, algorithm := range []string{blockchain.PowAlgorithmSHA256, blockchain.PowAlgorithmScrypt} {
+		t.Run(algorithm, func(t *testing.T) {
+			chain := newPowChain(t, algorithm, 2)
+			tx := newSignedTx(t, 10, 0.5)
+			if err := chain.AddTransction2Pool(tx); err != nil {
+				t.Fatalf("AddTransction2Pool failed err: %v", err)
+			}
+
+			template, err := chain.NewBlockTemplate("miner")
+			if err != nil {
+				t.Fatalf("NewBlockTemplate failed err: %v", err)
+			}
+			if template.Height != 1 || template.Target != "00" || template.Algorithm != algorithm {
+				t.Errorf("template got height %d target %q algorithm %s", template.Height, template.Target, template.Algorithm)
+			}
+			if len(template.Transactions) != 2 || template.Transactions[0].ID != tx.ID() || template.Transactions[1].Amount != 50.5 {
+				t.Errorf("template transactions got %+v", template.Transactions)
+			}
+
+			if _, err := chain.SubmitBlock(template.TemplateID, solveTemplate(t, template, false)); !errors.Is(err, blockchain.ErrInvalidProofOfWork) {
+				t.Errorf("SubmitBlock got err %v want %v", err, blockchain.ErrInvalidProofOfWork)
+			}
+			hash, err := chain.SubmitBlock(template.TemplateID, solveTemplate(t, template, true))
+			if err != nil {
+				t.Fatalf("SubmitBlock failed err: %v", err)
+			}
+			block, _ := chain.GetBlock(1)
+			if chain.Height() != 1 || block.Hash() != hash {
+				t.Errorf("submitted block should be connected, height %d", chain.Height())
+			}
+			if chain.Mempool().Has(tx.ID()) {
+				t.Errorf("mined transaction should leave the pool")
+			}
+			if !chain.IsValidChain() {
+				t.Errorf("chain should be valid")
+			}
+		})
+	}
+}
+
+func TestBlockTemplate_StaleAndUnknown(t *testing.T) {
+	chain := newPowChain(t, blockchain.PowAlgorithmSHA256, 1)
+	template, err := chain.NewBlockTemplate("miner")
+	if err != nil {
+		t.Fatalf("NewBlockTemplate failed err: %v", err)
+	}
+	//模板发出去之后别人先挖到了
+	if err := chain.MineTransctionFromPool("another miner"); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	if _, err := chain.SubmitBlock(template.TemplateID, solveTemplate(t, template, true)); !errors.Is(err, blockchain.ErrStaleTip) {
+		t.Errorf("SubmitBlock got err %v want %v", err, blockchain.ErrStaleTip)
+	}
+	if _, err := chain.SubmitBlock("unknown", 1); !errors.Is(err, blockchain.ErrUnknownTemplate) {
+		t.Errorf("SubmitBlock got err %v want %v", err, blockchain.ErrUnknownTemplate)
+	}
+	if _, err := chain.NewBlockTemplate(""); err == nil {
+		t.Errorf("empty reward address should be rejected")
+	}
+}
+
+func TestBlockTemplate_SeparateStores(t *testing.T) {
+	chain := newPowChain(t, blockchain.PowAlgorithmSHA256, 1)
+	store := blockchain.NewTemplateStore(1)
+	template, err := chain.NewBlockTemplateIn(store, "pool")
+	if err != nil {
+		t.Fatalf("NewBlockTemplateIn failed err: %v", err)
+	}
+	//链自带的store里的模板再多也挤不掉别的store里的
+	for i := 0; i < 100; i++ {
+		if _, err := chain.NewBlockTemplate(fmt.Sprintf("miner%d", i)); err != nil {
+			t.Fatalf("NewBlockTemplate failed err: %v", err)
+		}
+	}
+	if _, err := chain.SubmitBlock(template.TemplateID, 0); !errors.Is(err, blockchain.ErrUnknownTemplate) {
+		t.Errorf("SubmitBlock got err %v want %v", err, blockchain.ErrUnknownTemplate)
+	}
+	if _, err := chain.TemplateHeader(store, template.TemplateID, 0); err != nil {
+		t.Fatalf("TemplateHeader failed err: %v", err)
+	}
+
+	//超过store的上限，最早的模板被挤掉
+	if _, err := chain.NewBlockTemplateIn(store, "another pool"); err != nil {
+		t.Fatalf("NewBlockTemplateIn failed err: %v", err)
+	}
+	if _, err := chain.SubmitBlockWithExtraNonce(store, template.TemplateID, 0, solveTemplate(t, template, true)); !errors.Is(err, blockchain.ErrUnknownTemplate) {
+		t.Errorf("SubmitBlockWithExtraNonce got err %v want %v", err, blockchain.ErrUnknownTemplate)
+	}
+}
+
+func TestBlockTemplate_UnsupportedEngine(t *testing.T) {
+	params, signers := newPoAParams(t, 1)
+	chain, _ := newPoAChain(t, params, signers...)
+	if _, err := chain.NewBlockTemplate("miner"); !errors.Is(err, blockchain.ErrExternalMiningUnsupported) {
+		t.Errorf("NewBlockTemplate got err %v want %v", err, blockchain.ErrExternalMiningUnsupported)
+	}
+}
@@ -0,0 +1,43 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"CcCoin-go-version/internal/encryption"
+	"errors"
+	"testing"
+)
+
+func TestBlockchain_Checkpoints(t *testing.T) {
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	myChain := blockchain.NewBlockchain(1)
+	for i := 0; i < 2; i++ {
+		if err := myChain.MineTransctionFromPool(minerPublicKey); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+	}
+	block, _ := myChain.GetBlock(2)
+
+	myChain.SetCheckpoints([]blockchain.Checkpoint{{Height: 2, Hash: block.Hash()}})
+	if !myChain.IsValidChain() {
+		t.Errorf("chain matching its checkpoint should be valid")
+	}
+
+	myChain.SetCheckpoints([]blockchain.Checkpoint{{Height: 1, Hash: block.Hash()}})
+	if myChain.IsValidChain() {
+		t.Errorf("chain conflicting with a checkpoint should be rejected")
+	}
+}
+
+func TestBlockchain_RejectBlockConflictingWithCheckpoint(t *testing.T) {
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	myChain := blockchain.NewBlockchain(1)
+	myChain.SetCheckpoints([]blockchain.Checkpoint{{Height: 1, Hash: "not-the-mined-hash"}})
+
+	err := myChain.MineTransctionFromPool(minerPublicKey)
+	if !errors.Is(err, blockchain.ErrCheckpointMismatch) {
+		t.Errorf("MineTransctionFromPool got err %v want %v", err, blockchain.ErrCheckpointMismatch)
+	}
+	if myChain.Height() != 0 {
+		t.Errorf("conflicting block should not be connected, height %d", myChain.Height())
+	}
+}
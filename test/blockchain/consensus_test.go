@@ -0,0 +1,152 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"CcCoin-go-version/internal/encryption"
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// countingEngine 包一层工作量证明，记录每个方法被调用的次数，用来确认链确实通过引擎出块和校验
+type countingEngine struct {
+	*blockchain.ProofOfWork
+	prepared, sealed, verified int
+}
+
+func (engine *countingEngine) Name() string {
+	return "counting"
+}
+
+func (engine *countingEngine) Prepare(chain blockchain.ChainReader, block *blockchain.Block) error {
+	engine.prepared++
+	return engine.ProofOfWork.Prepare(chain, block)
+}
+
+func (engine *countingEngine) Seal(ctx context.Context, chain blockchain.ChainReader, block *blockchain.Block, opts blockchain.SealOptions) error {
+	engine.sealed++
+	return engine.ProofOfWork.Seal(ctx, chain, block, opts)
+}
+
+func (engine *countingEngine) VerifySeal(chain blockchain.ChainReader, block *blockchain.Block) error {
+	engine.verified++
+	return engine.ProofOfWork.VerifySeal(chain, block)
+}
+
+// tamperingEngine 照常挖矿，封装完再把区块换成craft拼出来的区块，模拟有问题的共识引擎
+type tamperingEngine struct {
+	*blockchain.ProofOfWork
+	craft func() blockchain.Block
+}
+
+func (engine *tamperingEngine) Seal(ctx context.Context, chain blockchain.ChainReader, block *blockchain.Block, opts blockchain.SealOptions) error {
+	if err := engine.ProofOfWork.Seal(ctx, chain, block, opts); err != nil {
+		return err
+	}
+	*block = engine.craft()
+	return nil
+}
+
+func TestConsensus_SealedBlockCheckedAgainstState(t *testing.T) {
+	senderPrivateKey, sender := encryption.GenerateKeyPair()
+	_, receiver := encryption.GenerateKeyPair()
+	params := fundedParams(blockchain.RegTestParams)
+	params.Genesis.Allocations[sender] = 100
+	engine := &tamperingEngine{ProofOfWork: blockchain.NewProofOfWork()}
+	chain, err := blockchain.NewBlockchainWithEngine(params, engine)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithEngine failed err: %v", err)
+	}
+
+	//本节点挖出来的区块和外部区块按同样的账本规则校验
+	overspend, _ := blockchain.NewTransactionWithNonce(sender, senderPrivateKey, receiver, 1000, 0, 0)
+	engine.craft = func() blockchain.Block { return craftBlock(t, chain, "miner", overspend) }
+	err = chain.MineTransctionFromPool("miner")
+	expectValidationError(t, err, blockchain.ErrOverspend, 1)
+	if chain.Height() != 0 || chain.GetBalance(sender) != 100 {
+		t.Errorf("overspending block should not be connected, height %d balance %v", chain.Height(), chain.GetBalance(sender))
+	}
+	if stats := chain.MiningStats(); stats.BlocksMined != 0 {
+		t.Errorf("rejected block should not count as mined, blocks mined %d", stats.BlocksMined)
+	}
+
+	engine.craft = func() blockchain.Block { return craftBlock(t, chain, "miner") }
+	if err := chain.MineTransctionFromPool("miner"); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	if stats := chain.MiningStats(); stats.BlocksMined != 1 {
+		t.Errorf("blocks mined got %d want 1", stats.BlocksMined)
+	}
+}
+
+func TestConsensus_DefaultIsProofOfWork(t *testing.T) {
+	for _, params := range []blockchain.ChainParams{blockchain.MainNetParams, blockchain.TestNetParams, blockchain.RegTestParams} {
+		engine, err := blockchain.NewConsensusEngine(params)
+		if err != nil {
+			t.Fatalf("%s: NewConsensusEngine failed err: %v", params.Name, err)
+		}
+		if engine.Name() != blockchain.ConsensusProofOfWork {
+			t.Errorf("%s: engine got %s want %s", params.Name, engine.Name(), blockchain.ConsensusProofOfWork)
+		}
+	}
+
+	params := blockchain.RegTestParams
+	params.Consensus = "unknown"
+	if _, err := blockchain.NewBlockchainWithParams(params); err == nil {
+		t.Errorf("unknown consensus engine should be rejected")
+	}
+}
+
+func TestConsensus_CustomEngine(t *testing.T) {
+	engine := &countingEngine{ProofOfWork: blockchain.NewProofOfWork()}
+	chain, err := blockchain.NewBlockchainWithEngine(blockchain.RegTestParams, engine)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithEngine failed err: %v", err)
+	}
+	if chain.Engine() != engine {
+		t.Fatalf("chain should use the given engine")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := chain.MineTransctionFromPool("miner"); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+	}
+	if engine.prepared != 2 || engine.sealed != 2 {
+		t.Errorf("prepared %d sealed %d want 2 and 2", engine.prepared, engine.sealed)
+	}
+	if !chain.IsValidChain() {
+		t.Fatalf("chain should be valid")
+	}
+	if engine.verified != 2 {
+		t.Errorf("verified %d want 2", engine.verified)
+	}
+
+	//regtest难度为1，每个区块的权重是16
+	if weight := chain.TotalWeight(); weight.Cmp(big.NewInt(32)) != 0 {
+		t.Errorf("total weight got %v want 32", weight)
+	}
+}
+
+func TestProofOfWork_VerifySeal(t *testing.T) {
+	chain, err := blockchain.NewBlockchainWithParams(blockchain.RegTestParams)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithParams failed err: %v", err)
+	}
+	if err := chain.MineTransctionFromPool("miner"); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	block, _ := chain.GetBlock(1)
+
+	pow := blockchain.NewProofOfWork()
+	if err := pow.VerifySeal(chain, &block); err != nil {
+		t.Errorf("mined block should pass VerifySeal err: %v", err)
+	}
+
+	//难度更高的链上，这个区块的hash就不够了
+	harder := blockchain.NewBlockchain(40)
+	if err := pow.VerifySeal(harder, &block); !errors.Is(err, blockchain.ErrInvalidProofOfWork) {
+		t.Errorf("VerifySeal got err %v want %v", err, blockchain.ErrInvalidProofOfWork)
+	}
+}
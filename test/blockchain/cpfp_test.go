@@ -0,0 +1,264 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"CcCoin-go-version/internal/encryption"
+	"errors"
+	"testing"
+)
+
+func TestMempool_TrackAncestorsAndDescendants(t *testing.T) {
+	alicePrivateKey, alicePublicKey := encryption.GenerateKeyPair()
+	_, bobPublicKey := encryption.GenerateKeyPair()
+
+	pool := blockchain.NewMempool(blockchain.DefaultMempoolConfig())
+	parent, _ := blockchain.NewTransactionWithNonce(alicePublicKey, alicePrivateKey, bobPublicKey, 10, 0, 0)
+	child, _ := blockchain.NewTransactionWithNonce(alicePublicKey, alicePrivateKey, bobPublicKey, 5, 0, 1)
+	grandchild, _ := blockchain.NewTransactionWithNonce(alicePublicKey, alicePrivateKey, bobPublicKey, 1, 0, 2)
+	//孙交易先到，父交易最后到，父子关系只看nonce，和进池的顺序无关
+	for _, tx := range []blockchain.Transaction{grandchild, parent, child} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatalf("Add failed err: %v", err)
+		}
+	}
+
+	if ancestors := pool.Ancestors(grandchild.ID()); len(ancestors) != 2 || ancestors[0].ID() != parent.ID() || ancestors[1].ID() != child.ID() {
+		t.Errorf("grandchild ancestors got %v want parent and child", ancestors)
+	}
+	if descendants := pool.Descendants(parent.ID()); len(descendants) != 2 || descendants[0].ID() != child.ID() || descendants[1].ID() != grandchild.ID() {
+		t.Errorf("parent descendants got %v want child and grandchild", descendants)
+	}
+
+	pool.Remove(parent.ID())
+	if ancestors := pool.Ancestors(grandchild.ID()); len(ancestors) != 1 || ancestors[0].ID() != child.ID() {
+		t.Errorf("grandchild ancestors after parent removed got %v want child", ancestors)
+	}
+}
+
+func TestMempool_PaymentIsNotAParent(t *testing.T) {
+	attackerPrivateKey, attackerPublicKey := encryption.GenerateKeyPair()
+	bobPrivateKey, bobPublicKey := encryption.GenerateKeyPair()
+	_, carolPublicKey := encryption.GenerateKeyPair()
+
+	//bob的交易先进池，攻击者再给bob转一笔手续费很低的小钱
+	bobTx, _ := blockchain.NewTransactionWithNonce(bobPublicKey, bobPrivateKey, carolPublicKey, 10, 0.01, 0)
+	dust, _ := blockchain.NewTransactionWithNonce(attackerPublicKey, attackerPrivateKey, bobPublicKey, 0.001, 0.00001, 0)
+	bobNext, _ := blockchain.NewTransactionWithNonce(bobPublicKey, bobPrivateKey, carolPublicKey, 10, 0.01, 1)
+	config := blockchain.DefaultMempoolConfig()
+	config.MaxSize = bobTx.Size() + dust.Size() + bobNext.Size()
+	pool := blockchain.NewMempool(config)
+	for _, tx := range []blockchain.Transaction{bobTx, dust, bobNext} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatalf("Add failed err: %v", err)
+		}
+	}
+	if ancestors := pool.Ancestors(bobNext.ID()); len(ancestors) != 1 || ancestors[0].ID() != bobTx.ID() {
+		t.Errorf("bob's next transaction ancestors got %v want only bob's previous transaction", ancestors)
+	}
+	if descendants := pool.Descendants(dust.ID()); len(descendants) != 0 {
+		t.Errorf("payment to bob should not adopt bob's transactions, got %d descendants", len(descendants))
+	}
+
+	//池子满了驱逐费率最低的转账时，不会把bob的交易一起带走
+	if err := pool.Add(newSignedTx(t, 10, 0.1)); err != nil {
+		t.Fatalf("Add failed err: %v", err)
+	}
+	if pool.Has(dust.ID()) || !pool.Has(bobTx.ID()) || !pool.Has(bobNext.ID()) {
+		t.Errorf("only the dust payment should be evicted")
+	}
+}
+
+func TestBlockchain_ChildPaysForParent(t *testing.T) {
+	alicePrivateKey, alicePublicKey := fundedKeyPair()
+	carolPrivateKey, carolPublicKey := fundedKeyPair()
+	_, bobPublicKey := encryption.GenerateKeyPair()
+	_, minerPublicKey := encryption.GenerateKeyPair()
+
+	//alice的交易手续费太低卡住了，她的下一笔交易付了高手续费来加速它
+	stuckParent, _ := blockchain.NewTransactionWithNonce(alicePublicKey, alicePrivateKey, bobPublicKey, 10, 0, 0)
+	rescueChild, _ := blockchain.NewTransactionWithNonce(alicePublicKey, alicePrivateKey, bobPublicKey, 5, 1, 1)
+	//不相关的交易，单独看手续费比父交易高，但比父子两笔的整体费率低
+	unrelated, _ := blockchain.NewTransactionWithNonce(carolPublicKey, carolPrivateKey, alicePublicKey, 1, 0.1, 0)
+
+	myChain := newFundedChain(t, 1)
+	//区块只放得下两笔交易
+	myChain.SetMaxBlockSize(stuckParent.Size() + rescueChild.Size())
+	for _, tx := range []blockchain.Transaction{stuckParent, unrelated, rescueChild} {
+		if err := myChain.AddTransction2Pool(tx); err != nil {
+			t.Fatalf("Failed to add transaction to pool: %v", err)
+		}
+	}
+
+	if err := myChain.MineTransctionFromPool(minerPublicKey); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+
+	pool := myChain.Mempool()
+	if pool.Has(stuckParent.ID()) || pool.Has(rescueChild.ID()) {
+		t.Errorf("parent and child should be mined together")
+	}
+	if !pool.Has(unrelated.ID()) {
+		t.Errorf("unrelated transaction should wait for the next block")
+	}
+	if !myChain.IsValidChain() {
+		t.Errorf("chain should be valid")
+	}
+}
+
+func TestBlockchain_RecipientPaysForParent(t *testing.T) {
+	alicePrivateKey, alicePublicKey := fundedKeyPair()
+	carolPrivateKey, carolPublicKey := fundedKeyPair()
+	bobPrivateKey, bobPublicKey := encryption.GenerateKeyPair()
+	_, davePublicKey := encryption.GenerateKeyPair()
+	_, minerPublicKey := encryption.GenerateKeyPair()
+
+	//alice转给bob的交易手续费太低卡住了，bob还没有已确认的余额，花这笔还没上链的钱、付高手续费来加速它
+	stuckParent, _ := blockchain.NewTransactionWithNonce(alicePublicKey, alicePrivateKey, bobPublicKey, 10, 0, 0)
+	rescueChild, _ := blockchain.NewTransactionWithNonce(bobPublicKey, bobPrivateKey, davePublicKey, 5, 1, 0)
+	unrelated, _ := blockchain.NewTransactionWithNonce(carolPublicKey, carolPrivateKey, davePublicKey, 1, 0.1, 0)
+
+	myChain := newFundedChain(t, 1)
+	myChain.SetMaxBlockSize(stuckParent.Size() + rescueChild.Size())
+	if err := myChain.AddTransction2Pool(rescueChild); !errors.Is(err, blockchain.ErrOverspend) {
+		t.Fatalf("spending before the payment arrives got err %v want %v", err, blockchain.ErrOverspend)
+	}
+	for _, tx := range []blockchain.Transaction{stuckParent, unrelated, rescueChild} {
+		if err := myChain.AddTransction2Pool(tx); err != nil {
+			t.Fatalf("Failed to add transaction to pool: %v", err)
+		}
+	}
+	//借来的钱也不能超支
+	overspend, _ := blockchain.NewTransactionWithNonce(bobPublicKey, bobPrivateKey, davePublicKey, 5, 0, 1)
+	if err := myChain.AddTransction2Pool(overspend); !errors.Is(err, blockchain.ErrOverspend) {
+		t.Errorf("AddTransction2Pool got err %v want %v", err, blockchain.ErrOverspend)
+	}
+
+	pool := myChain.Mempool()
+	if ancestors := pool.Ancestors(rescueChild.ID()); len(ancestors) != 1 || ancestors[0].ID() != stuckParent.ID() {
+		t.Errorf("rescue ancestors got %v want the stuck payment", ancestors)
+	}
+	if descendants := pool.Descendants(stuckParent.ID()); len(descendants) != 1 || descendants[0].ID() != rescueChild.ID() {
+		t.Errorf("stuck payment descendants got %v want the rescue", descendants)
+	}
+
+	if err := myChain.MineTransctionFromPool(minerPublicKey); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	if pool.Has(stuckParent.ID()) || pool.Has(rescueChild.ID()) || !pool.Has(unrelated.ID()) {
+		t.Errorf("payment and rescue should be mined together before the unrelated transaction")
+	}
+	if balance := myChain.GetBalance(bobPublicKey); balance != 4 {
+		t.Errorf("bob balance got %v want 4", balance)
+	}
+	if !myChain.IsValidChain() {
+		t.Errorf("chain should be valid")
+	}
+}
+
+func TestBlockchain_ReplacedFundingEvictsDependents(t *testing.T) {
+	alicePrivateKey, alicePublicKey := fundedKeyPair()
+	carolPrivateKey, carolPublicKey := fundedKeyPair()
+	bobPrivateKey, bobPublicKey := encryption.GenerateKeyPair()
+	_, davePublicKey := encryption.GenerateKeyPair()
+
+	payment, _ := blockchain.NewTransactionWithNonce(alicePublicKey, alicePrivateKey, bobPublicKey, 10, 0.01, 0)
+	spend, _ := blockchain.NewTransactionWithNonce(bobPublicKey, bobPrivateKey, davePublicKey, 5, 0.01, 0)
+	spendNext, _ := blockchain.NewTransactionWithNonce(bobPublicKey, bobPrivateKey, davePublicKey, 1, 0.01, 1)
+	//carol自己的余额就付得起，alice转给她的钱不算依赖
+	carolTx, _ := blockchain.NewTransactionWithNonce(carolPublicKey, carolPrivateKey, davePublicKey, 1, 0.01, 0)
+
+	myChain := newFundedChain(t, 1)
+	for _, tx := range []blockchain.Transaction{payment, spend, spendNext, carolTx} {
+		if err := myChain.AddTransction2Pool(tx); err != nil {
+			t.Fatalf("Failed to add transaction to pool: %v", err)
+		}
+	}
+
+	//alice把转给bob的钱改成转给carol，bob花的钱没了来源
+	replacement, _ := blockchain.NewTransactionWithNonce(alicePublicKey, alicePrivateKey, carolPublicKey, 10, 0.1, 0)
+	if err := myChain.AddTransction2Pool(replacement); err != nil {
+		t.Fatalf("replacement failed err: %v", err)
+	}
+	pool := myChain.Mempool()
+	if pool.Has(spend.ID()) || pool.Has(spendNext.ID()) {
+		t.Errorf("transactions spending the replaced payment should be evicted")
+	}
+	if !pool.Has(carolTx.ID()) || len(pool.Ancestors(carolTx.ID())) != 0 {
+		t.Errorf("affordable transaction should not depend on an incoming payment")
+	}
+}
+
+func TestMempool_ClusterLimit(t *testing.T) {
+	senderPrivateKey, senderPublicKey := fundedKeyPair()
+	_, receiverPublicKey := encryption.GenerateKeyPair()
+	myChain := newFundedChain(t, 1)
+	for nonce := uint64(0); nonce < 64; nonce++ {
+		tx, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, 1, 0.01, nonce)
+		if err := myChain.AddTransction2Pool(tx); err != nil {
+			t.Fatalf("Failed to add transaction %d to pool: %v", nonce, err)
+		}
+	}
+	//一簇互相依赖的交易最多64笔
+	tx, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, 1, 0.01, 64)
+	if err := myChain.AddTransction2Pool(tx); !errors.Is(err, blockchain.ErrMempoolTooManyRelated) {
+		t.Errorf("AddTransction2Pool got err %v want %v", err, blockchain.ErrMempoolTooManyRelated)
+	}
+}
+
+func TestMempool_EvictByDescendantFeeRate(t *testing.T) {
+	alicePrivateKey, alicePublicKey := encryption.GenerateKeyPair()
+	_, bobPublicKey := encryption.GenerateKeyPair()
+	parent, _ := blockchain.NewTransactionWithNonce(alicePublicKey, alicePrivateKey, bobPublicKey, 10, 0.0001, 0)
+	child, _ := blockchain.NewTransactionWithNonce(alicePublicKey, alicePrivateKey, bobPublicKey, 10, 1, 1)
+	low := newSignedTx(t, 10, 0.001)
+
+	config := blockchain.DefaultMempoolConfig()
+	config.MaxSize = parent.Size() + child.Size() + low.Size()
+	pool := blockchain.NewMempool(config)
+	for _, tx := range []blockchain.Transaction{parent, child, low} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatalf("Add failed err: %v", err)
+		}
+	}
+
+	//父交易自己的费率最低，但有高手续费的子交易撑腰，被驱逐的是low
+	if err := pool.Add(newSignedTx(t, 10, 0.01)); err != nil {
+		t.Fatalf("Add failed err: %v", err)
+	}
+	if !pool.Has(parent.ID()) || !pool.Has(child.ID()) || pool.Has(low.ID()) {
+		t.Fatalf("low should be evicted instead of the parent")
+	}
+
+	//子交易走了之后，父交易只剩自己的费率，下一次就轮到它
+	pool.Remove(child.ID())
+	for _, tx := range []blockchain.Transaction{newSignedTx(t, 10, 0.01), newSignedTx(t, 10, 0.01)} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatalf("Add failed err: %v", err)
+		}
+	}
+	if pool.Has(parent.ID()) {
+		t.Errorf("parent without its child should be evicted")
+	}
+}
+
+// BenchmarkMempool_AssembleBlock 交易池里有fundedKeyCount个发送者、每个发送者一串连续nonce的交易时，生成区块模板的耗时
+// go test ./test/blockchain -run '^$' -bench AssembleBlock
+func BenchmarkMempool_AssembleBlock(b *testing.B) {
+	const txsPerSender = 40
+	chain := newFundedChain(b, 1)
+	_, receiverPublicKey := encryption.GenerateKeyPair()
+	for nonce := uint64(0); nonce < txsPerSender; nonce++ {
+		for i, sender := range fundedKeys {
+			tx, _ := blockchain.NewTransactionWithNonce(sender.address, sender.privateKey, receiverPublicKey, 10, float64(i%7+1)*0.001, nonce)
+			if err := chain.AddTransction2Pool(tx); err != nil {
+				b.Fatalf("Failed to add transaction to pool: %v", err)
+			}
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := chain.NewBlockTemplate("minerPublicKey"); err != nil {
+			b.Fatalf("NewBlockTemplate failed err: %v", err)
+		}
+	}
+}
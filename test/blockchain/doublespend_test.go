@@ -0,0 +1,135 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"CcCoin-go-version/internal/encryption"
+	"errors"
+	"testing"
+)
+
+func TestMempool_RejectDoubleSpend(t *testing.T) {
+	senderPrivateKey, senderPublicKey := encryption.GenerateKeyPair()
+	_, receiverPublicKey := encryption.GenerateKeyPair()
+	_, otherPublicKey := encryption.GenerateKeyPair()
+
+	pool := blockchain.NewMempool(blockchain.DefaultMempoolConfig())
+	first, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, 10, 0.01, 0)
+	if err := pool.Add(first); err != nil {
+		t.Fatalf("Add failed err: %v", err)
+	}
+
+	//同一个nonce、手续费没有提高的第二笔交易是双花，要被拒绝
+	second, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, otherPublicKey, 10, 0.01, 0)
+	if err := pool.Add(second); !errors.Is(err, blockchain.ErrMempoolConflict) {
+		t.Fatalf("Add got err %v want %v", err, blockchain.ErrMempoolConflict)
+	}
+
+	//手续费提高足够多的话，可以替换掉旧交易
+	replacement, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, otherPublicKey, 10, 0.1, 0)
+	if err := pool.Add(replacement); err != nil {
+		t.Fatalf("replacement should be accepted err: %v", err)
+	}
+	if pool.Has(first.ID()) || !pool.Has(replacement.ID()) || pool.Count() != 1 {
+		t.Errorf("replacement should evict the original transaction")
+	}
+}
+
+func TestBlockchain_RejectConfirmedSpend(t *testing.T) {
+	myChain := newFundedChain(t, 1)
+	senderPrivateKey, senderPublicKey := fundedKeyPair()
+	_, receiverPublicKey := encryption.GenerateKeyPair()
+	_, minerPublicKey := encryption.GenerateKeyPair()
+
+	tx, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, 10, 0, 0)
+	if err := myChain.AddTransction2Pool(tx); err != nil {
+		t.Fatalf("Failed to add transaction to pool: %v", err)
+	}
+	if err := myChain.MineTransctionFromPool(minerPublicKey); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	if nonce := myChain.AccountNonce(senderPublicKey); nonce != 1 {
+		t.Errorf("AccountNonce got %d want 1", nonce)
+	}
+
+	//nonce 0已经上链，再花一次就是双花
+	again, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, minerPublicKey, 10, 1, 0)
+	if err := myChain.AddTransction2Pool(again); !errors.Is(err, blockchain.ErrDoubleSpend) {
+		t.Errorf("AddTransction2Pool got err %v want %v", err, blockchain.ErrDoubleSpend)
+	}
+	if !myChain.IsValidChain() {
+		t.Errorf("chain should be valid")
+	}
+}
+
+func TestMempool_ReplacementWhenFull(t *testing.T) {
+	senderPrivateKey, senderPublicKey := encryption.GenerateKeyPair()
+	_, receiverPublicKey := encryption.GenerateKeyPair()
+
+	original, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, 10, 0.01, 0)
+	other := newSignedTx(t, 10, 1)
+	config := blockchain.DefaultMempoolConfig()
+	config.MaxSize = original.Size() + other.Size()
+	pool := blockchain.NewMempool(config)
+	for _, tx := range []blockchain.Transaction{original, other} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatalf("Add failed err: %v", err)
+		}
+	}
+
+	//替换交易的收款地址更长，换进来之后池子超出容量，而它自己的费率最低，要被驱逐
+	replacement, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey+receiverPublicKey, 10, 0.02, 0)
+	if err := pool.Add(replacement); !errors.Is(err, blockchain.ErrMempoolFull) {
+		t.Fatalf("Add got err %v want %v", err, blockchain.ErrMempoolFull)
+	}
+	//新旧两笔交易不能都丢掉，替换被撤销，原来的交易还在池子里
+	if !pool.Has(original.ID()) || pool.Has(replacement.ID()) || !pool.Has(other.ID()) {
+		t.Errorf("failed replacement should keep the original transaction")
+	}
+	if pool.Size() != config.MaxSize {
+		t.Errorf("pool size got %d want %d", pool.Size(), config.MaxSize)
+	}
+}
+
+func TestBlockchain_ReplacementEvictsUnaffordableDescendants(t *testing.T) {
+	myChain := newFundedChain(t, 1)
+	senderPrivateKey, senderPublicKey := fundedKeyPair()
+	_, receiverPublicKey := encryption.GenerateKeyPair()
+	balance := myChain.GetBalance(senderPublicKey)
+
+	first, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, 10, 0.01, 0)
+	//第二笔交易花掉了第一笔之后剩下的几乎所有钱
+	second, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, balance-100, 0.01, 1)
+	third, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, 1, 0.01, 2)
+	for _, tx := range []blockchain.Transaction{first, second, third} {
+		if err := myChain.AddTransction2Pool(tx); err != nil {
+			t.Fatalf("Failed to add transaction to pool: %v", err)
+		}
+	}
+
+	pool := myChain.Mempool()
+	//金额没怎么变的替换不影响后面的交易
+	cheap, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, 20, 0.1, 0)
+	if err := myChain.AddTransction2Pool(cheap); err != nil {
+		t.Fatalf("replacement should be accepted err: %v", err)
+	}
+	if !pool.Has(second.ID()) || !pool.Has(third.ID()) {
+		t.Fatalf("affordable descendants should stay in pool")
+	}
+
+	//金额更大的替换花掉了第二笔交易要用的钱，第二笔和依赖它的第三笔都付不起了
+	expensive, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, 1000, 1, 0)
+	if err := myChain.AddTransction2Pool(expensive); err != nil {
+		t.Fatalf("replacement should be accepted err: %v", err)
+	}
+	if !pool.Has(expensive.ID()) || pool.Has(cheap.ID()) || pool.Has(second.ID()) || pool.Has(third.ID()) {
+		t.Errorf("replacement should evict descendants it can no longer pay for")
+	}
+
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	if err := myChain.MineTransctionFromPool(minerPublicKey); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	if !myChain.IsValidChain() {
+		t.Errorf("chain should be valid")
+	}
+}
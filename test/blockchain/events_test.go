@@ -0,0 +1,201 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"CcCoin-go-version/internal/encryption"
+	"testing"
+	"time"
+)
+
+// nextEvent 等订阅收到下一个事件，一直等不到就让测试失败
+func nextEvent(t *testing.T, sub *blockchain.Subscription) blockchain.Event {
+	t.Helper()
+	select {
+	case event, ok := <-sub.Events():
+		if !ok {
+			t.Fatalf("subscription closed while waiting for event")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+	return blockchain.Event{}
+}
+
+func expectNoEvent(t *testing.T, sub *blockchain.Subscription) {
+	t.Helper()
+	select {
+	case event := <-sub.Events():
+		t.Fatalf("unexpected event %v", event.Type)
+	default:
+	}
+}
+
+func TestEvents_ConnectAndDisconnect(t *testing.T) {
+	myChain := newFundedChain(t, 1)
+	sub := myChain.Subscribe(0)
+	defer sub.Unsubscribe()
+	_, minerPublicKey := encryption.GenerateKeyPair()
+
+	tx := newSignedTx(t, 10, 0.01)
+	if err := myChain.AddTransction2Pool(tx); err != nil {
+		t.Fatalf("Failed to add transaction to pool: %v", err)
+	}
+	if event := nextEvent(t, sub); event.Type != blockchain.EventTxAccepted || event.Transaction.ID() != tx.ID() {
+		t.Fatalf("got event %v want %v for tx", event.Type, blockchain.EventTxAccepted)
+	}
+
+	if err := myChain.MineTransctionFromPool(minerPublicKey); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	connected := nextEvent(t, sub)
+	if connected.Type != blockchain.EventBlockConnected || connected.Height != 1 {
+		t.Fatalf("got event %v at height %d want %v at height 1", connected.Type, connected.Height, blockchain.EventBlockConnected)
+	}
+	if tip, _ := myChain.GetBlock(1); connected.Block.Hash() != tip.Hash() {
+		t.Errorf("connected block hash got %s want %s", connected.Block.Hash(), tip.Hash())
+	}
+	//交易上链是正常离开交易池，不算驱逐
+	expectNoEvent(t, sub)
+
+	block, err := myChain.DisconnectTip()
+	if err != nil {
+		t.Fatalf("DisconnectTip failed err: %v", err)
+	}
+	if block.Hash() != connected.Block.Hash() || myChain.Height() != 0 {
+		t.Fatalf("DisconnectTip should remove block 1, height now %d", myChain.Height())
+	}
+	disconnected := nextEvent(t, sub)
+	if disconnected.Type != blockchain.EventBlockDisconnected || disconnected.Height != 1 || disconnected.Block.Hash() != block.Hash() {
+		t.Fatalf("got event %v at height %d want %v at height 1", disconnected.Type, disconnected.Height, blockchain.EventBlockDisconnected)
+	}
+	//断开区块里的交易回到交易池
+	if event := nextEvent(t, sub); event.Type != blockchain.EventTxAccepted || event.Transaction.ID() != tx.ID() {
+		t.Fatalf("got event %v want %v for returned tx", event.Type, blockchain.EventTxAccepted)
+	}
+	if !myChain.Mempool().Has(tx.ID()) {
+		t.Errorf("disconnected transaction should return to pool")
+	}
+	if !myChain.IsValidChain() {
+		t.Errorf("chain should be valid after disconnect")
+	}
+
+	if _, err := myChain.DisconnectTip(); err == nil {
+		t.Errorf("DisconnectTip should refuse to disconnect genesis block")
+	}
+}
+
+func TestEvents_TxEvicted(t *testing.T) {
+	senderPrivateKey, senderPublicKey := fundedKeyPair()
+	_, receiverPublicKey := encryption.GenerateKeyPair()
+	first, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, 10, 0.01, 0)
+	replacement, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, 10, 0.1, 0)
+	stale := newSignedTx(t, 10, 0.01)
+
+	config := blockchain.DefaultMempoolConfig()
+	config.Expiry = time.Hour
+	myChain := newFundedChainWithMempool(t, 1, config)
+	sub := myChain.Subscribe(0, blockchain.EventTxEvicted)
+	defer sub.Unsubscribe()
+
+	for _, tx := range []blockchain.Transaction{first, replacement, stale} {
+		if err := myChain.AddTransction2Pool(tx); err != nil {
+			t.Fatalf("Failed to add transaction to pool: %v", err)
+		}
+	}
+	if event := nextEvent(t, sub); event.Transaction.ID() != first.ID() || event.Reason != blockchain.EvictReasonReplaced {
+		t.Errorf("got eviction reason %q want %q for replaced tx", event.Reason, blockchain.EvictReasonReplaced)
+	}
+
+	myChain.Mempool().Expire(time.Now().Add(2 * time.Hour))
+	evicted := map[string]string{}
+	for i := 0; i < 2; i++ {
+		event := nextEvent(t, sub)
+		evicted[event.Transaction.ID()] = event.Reason
+	}
+	for _, tx := range []blockchain.Transaction{replacement, stale} {
+		if evicted[tx.ID()] != blockchain.EvictReasonExpired {
+			t.Errorf("got eviction reason %q want %q", evicted[tx.ID()], blockchain.EvictReasonExpired)
+		}
+	}
+	expectNoEvent(t, sub)
+}
+
+func TestEvents_EvictedBySizeLimit(t *testing.T) {
+	low := newSignedTx(t, 10, 0.001)
+	high := newSignedTx(t, 10, 0.1)
+	config := blockchain.DefaultMempoolConfig()
+	//池子只够放一笔交易
+	config.MaxSize = low.Size() + high.Size() - 1
+	myChain := newFundedChainWithMempool(t, 1, config)
+	sub := myChain.Subscribe(0)
+	defer sub.Unsubscribe()
+
+	for _, tx := range []blockchain.Transaction{low, high} {
+		if err := myChain.AddTransction2Pool(tx); err != nil {
+			t.Fatalf("Failed to add transaction to pool: %v", err)
+		}
+	}
+	want := []struct {
+		eventType blockchain.EventType
+		id        string
+		reason    string
+	}{
+		{blockchain.EventTxAccepted, low.ID(), ""},
+		{blockchain.EventTxAccepted, high.ID(), ""},
+		{blockchain.EventTxEvicted, low.ID(), blockchain.EvictReasonSizeLimit},
+	}
+	for _, w := range want {
+		event := nextEvent(t, sub)
+		if event.Type != w.eventType || event.Transaction.ID() != w.id || event.Reason != w.reason {
+			t.Errorf("got event %v reason %q want %v reason %q", event.Type, event.Reason, w.eventType, w.reason)
+		}
+	}
+
+	//费率太低、一进来就被驱逐的交易，订阅者什么都看不到
+	cheap := newSignedTx(t, 10, 0.0001)
+	if err := myChain.AddTransction2Pool(cheap); err == nil {
+		t.Fatalf("cheap transaction should be rejected")
+	}
+	expectNoEvent(t, sub)
+}
+
+func TestEvents_SlowSubscriberDoesNotBlock(t *testing.T) {
+	myChain := newFundedChain(t, 1)
+	slow := myChain.Subscribe(1)
+	defer slow.Unsubscribe()
+	blocks := myChain.Subscribe(0, blockchain.EventBlockConnected)
+	defer blocks.Unsubscribe()
+	_, minerPublicKey := encryption.GenerateKeyPair()
+
+	//慢订阅者一直不读，出块和交易进池都不能被它卡住
+	for i := 0; i < 3; i++ {
+		if err := myChain.AddTransction2Pool(newSignedTx(t, 10, 0.01)); err != nil {
+			t.Fatalf("Failed to add transaction to pool: %v", err)
+		}
+		if err := myChain.MineTransctionFromPool(minerPublicKey); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+	}
+	if slow.Dropped() != 5 {
+		t.Errorf("Dropped got %d want 5", slow.Dropped())
+	}
+	if event := nextEvent(t, slow); event.Type != blockchain.EventTxAccepted {
+		t.Errorf("slow subscriber should keep the first event, got %v", event.Type)
+	}
+
+	//只订阅了出块事件的订阅者收到了所有区块
+	for height := 1; height <= 3; height++ {
+		if event := nextEvent(t, blocks); event.Type != blockchain.EventBlockConnected || event.Height != height {
+			t.Errorf("got event %v at height %d want %v at height %d", event.Type, event.Height, blockchain.EventBlockConnected, height)
+		}
+	}
+	if blocks.Dropped() != 0 {
+		t.Errorf("Dropped got %d want 0", blocks.Dropped())
+	}
+
+	blocks.Unsubscribe()
+	if _, ok := <-blocks.Events(); ok {
+		t.Errorf("Events should be closed after Unsubscribe")
+	}
+}
@@ -0,0 +1,161 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"CcCoin-go-version/internal/encryption"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGenesis_Deterministic(t *testing.T) {
+	first := blockchain.NewBlockchain(3)
+	time.Sleep(1100 * time.Millisecond)
+	second := blockchain.NewBlockchain(3)
+
+	if first.GenesisHash() != second.GenesisHash() {
+		t.Errorf("genesis hash should not depend on start time: %s != %s", first.GenesisHash(), second.GenesisHash())
+	}
+	if !first.IsValidChain() {
+		t.Errorf("fresh chain should be valid")
+	}
+}
+
+func TestGenesis_Allocations(t *testing.T) {
+	_, alicePublicKey := encryption.GenerateKeyPair()
+	_, bobPublicKey := encryption.GenerateKeyPair()
+
+	genesis := blockchain.DefaultGenesisConfig()
+	genesis.Allocations = map[string]float64{alicePublicKey: 1000, bobPublicKey: 10}
+	myChain, err := blockchain.NewBlockchainFromGenesis(genesis, blockchain.DefaultMempoolConfig())
+	if err != nil {
+		t.Fatalf("NewBlockchainFromGenesis failed err: %v", err)
+	}
+
+	if balance := myChain.GetBalance(alicePublicKey); balance != 1000 {
+		t.Errorf("alice balance got %v want 1000", balance)
+	}
+	if balance := myChain.GetBalance(bobPublicKey); balance != 10 {
+		t.Errorf("bob balance got %v want 10", balance)
+	}
+	defaultChain := blockchain.NewBlockchain(3)
+	if myChain.GenesisHash() == defaultChain.GenesisHash() {
+		t.Errorf("allocations should be part of the genesis hash")
+	}
+}
+
+func TestGenesis_HashMismatch(t *testing.T) {
+	genesis := blockchain.DefaultGenesisConfig()
+	genesis.Hash = "not-the-genesis-hash"
+	if _, err := blockchain.NewBlockchainFromGenesis(genesis, blockchain.DefaultMempoolConfig()); !errors.Is(err, blockchain.ErrGenesisMismatch) {
+		t.Errorf("NewBlockchainFromGenesis got err %v want %v", err, blockchain.ErrGenesisMismatch)
+	}
+}
+
+func TestGenesis_CommitsConsensusRules(t *testing.T) {
+	base := blockchain.RegTestParams
+	testCases := []struct {
+		name   string
+		change func(params *blockchain.ChainParams)
+	}{
+		{name: "Difficulty", change: func(p *blockchain.ChainParams) { p.Genesis.Difficulty++ }},
+		{name: "Miner Reward", change: func(p *blockchain.ChainParams) { p.Genesis.MinerReward /= 2 }},
+		{name: "Period", change: func(p *blockchain.ChainParams) { p.Genesis.Period = 15 }},
+		{name: "Pow Algorithm", change: func(p *blockchain.ChainParams) { p.PowAlgorithm = blockchain.PowAlgorithmScrypt }},
+	}
+
+	baseChain, err := blockchain.NewBlockchainWithParams(base)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithParams failed err: %v", err)
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params := base
+			tc.change(&params)
+			chain, err := blockchain.NewBlockchainWithParams(params)
+			if err != nil {
+				t.Fatalf("NewBlockchainWithParams failed err: %v", err)
+			}
+			if chain.GenesisHash() == baseChain.GenesisHash() {
+				t.Errorf("changing the %s should change the genesis hash", tc.name)
+			}
+
+			//配置里写的是原来规则下的创世hash，换了规则就启动不了
+			params.Genesis.Hash = baseChain.GenesisHash()
+			if _, err := blockchain.NewBlockchainWithParams(params); !errors.Is(err, blockchain.ErrGenesisMismatch) {
+				t.Errorf("NewBlockchainWithParams got err %v want %v", err, blockchain.ErrGenesisMismatch)
+			}
+		})
+	}
+
+	//主网的checkpoint只认主网规则下的创世区块
+	params := blockchain.MainNetParams
+	params.PowAlgorithm = blockchain.PowAlgorithmScrypt
+	if _, err := blockchain.NewBlockchainWithParams(params); !errors.Is(err, blockchain.ErrCheckpointMismatch) {
+		t.Errorf("NewBlockchainWithParams got err %v want %v", err, blockchain.ErrCheckpointMismatch)
+	}
+}
+
+func TestGenesis_RejectInvalidStakes(t *testing.T) {
+	testCases := []struct {
+		name        string
+		allocations map[string]float64
+		stakes      map[string]float64
+	}{
+		{name: "Unfunded", allocations: map[string]float64{}, stakes: map[string]float64{"alice": 10}},
+		{name: "Above Allocation", allocations: map[string]float64{"alice": 10}, stakes: map[string]float64{"alice": 11}},
+		{name: "Zero", allocations: map[string]float64{"alice": 10}, stakes: map[string]float64{"alice": 0}},
+		{name: "NaN", allocations: map[string]float64{"alice": 10}, stakes: map[string]float64{"alice": math.NaN()}},
+		{name: "NaN Allocation", allocations: map[string]float64{"alice": math.NaN()}, stakes: map[string]float64{"alice": 10}},
+		{name: "Infinite Allocation", allocations: map[string]float64{"alice": math.Inf(1)}, stakes: map[string]float64{"alice": 10}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			genesis := blockchain.DefaultGenesisConfig()
+			genesis.Allocations = tc.allocations
+			genesis.Stakes = tc.stakes
+			//锁定权益的交易会从预挖的余额里扣，配置不对的话创世之后余额就是负的
+			if _, err := blockchain.NewBlockchainFromGenesis(genesis, blockchain.DefaultMempoolConfig()); err == nil {
+				t.Errorf("NewBlockchainFromGenesis with allocations %v and stakes %v should fail", tc.allocations, tc.stakes)
+			}
+		})
+	}
+}
+
+func TestGenesis_InvalidDifficultyPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("NewBlockchain with negative difficulty should panic instead of returning a broken chain")
+		}
+	}()
+	blockchain.NewBlockchain(-1)
+}
+
+func TestGenesis_LoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "genesis.json")
+	data := []byte(`{"timestamp": 1, "difficulty": 2, "minerReward": 25, "message": "hello", "allocations": {"alice": 5}}`)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("write genesis config failed err: %v", err)
+	}
+
+	genesis, err := blockchain.LoadGenesisConfig(path)
+	if err != nil {
+		t.Fatalf("LoadGenesisConfig failed err: %v", err)
+	}
+	if genesis.Timestamp != 1 || genesis.Difficulty != 2 || genesis.MinerReward != 25 || genesis.Message != "hello" || genesis.Allocations["alice"] != 5 {
+		t.Errorf("unexpected genesis config %+v", genesis)
+	}
+
+	//仓库里的示例配置算出来的hash要和它自己声明的一致
+	example, err := blockchain.LoadGenesisConfig("../../cmd/blockchain/genesis.json")
+	if err != nil {
+		t.Fatalf("LoadGenesisConfig failed err: %v", err)
+	}
+	if err := example.Validate(blockchain.PowAlgorithmSHA256); err != nil {
+		t.Errorf("example genesis config invalid err: %v", err)
+	}
+}
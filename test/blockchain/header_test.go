@@ -0,0 +1,123 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+// benchmarkBlockTxs 压测用的区块里的交易数
+const benchmarkBlockTxs = 100
+
+// newHeaderTemplate 生成一个带txs笔交易的区块模板
+func newHeaderTemplate(tb testing.TB, txs int) (blockchain.BlockTemplate, []blockchain.Transaction, [blockchain.BlockHeaderSize]byte) {
+	tb.Helper()
+	chain, err := blockchain.NewBlockchainWithParams(fundedParams(blockchain.RegTestParams))
+	if err != nil {
+		tb.Fatalf("NewBlockchainWithParams failed err: %v", err)
+	}
+	transactions := make([]blockchain.Transaction, 0, txs)
+	for i := 0; i < txs; i++ {
+		tx := newSignedTx(tb, 10, 0.01)
+		if err := chain.AddTransction2Pool(tx); err != nil {
+			tb.Fatalf("AddTransction2Pool failed err: %v", err)
+		}
+		transactions = append(transactions, tx)
+	}
+	template, err := chain.NewBlockTemplate("minerPublicKey")
+	if err != nil {
+		tb.Fatalf("NewBlockTemplate failed err: %v", err)
+	}
+	data, _ := hex.DecodeString(template.Header)
+	var header [blockchain.BlockHeaderSize]byte
+	if copy(header[:], data) != blockchain.BlockHeaderSize {
+		tb.Fatalf("template header got %d bytes want %d", len(data), blockchain.BlockHeaderSize)
+	}
+	return template, transactions, header
+}
+
+func TestHeader_FixedSize(t *testing.T) {
+	small, _, smallHeader := newHeaderTemplate(t, 0)
+	large, _, largeHeader := newHeaderTemplate(t, 20)
+	//区块的hash就是区块头的sha256，和交易的多少无关，区块头的长度都一样
+	for _, tc := range []struct {
+		template blockchain.BlockTemplate
+		header   [blockchain.BlockHeaderSize]byte
+	}{{small, smallHeader}, {large, largeHeader}} {
+		hash := sha256.Sum256(tc.header[:])
+		if hex.EncodeToString(hash[:]) != tc.template.TemplateID {
+			t.Errorf("block hash should be sha256 of the header")
+		}
+		prevHash, _ := hex.DecodeString(tc.template.PrevHash)
+		if !bytes.Equal(tc.header[:32], prevHash) {
+			t.Errorf("header should start with the previous block hash")
+		}
+	}
+}
+
+func TestMidstateHasher(t *testing.T) {
+	_, _, header := newHeaderTemplate(t, 5)
+	hasher := blockchain.NewMidstateHasher(&header)
+	for _, nonce := range []uint32{0, 1, 12345, 1<<32 - 1} {
+		binary.BigEndian.PutUint32(header[blockchain.HeaderNonceOffset:], nonce)
+		want := sha256.Sum256(header[:])
+		if got := hasher.Hash(&header); !bytes.Equal(got, want[:]) {
+			t.Errorf("nonce %d got hash %x want %x", nonce, got, want)
+		}
+	}
+
+	nonce := uint32(0)
+	allocs := testing.AllocsPerRun(1000, func() {
+		nonce++
+		binary.BigEndian.PutUint32(header[blockchain.HeaderNonceOffset:], nonce)
+		hasher.Hash(&header)
+	})
+	if allocs != 0 {
+		t.Errorf("midstate hashing should not allocate, got %v allocs per hash", allocs)
+	}
+}
+
+// BenchmarkHeaderHash_Rebuild 以前的做法：每个nonce都重新拼接并格式化整个区块的内容，再整个算hash
+func BenchmarkHeaderHash_Rebuild(b *testing.B) {
+	template, transactions, _ := newHeaderTemplate(b, benchmarkBlockTxs)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data := bytes.Join([][]byte{
+			[]byte(template.PrevHash),
+			[]byte(fmt.Sprintf("%v", transactions)),
+			[]byte(strconv.FormatUint(template.Timestamp, 10)),
+			[]byte(strconv.Itoa(i)),
+		}, []byte{})
+		hash := sha256.Sum256(data)
+		_ = hex.EncodeToString(hash[:])
+	}
+}
+
+// BenchmarkHeaderHash_Full 固定长度的区块头，每个nonce整个区块头算一遍sha256
+func BenchmarkHeaderHash_Full(b *testing.B) {
+	_, _, header := newHeaderTemplate(b, benchmarkBlockTxs)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		binary.BigEndian.PutUint32(header[blockchain.HeaderNonceOffset:], uint32(i))
+		sha256.Sum256(header[:])
+	}
+}
+
+// BenchmarkHeaderHash_Midstate 固定长度的区块头，复用前64字节的中间状态，挖矿实际用的做法
+func BenchmarkHeaderHash_Midstate(b *testing.B) {
+	_, _, header := newHeaderTemplate(b, benchmarkBlockTxs)
+	hasher := blockchain.NewMidstateHasher(&header)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		binary.BigEndian.PutUint32(header[blockchain.HeaderNonceOffset:], uint32(i))
+		hasher.Hash(&header)
+	}
+}
@@ -0,0 +1,142 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"CcCoin-go-version/internal/encryption"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fundedKeyCount 测试链的创世区块里预挖了余额的发送者个数，一条链上用newSignedTx发的交易不能超过这么多笔
+const fundedKeyCount = 128
+
+var (
+	fundedKeys    = newFundedKeys()
+	nextFundedKey atomic.Uint64
+)
+
+func newFundedKeys() []signerKey {
+	keys := make([]signerKey, fundedKeyCount)
+	for i := range keys {
+		keys[i].privateKey, keys[i].address = encryption.GenerateKeyPair()
+	}
+	return keys
+}
+
+// fundedParams 在params的创世区块里给fundedKeys各预挖一笔钱，主网的checkpoint对不上改过的创世区块，去掉
+func fundedParams(params blockchain.ChainParams) blockchain.ChainParams {
+	params.Genesis.Allocations = map[string]float64{}
+	for _, key := range fundedKeys {
+		params.Genesis.Allocations[key.address] = 1e6
+	}
+	params.Checkpoints = nil
+	return params
+}
+
+// newFundedChain 和blockchain.NewBlockchain(difficulty)一样按主网规则创建区块链，只是fundedKeys在创世区块里有余额
+func newFundedChain(tb testing.TB, difficulty int) *blockchain.Blockchain {
+	tb.Helper()
+	return newFundedChainWithMempool(tb, difficulty, blockchain.DefaultMempoolConfig())
+}
+
+func newFundedChainWithMempool(tb testing.TB, difficulty int, mempoolConfig blockchain.MempoolConfig) *blockchain.Blockchain {
+	tb.Helper()
+	params := blockchain.MainNetParams
+	params.Genesis.Difficulty = difficulty
+	params.Mempool = mempoolConfig
+	chain, err := blockchain.NewBlockchainWithParams(fundedParams(params))
+	if err != nil {
+		tb.Fatalf("NewBlockchainWithParams failed err: %v", err)
+	}
+	return chain
+}
+
+// fundedKeyPair 和encryption.GenerateKeyPair一样返回私钥和公钥，只是轮流从fundedKeys里取，在测试链上有余额
+func fundedKeyPair() (privateKey, publicKey string) {
+	key := fundedKeys[nextFundedKey.Add(1)%fundedKeyCount]
+	return key.privateKey, key.address
+}
+
+// newSignedTx 用fundedKeyPair的发送者签一笔nonce为0的交易，收款人每次都是新的
+func newSignedTx(t testing.TB, amount, fee float64) blockchain.Transaction {
+	t.Helper()
+	senderPrivateKey, senderPublicKey := fundedKeyPair()
+	_, receiverPublicKey := encryption.GenerateKeyPair()
+	tx, err := blockchain.NewTransactionWithFee(senderPublicKey, senderPrivateKey, receiverPublicKey, amount, fee)
+	if err != nil {
+		t.Fatalf("NewTransactionWithFee failed err: %v", err)
+	}
+	return tx
+}
+
+func TestMempool_EvictLowestFeeRateWhenFull(t *testing.T) {
+	low := newSignedTx(t, 10, 0.001)
+	mid := newSignedTx(t, 10, 0.01)
+	high := newSignedTx(t, 10, 0.1)
+
+	config := blockchain.DefaultMempoolConfig()
+	//池子只够放两笔交易
+	config.MaxSize = low.Size() + mid.Size() + high.Size() - 1
+	pool := blockchain.NewMempool(config)
+
+	for _, tx := range []blockchain.Transaction{low, mid, high} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatalf("Add failed err: %v", err)
+		}
+	}
+
+	if pool.Count() != 2 {
+		t.Fatalf("pool count got %d want 2", pool.Count())
+	}
+	if pool.Has(low.ID()) {
+		t.Errorf("lowest fee rate transaction should have been evicted")
+	}
+	if !pool.Has(mid.ID()) || !pool.Has(high.ID()) {
+		t.Errorf("higher fee rate transactions should stay in pool")
+	}
+	if pool.Size() > config.MaxSize {
+		t.Errorf("pool size %d exceeds max %d", pool.Size(), config.MaxSize)
+	}
+
+	//驱逐之后最低费率被抬高了，比被驱逐的交易费率还低的交易进不来
+	if pool.MinFeeRate() <= low.FeeRate() {
+		t.Errorf("min fee rate %v should rise above evicted fee rate %v", pool.MinFeeRate(), low.FeeRate())
+	}
+	cheap := newSignedTx(t, 10, 0)
+	if err := pool.Add(cheap); !errors.Is(err, blockchain.ErrMempoolFeeTooLow) {
+		t.Errorf("Add got err %v want %v", err, blockchain.ErrMempoolFeeTooLow)
+	}
+}
+
+func TestMempool_RejectDuplicate(t *testing.T) {
+	pool := blockchain.NewMempool(blockchain.DefaultMempoolConfig())
+	tx := newSignedTx(t, 10, 0)
+	if err := pool.Add(tx); err != nil {
+		t.Fatalf("Add failed err: %v", err)
+	}
+	if err := pool.Add(tx); !errors.Is(err, blockchain.ErrMempoolDuplicateTx) {
+		t.Errorf("Add got err %v want %v", err, blockchain.ErrMempoolDuplicateTx)
+	}
+}
+
+func TestMempool_Expire(t *testing.T) {
+	config := blockchain.DefaultMempoolConfig()
+	config.Expiry = time.Hour
+	pool := blockchain.NewMempool(config)
+	tx := newSignedTx(t, 10, 0)
+	if err := pool.Add(tx); err != nil {
+		t.Fatalf("Add failed err: %v", err)
+	}
+
+	if removed := pool.Expire(time.Now()); removed != 0 {
+		t.Errorf("fresh transaction should not expire, removed %d", removed)
+	}
+	if removed := pool.Expire(time.Now().Add(2 * time.Hour)); removed != 1 {
+		t.Errorf("expired transaction should be removed, removed %d", removed)
+	}
+	if pool.Count() != 0 || pool.Size() != 0 {
+		t.Errorf("pool should be empty after expiry, count %d size %d", pool.Count(), pool.Size())
+	}
+}
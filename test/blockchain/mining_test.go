@@ -0,0 +1,115 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMining_ParallelWorkers(t *testing.T) {
+	myChain := blockchain.NewBlockchain(3)
+	myChain.SetMiningWorkers(4)
+	for i := 0; i < 3; i++ {
+		if err := myChain.MineTransctionFromPool("minerPublicKey"); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+	}
+	if myChain.Height() != 3 || !myChain.IsValidChain() {
+		t.Errorf("parallel mining should produce a valid chain, height %d", myChain.Height())
+	}
+}
+
+func TestMining_Cancel(t *testing.T) {
+	//难度高到不可能在测试时间内挖出来
+	myChain := blockchain.NewBlockchain(32)
+	myChain.SetMiningWorkers(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := myChain.MineTransctionFromPoolWithContext(ctx, "minerPublicKey")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("MineTransctionFromPoolWithContext got err %v want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("mining should stop promptly after cancel, took %v", elapsed)
+	}
+	if myChain.Height() != 0 {
+		t.Errorf("cancelled mining should not connect a block")
+	}
+}
+
+func TestMining_Stats(t *testing.T) {
+	myChain := blockchain.NewBlockchain(32)
+	myChain.SetMiningWorkers(2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- myChain.MineTransctionFromPoolWithContext(ctx, "minerPublicKey")
+	}()
+
+	//挖矿过程中可以看到实时的进度
+	deadline := time.Now().Add(2 * time.Second)
+	var stats blockchain.MiningStats
+	for time.Now().Before(deadline) {
+		stats = myChain.MiningStats()
+		if stats.Mining && stats.Attempts > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !stats.Mining || stats.Attempts == 0 || stats.HashRate <= 0 || stats.NonceRangeStart != 0 || stats.NonceRangeEnd <= stats.NonceRangeStart {
+		t.Errorf("unexpected live mining stats %+v", stats)
+	}
+	if stats.Workers != 2 || stats.Difficulty != 32 || stats.ExpectedSeconds <= stats.ElapsedSeconds {
+		t.Errorf("unexpected live mining stats %+v", stats)
+	}
+	cancel()
+	<-done
+
+	stats = myChain.MiningStats()
+	if stats.Mining || stats.BlocksMined != 0 {
+		t.Errorf("cancelled mining should not count as mined block %+v", stats)
+	}
+
+	easyChain := blockchain.NewBlockchain(2)
+	if err := easyChain.MineTransctionFromPool("minerPublicKey"); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	stats = easyChain.MiningStats()
+	if stats.Mining || stats.BlocksMined != 1 || stats.TotalAttempts == 0 {
+		t.Errorf("unexpected mining stats after block found %+v", stats)
+	}
+}
+
+func TestMining_ExtraNonce(t *testing.T) {
+	myChain := blockchain.NewBlockchain(4)
+	myChain.SetMiningWorkers(2)
+	//每个extra nonce只试16个nonce，难度4平均要试65536次，几乎肯定要换好多次extra nonce
+	myChain.SetNonceRange(16)
+	for i := 0; i < 2; i++ {
+		if err := myChain.MineTransctionFromPool("minerPublicKey"); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+	}
+	if !myChain.IsValidChain() {
+		t.Fatalf("blocks mined with extra nonce should be valid")
+	}
+
+	stats := myChain.MiningStats()
+	if stats.ExtraNonce == 0 || stats.NonceRangeEnd >= 16 {
+		t.Errorf("unexpected mining stats %+v", stats)
+	}
+	first, _ := myChain.GetBlock(1)
+	second, _ := myChain.GetBlock(2)
+	for _, block := range []blockchain.Block{first, second} {
+		transactions := block.Transactions()
+		coinbase := transactions[len(transactions)-1]
+		if coinbase.ExtraNonce() == 0 {
+			t.Errorf("block %s should have rolled the coinbase extra nonce", block.Hash())
+		}
+	}
+}
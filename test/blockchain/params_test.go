@@ -0,0 +1,49 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"CcCoin-go-version/internal/encryption"
+	"testing"
+)
+
+func TestParams_Networks(t *testing.T) {
+	genesisHashes := map[string]bool{}
+	for _, name := range []string{"mainnet", "testnet", "regtest"} {
+		params, err := blockchain.ParamsForNetwork(name)
+		if err != nil {
+			t.Fatalf("ParamsForNetwork(%s) failed err: %v", name, err)
+		}
+		//每个网络的创世区块都要和自己的checkpoint一致
+		myChain, err := blockchain.NewBlockchainWithParams(params)
+		if err != nil {
+			t.Fatalf("NewBlockchainWithParams(%s) failed err: %v", name, err)
+		}
+		genesisHashes[myChain.GenesisHash()] = true
+	}
+	if len(genesisHashes) != 3 {
+		t.Errorf("each network should have its own genesis block")
+	}
+
+	if _, err := blockchain.ParamsForNetwork("unknown"); err == nil {
+		t.Errorf("unknown network should be rejected")
+	}
+}
+
+func TestParams_RegTestInstantBlocks(t *testing.T) {
+	myChain, err := blockchain.NewBlockchainWithParams(blockchain.RegTestParams)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithParams failed err: %v", err)
+	}
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	for i := 0; i < 100; i++ {
+		if err := myChain.MineTransctionFromPool(minerPublicKey); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+	}
+	if myChain.Height() != 100 {
+		t.Errorf("height got %d want 100", myChain.Height())
+	}
+	if balance := myChain.GetBalance(minerPublicKey); balance != 100*blockchain.RegTestParams.Genesis.MinerReward {
+		t.Errorf("miner balance got %v", balance)
+	}
+}
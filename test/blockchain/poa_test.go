@@ -0,0 +1,175 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"CcCoin-go-version/internal/encryption"
+	"errors"
+	"sort"
+	"testing"
+)
+
+type signerKey struct {
+	address, privateKey string
+}
+
+// newPoAParams 生成n个签名者，返回权威证明的链参数和按出块顺序排好的签名者
+func newPoAParams(t *testing.T, n int) (blockchain.ChainParams, []signerKey) {
+	t.Helper()
+	signers := make([]signerKey, n)
+	for i := range signers {
+		privateKey, publicKey := encryption.GenerateKeyPair()
+		signers[i] = signerKey{address: publicKey, privateKey: privateKey}
+	}
+	sort.Slice(signers, func(i, j int) bool {
+		return signers[i].address < signers[j].address
+	})
+
+	params := blockchain.RegTestParams
+	params.Name = "poa"
+	params.Consensus = blockchain.ConsensusProofOfAuthority
+	params.Genesis.Hash = ""
+	params.Genesis.Signers = nil
+	for _, signer := range signers {
+		params.Genesis.Signers = append(params.Genesis.Signers, signer.address)
+	}
+	return params, signers
+}
+
+func newPoAChain(t *testing.T, params blockchain.ChainParams, keys ...signerKey) (*blockchain.Blockchain, *blockchain.ProofOfAuthority) {
+	t.Helper()
+	engine := blockchain.NewProofOfAuthority()
+	for _, key := range keys {
+		if err := engine.Authorize(key.address, key.privateKey); err != nil {
+			t.Fatalf("Authorize failed err: %v", err)
+		}
+	}
+	chain, err := blockchain.NewBlockchainWithEngine(params, engine)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithEngine failed err: %v", err)
+	}
+	return chain, engine
+}
+
+func TestPoA_SignersTakeTurns(t *testing.T) {
+	params, signers := newPoAParams(t, 3)
+	chain, _ := newPoAChain(t, params, signers...)
+
+	for height := 1; height <= 6; height++ {
+		if err := chain.MineTransctionFromPool("miner"); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+		block, _ := chain.GetBlock(height)
+		if want := signers[height%len(signers)].address; block.Signer() != want {
+			t.Errorf("block %d signer got %s want %s", height, block.Signer(), want)
+		}
+	}
+	if !chain.IsValidChain() {
+		t.Errorf("chain signed in turn should be valid")
+	}
+	if stats := chain.MiningStats(); stats.BlocksMined != 0 {
+		t.Errorf("proof of authority should not mine, blocks mined %d", stats.BlocksMined)
+	}
+}
+
+func TestPoA_NotInTurn(t *testing.T) {
+	params, signers := newPoAParams(t, 2)
+	//本节点只有signers[0]的密钥，第1个区块轮到signers[1]
+	chain, _ := newPoAChain(t, params, signers[0])
+
+	err := chain.MineTransctionFromPool("miner")
+	if !errors.Is(err, blockchain.ErrNotInTurn) {
+		t.Errorf("MineTransctionFromPool got err %v want %v", err, blockchain.ErrNotInTurn)
+	}
+	if chain.Height() != 0 {
+		t.Errorf("no block should be produced out of turn, height %d", chain.Height())
+	}
+}
+
+func TestPoA_RejectUnauthorizedSigner(t *testing.T) {
+	params, signers := newPoAParams(t, 1)
+	chain, engine := newPoAChain(t, params, signers...)
+
+	//另一条链的签名者不在这条链的签名者名单里
+	otherParams, otherSigners := newPoAParams(t, 1)
+	other, _ := newPoAChain(t, otherParams, otherSigners...)
+	if err := other.MineTransctionFromPool("miner"); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	block, _ := other.GetBlock(1)
+
+	if err := engine.VerifySeal(chain, &block); !errors.Is(err, blockchain.ErrUnauthorizedSigner) {
+		t.Errorf("VerifySeal got err %v want %v", err, blockchain.ErrUnauthorizedSigner)
+	}
+}
+
+func TestPoA_VoteSigners(t *testing.T) {
+	params, signers := newPoAParams(t, 3)
+	newcomerKey, newcomer := encryption.GenerateKeyPair()
+	chain, engine := newPoAChain(t, params, signers...)
+	//本节点也持有新签名者的密钥，这样它加入之后轮到它的时候也能出块
+	if err := engine.Authorize(newcomer, newcomerKey); err != nil {
+		t.Fatalf("Authorize failed err: %v", err)
+	}
+
+	signersOf := func() []string {
+		t.Helper()
+		list, err := engine.Signers(chain)
+		if err != nil {
+			t.Fatalf("Signers failed err: %v", err)
+		}
+		return list
+	}
+	contains := func(list []string, address string) bool {
+		for _, signer := range list {
+			if signer == address {
+				return true
+			}
+		}
+		return false
+	}
+
+	//3个签名者里要有2个投票同意才会加入
+	if err := engine.Propose(newcomer, true); err != nil {
+		t.Fatalf("Propose failed err: %v", err)
+	}
+	if err := chain.MineTransctionFromPool("miner"); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	if contains(signersOf(), newcomer) {
+		t.Fatalf("one vote out of three should not authorize a signer")
+	}
+	if err := chain.MineTransctionFromPool("miner"); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	if list := signersOf(); len(list) != 4 || !contains(list, newcomer) {
+		t.Fatalf("newcomer should be authorized after two votes, signers %v", list)
+	}
+	block, _ := chain.GetBlock(2)
+	if address, authorize := block.Vote(); address != newcomer || !authorize {
+		t.Errorf("block 2 vote got %s %v want %s true", address, authorize, newcomer)
+	}
+
+	//再把signers[0]踢出去，4个签名者里要有3个同意
+	engine.Discard(newcomer)
+	if err := engine.Propose(signers[0].address, false); err != nil {
+		t.Fatalf("Propose failed err: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := chain.MineTransctionFromPool("miner"); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+	}
+	if list := signersOf(); len(list) != 3 || contains(list, signers[0].address) {
+		t.Fatalf("signer should be removed after three votes, signers %v", list)
+	}
+	//生效了的提议在下次出块的时候被清理掉
+	if err := chain.MineTransctionFromPool("miner"); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	if proposals := engine.Proposals(); len(proposals) != 0 {
+		t.Errorf("proposals should be empty, got %v", proposals)
+	}
+	if !chain.IsValidChain() {
+		t.Errorf("chain with votes should be valid")
+	}
+}
@@ -0,0 +1,218 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"CcCoin-go-version/internal/encryption"
+	"errors"
+	"testing"
+)
+
+// newPoSParams 生成验证者，按stakes给每个验证者预挖并锁定权益
+func newPoSParams(t *testing.T, stakes ...float64) (blockchain.ChainParams, []signerKey) {
+	t.Helper()
+	params := blockchain.RegTestParams
+	params.Name = "pos"
+	params.Consensus = blockchain.ConsensusProofOfStake
+	params.Genesis.Hash = ""
+	params.Genesis.Allocations = map[string]float64{}
+	params.Genesis.Stakes = map[string]float64{}
+	validators := make([]signerKey, len(stakes))
+	for i, stake := range stakes {
+		privateKey, publicKey := encryption.GenerateKeyPair()
+		validators[i] = signerKey{address: publicKey, privateKey: privateKey}
+		params.Genesis.Allocations[publicKey] = stake
+		params.Genesis.Stakes[publicKey] = stake
+	}
+	return params, validators
+}
+
+func newPoSChain(t *testing.T, params blockchain.ChainParams, keys ...signerKey) (*blockchain.Blockchain, *blockchain.ProofOfStake) {
+	t.Helper()
+	engine := blockchain.NewProofOfStake()
+	for _, key := range keys {
+		if err := engine.Authorize(key.address, key.privateKey); err != nil {
+			t.Fatalf("Authorize failed err: %v", err)
+		}
+	}
+	chain, err := blockchain.NewBlockchainWithEngine(params, engine)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithEngine failed err: %v", err)
+	}
+	return chain, engine
+}
+
+func TestPoS_StakeWeightedSelection(t *testing.T) {
+	params, validators := newPoSParams(t, 1, 99)
+	chain, _ := newPoSChain(t, params, validators...)
+
+	produced := map[string]int{}
+	for height := 1; height <= 50; height++ {
+		if err := chain.MineTransctionFromPool("miner"); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+		block, _ := chain.GetBlock(height)
+		produced[block.Signer()]++
+	}
+	//权益占99%的验证者出块50次里少于35次的概率可以忽略
+	if produced[validators[1].address] < 35 {
+		t.Errorf("validator with 99%% of the stake produced %d of 50 blocks", produced[validators[1].address])
+	}
+	if !chain.IsValidChain() {
+		t.Errorf("chain produced by selected validators should be valid")
+	}
+}
+
+func TestPoS_LockStake(t *testing.T) {
+	params, validators := newPoSParams(t, 10)
+	userPrivateKey, user := encryption.GenerateKeyPair()
+	params.Genesis.Allocations[user] = 30
+	chain, engine := newPoSChain(t, params, validators...)
+
+	//创世区块里锁定权益的交易占了验证者的nonce 0
+	if nonce := chain.AccountNonce(validators[0].address); nonce != 1 {
+		t.Errorf("validator nonce got %d want 1", nonce)
+	}
+
+	tooMuch, _ := blockchain.NewTransactionWithNonce(user, userPrivateKey, blockchain.StakeAddress, 40, 0, 0)
+	if err := chain.AddTransction2Pool(tooMuch); !errors.Is(err, blockchain.ErrInsufficientBalance) {
+		t.Errorf("AddTransction2Pool got err %v want %v", err, blockchain.ErrInsufficientBalance)
+	}
+	stake, _ := blockchain.NewTransactionWithNonce(user, userPrivateKey, blockchain.StakeAddress, 20, 0, 0)
+	if err := chain.AddTransction2Pool(stake); err != nil {
+		t.Fatalf("AddTransction2Pool failed err: %v", err)
+	}
+	if err := chain.MineTransctionFromPool("miner"); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+
+	if got := chain.GetStake(user); got != 20 {
+		t.Errorf("stake got %v want 20", got)
+	}
+	if got := chain.GetBalance(user); got != 10 {
+		t.Errorf("balance got %v want 10", got)
+	}
+	validatorStakes, err := engine.Validators(chain)
+	if err != nil {
+		t.Fatalf("Validators failed err: %v", err)
+	}
+	if validatorStakes[user] != 20 || validatorStakes[validators[0].address] != 10 {
+		t.Errorf("validators got %v", validatorStakes)
+	}
+	if !chain.IsValidChain() {
+		t.Errorf("chain should be valid")
+	}
+}
+
+func TestPoS_RejectOverstakeInBlock(t *testing.T) {
+	userPrivateKey, user := encryption.GenerateKeyPair()
+	params := fundedParams(blockchain.RegTestParams)
+	params.Genesis.Allocations[user] = 30
+	chain, err := blockchain.NewBlockchainWithParams(params)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithParams failed err: %v", err)
+	}
+
+	//两笔锁定权益的交易单独看都付得起，加起来超过了余额，第二笔要按第一笔之后的余额来校验
+	first, _ := blockchain.NewTransactionWithNonce(user, userPrivateKey, blockchain.StakeAddress, 20, 0, 0)
+	second, _ := blockchain.NewTransactionWithNonce(user, userPrivateKey, blockchain.StakeAddress, 20, 0, 1)
+	err = chain.ProcessBlock(craftBlock(t, chain, "miner", first, second))
+	expectValidationError(t, err, blockchain.ErrInsufficientBalance, 1)
+	var validationErr *blockchain.ValidationError
+	if errors.As(err, &validationErr); validationErr.TxID != second.ID() {
+		t.Errorf("got tx %s want %s", validationErr.TxID, second.ID())
+	}
+	if chain.Height() != 0 || chain.GetStake(user) != 0 || chain.GetBalance(user) != 30 {
+		t.Errorf("overstaking block should not be connected, height %d stake %v balance %v", chain.Height(), chain.GetStake(user), chain.GetBalance(user))
+	}
+}
+
+func TestPoS_ValidatorsReturnsCopy(t *testing.T) {
+	params, validators := newPoSParams(t, 10, 20)
+	chain, engine := newPoSChain(t, params, validators...)
+	if err := chain.MineTransctionFromPool("miner"); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+
+	//第一次算出来的结果会被缓存，调用方改了返回的map也不能影响之后的结果
+	first, err := engine.Validators(chain)
+	if err != nil {
+		t.Fatalf("Validators failed err: %v", err)
+	}
+	first[validators[0].address] = 1000
+	delete(first, validators[1].address)
+	second, err := engine.Validators(chain)
+	if err != nil {
+		t.Fatalf("Validators failed err: %v", err)
+	}
+	if second[validators[0].address] != 10 || second[validators[1].address] != 20 {
+		t.Errorf("validators got %v after the caller changed an earlier result", second)
+	}
+}
+
+func TestPoS_NotSelected(t *testing.T) {
+	params, _ := newPoSParams(t, 10)
+	//本节点没有唯一验证者的密钥
+	chain, _ := newPoSChain(t, params)
+	if err := chain.MineTransctionFromPool("miner"); !errors.Is(err, blockchain.ErrNotInTurn) {
+		t.Errorf("MineTransctionFromPool got err %v want %v", err, blockchain.ErrNotInTurn)
+	}
+}
+
+func TestPoS_SlashDoubleSign(t *testing.T) {
+	params, validators := newPoSParams(t, 50, 50)
+	chain, engine := newPoSChain(t, params, validators...)
+	//另一个节点持有同样的密钥，在同一个高度签了另一个区块
+	other, _ := newPoSChain(t, params, validators...)
+
+	if err := chain.MineTransctionFromPool("miner"); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	if err := other.MineTransctionFromPool("another miner"); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	first, _ := chain.GetBlock(1)
+	second, _ := other.GetBlock(1)
+	if first.Signer() != second.Signer() || first.Hash() == second.Hash() {
+		t.Fatalf("both nodes should sign different blocks with the same validator")
+	}
+	offender := first.Signer()
+
+	if err := engine.ReportDoubleSign(first, first); !errors.Is(err, blockchain.ErrInvalidEvidence) {
+		t.Errorf("ReportDoubleSign got err %v want %v", err, blockchain.ErrInvalidEvidence)
+	}
+	//校验过第一个区块之后，再校验同一个高度上的第二个区块就能发现双签
+	if !chain.IsValidChain() {
+		t.Fatalf("chain should be valid")
+	}
+	genesisOnly, _ := newPoSChain(t, params)
+	if err := engine.VerifySeal(genesisOnly, &second); err != nil {
+		t.Fatalf("VerifySeal failed err: %v", err)
+	}
+	if err := chain.MineTransctionFromPool("miner"); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	block, _ := chain.GetBlock(2)
+	if len(block.Slashings()) != 1 {
+		t.Fatalf("block 2 should carry the double sign evidence")
+	}
+	if got := chain.GetStake(offender); got != 0 {
+		t.Errorf("offender stake got %v want 0", got)
+	}
+
+	//罚没之后只剩另一个验证者出块
+	for i := 0; i < 3; i++ {
+		if err := chain.MineTransctionFromPool("miner"); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+		block, _ := chain.GetBlock(chain.Height())
+		if block.Signer() == offender {
+			t.Errorf("slashed validator should not be selected")
+		}
+		if len(block.Slashings()) != 0 {
+			t.Errorf("evidence should be included only once")
+		}
+	}
+	if !chain.IsValidChain() {
+		t.Errorf("chain with slashing should be valid")
+	}
+}
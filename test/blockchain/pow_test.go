@@ -0,0 +1,61 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"errors"
+	"testing"
+)
+
+func newPowChain(t *testing.T, algorithm string, difficulty int) *blockchain.Blockchain {
+	t.Helper()
+	params := blockchain.RegTestParams
+	params.PowAlgorithm = algorithm
+	params.Genesis.Difficulty = difficulty
+	chain, err := blockchain.NewBlockchainWithParams(fundedParams(params))
+	if err != nil {
+		t.Fatalf("NewBlockchainWithParams failed err: %v", err)
+	}
+	return chain
+}
+
+func TestProofOfWork_Scrypt(t *testing.T) {
+	chain := newPowChain(t, blockchain.PowAlgorithmScrypt, 1)
+	for i := 0; i < 2; i++ {
+		if err := chain.MineTransctionFromPool("miner"); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+	}
+	if !chain.IsValidChain() {
+		t.Fatalf("scrypt chain should be valid")
+	}
+	//创世区块承诺了工作量证明的算法，换算法就是另一条链
+	if chain.GenesisHash() == newPowChain(t, blockchain.PowAlgorithmSHA256, 1).GenesisHash() {
+		t.Errorf("genesis hash should commit to the proof of work algorithm")
+	}
+}
+
+func TestProofOfWork_DispatchOnChainAlgorithm(t *testing.T) {
+	sha256Chain := newPowChain(t, blockchain.PowAlgorithmSHA256, 4)
+	if err := sha256Chain.MineTransctionFromPool("miner"); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	block, _ := sha256Chain.GetBlock(1)
+
+	pow := blockchain.NewProofOfWork()
+	if err := pow.VerifySeal(sha256Chain, &block); err != nil {
+		t.Errorf("VerifySeal on sha256 chain failed err: %v", err)
+	}
+	//同样的区块放到scrypt的链上，工作量hash就不满足难度了
+	scryptChain := newPowChain(t, blockchain.PowAlgorithmScrypt, 4)
+	if err := pow.VerifySeal(scryptChain, &block); !errors.Is(err, blockchain.ErrInvalidProofOfWork) {
+		t.Errorf("VerifySeal on scrypt chain got err %v want %v", err, blockchain.ErrInvalidProofOfWork)
+	}
+}
+
+func TestProofOfWork_UnknownAlgorithm(t *testing.T) {
+	params := blockchain.RegTestParams
+	params.PowAlgorithm = "md5"
+	if _, err := blockchain.NewBlockchainWithParams(params); err == nil {
+		t.Errorf("unknown proof of work algorithm should be rejected")
+	}
+}
@@ -0,0 +1,111 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"CcCoin-go-version/internal/encryption"
+	"errors"
+	"testing"
+)
+
+func TestPrune_KeepsHeadersAndState(t *testing.T) {
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	chain := newFundedChain(t, 1)
+	hashes := []string{chain.GenesisHash()}
+	balances := []float64{0}
+	for height := 1; height <= 10; height++ {
+		if err := chain.AddTransction2Pool(newSignedTx(t, 10, 0.01)); err != nil {
+			t.Fatalf("Failed to add transaction to pool: %v", err)
+		}
+		if err := chain.MineTransctionFromPool(minerPublicKey); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+		block, _ := chain.GetBlock(height)
+		hashes = append(hashes, block.Hash())
+		balances = append(balances, chain.GetBalance(minerPublicKey))
+	}
+
+	if err := chain.SetPruneDepth(3); err != nil {
+		t.Fatalf("SetPruneDepth failed err: %v", err)
+	}
+	if chain.PrunedHeight() != 7 {
+		t.Fatalf("PrunedHeight got %d want 7", chain.PrunedHeight())
+	}
+	for height := 0; height <= 10; height++ {
+		block, _ := chain.GetBlock(height)
+		pruned := height >= 1 && height <= 7
+		if block.Pruned() != pruned || (pruned && block.Transactions() != nil) || (height > 7 && len(block.Transactions()) == 0) {
+			t.Errorf("block %d pruned %v with %d transactions, want pruned %v", height, block.Pruned(), len(block.Transactions()), pruned)
+		}
+		if block.Hash() != hashes[height] {
+			t.Errorf("block %d hash changed after pruning", height)
+		}
+	}
+	if err := chain.ValidateChain(); err != nil {
+		t.Errorf("ValidateChain got err %v want nil", err)
+	}
+	if chain.GetBalance(minerPublicKey) != balances[10] {
+		t.Errorf("balance got %v want %v", chain.GetBalance(minerPublicKey), balances[10])
+	}
+
+	//断开没有被裁剪的区块时，账本从裁剪点重放
+	for height := 10; height > 7; height-- {
+		if _, err := chain.DisconnectTip(); err != nil {
+			t.Fatalf("DisconnectTip at %d failed err: %v", height, err)
+		}
+		if chain.GetBalance(minerPublicKey) != balances[height-1] {
+			t.Errorf("balance at %d got %v want %v", height-1, chain.GetBalance(minerPublicKey), balances[height-1])
+		}
+	}
+	if _, err := chain.DisconnectTip(); !errors.Is(err, blockchain.ErrPruned) {
+		t.Fatalf("DisconnectTip got err %v want %v", err, blockchain.ErrPruned)
+	}
+
+	//链继续增长时，旧区块会被成批地裁剪
+	for i := 0; i < 80; i++ {
+		if err := chain.MineTransctionFromPool(minerPublicKey); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+	}
+	if chain.PrunedHeight() <= 7 || chain.Height()-chain.PrunedHeight() < 3 {
+		t.Errorf("PrunedHeight got %d at height %d", chain.PrunedHeight(), chain.Height())
+	}
+	if err := chain.ValidateChain(); err != nil {
+		t.Errorf("ValidateChain got err %v want nil", err)
+	}
+}
+
+func TestPrune_RejectPrunedBlock(t *testing.T) {
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	source := blockchain.NewBlockchain(1)
+	target := blockchain.NewBlockchain(1)
+	for i := 0; i < 2; i++ {
+		if err := source.MineTransctionFromPool(minerPublicKey); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+	}
+	if err := source.SetPruneDepth(1); err != nil {
+		t.Fatalf("SetPruneDepth failed err: %v", err)
+	}
+
+	//只有区块头的区块没法校验交易，不能拿来同步
+	info := relayBlock(t, source, 1)
+	if !info.Pruned || info.BodyRoot == "" || len(info.Transactions) != 0 {
+		t.Fatalf("relayed block should be pruned, got %+v", info)
+	}
+	err := target.ProcessBlock(blockchain.NewBlockFromInfo(info))
+	expectValidationError(t, err, blockchain.ErrPruned, 1)
+	if target.Height() != 0 {
+		t.Errorf("pruned block should not be connected, height %d", target.Height())
+	}
+}
+
+func TestPrune_UnsupportedForPoS(t *testing.T) {
+	params, validators := newPoSParams(t, 10)
+	chain, _ := newPoSChain(t, params, validators...)
+	if err := chain.SetPruneDepth(10); !errors.Is(err, blockchain.ErrPruneUnsupported) {
+		t.Errorf("SetPruneDepth got err %v want %v", err, blockchain.ErrPruneUnsupported)
+	}
+	if err := chain.SetPruneDepth(0); err != nil {
+		t.Errorf("SetPruneDepth(0) got err %v want nil", err)
+	}
+}
@@ -0,0 +1,132 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"CcCoin-go-version/internal/encryption"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// newForgedTx 用别人的私钥签名的交易，直接塞进交易池(绕过AddTransction2Pool的签名校验)
+func newForgedTx(t *testing.T) blockchain.Transaction {
+	t.Helper()
+	_, senderPublicKey := fundedKeyPair()
+	otherPrivateKey, _ := encryption.GenerateKeyPair()
+	_, receiverPublicKey := encryption.GenerateKeyPair()
+	tx, err := blockchain.NewTransactionWithFee(senderPublicKey, otherPrivateKey, receiverPublicKey, 10, 1)
+	if err != nil {
+		t.Fatalf("NewTransactionWithFee failed err: %v", err)
+	}
+	return tx
+}
+
+// newLongChain 挖出blocks个区块，每个区块带txsPerBlock笔交易，第j笔交易由fundedKeys[j]发出
+func newLongChain(tb testing.TB, blocks, txsPerBlock int) *blockchain.Blockchain {
+	tb.Helper()
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	_, receiverPublicKey := encryption.GenerateKeyPair()
+	chain := newFundedChain(tb, 1)
+	for i := 0; i < blocks; i++ {
+		for _, sender := range fundedKeys[:txsPerBlock] {
+			tx, err := blockchain.NewTransactionWithNonce(sender.address, sender.privateKey, receiverPublicKey, 10, 0.01, uint64(i))
+			if err != nil {
+				tb.Fatalf("NewTransactionWithNonce failed err: %v", err)
+			}
+			if err := chain.AddTransction2Pool(tx); err != nil {
+				tb.Fatalf("Failed to add transaction to pool: %v", err)
+			}
+		}
+		if err := chain.MineTransctionFromPool(minerPublicKey); err != nil {
+			tb.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+	}
+	return chain
+}
+
+func TestSignatureCache_ValidateChain(t *testing.T) {
+	chain := newLongChain(t, 20, 3)
+	for _, cacheSize := range []int{0, 1, blockchain.DefaultSignatureCacheSize} {
+		for _, workers := range []int{1, 4} {
+			t.Run(fmt.Sprintf("cache %d workers %d", cacheSize, workers), func(t *testing.T) {
+				chain.SetSignatureCacheSize(cacheSize)
+				chain.SetValidationWorkers(workers)
+				//第二次校验会用到第一次缓存下来的结果
+				for i := 0; i < 2; i++ {
+					if err := chain.ValidateChain(); err != nil {
+						t.Fatalf("ValidateChain got err %v want nil", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestSignatureCache_RejectForgedTransaction(t *testing.T) {
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	chain := newPowChain(t, blockchain.PowAlgorithmSHA256, 1)
+	chain.SetValidationWorkers(4)
+	for i := 0; i < 5; i++ {
+		if err := chain.AddTransction2Pool(newSignedTx(t, 10, 0.01)); err != nil {
+			t.Fatalf("Failed to add transaction to pool: %v", err)
+		}
+	}
+	forged := newForgedTx(t)
+	if err := chain.AddTransction2Pool(forged); !errors.Is(err, blockchain.ErrInvalidTxSignature) {
+		t.Fatalf("AddTransction2Pool got err %v want %v", err, blockchain.ErrInvalidTxSignature)
+	}
+	if err := chain.Mempool().Add(forged); err != nil {
+		t.Fatalf("Add failed err: %v", err)
+	}
+
+	//打包之前会并行地校验所有交易
+	err := chain.MineTransctionFromPool(minerPublicKey)
+	var validationErr *blockchain.ValidationError
+	if !errors.Is(err, blockchain.ErrInvalidTxSignature) || !errors.As(err, &validationErr) || validationErr.TxID != forged.ID() {
+		t.Fatalf("MineTransctionFromPool got err %v want %v for %s", err, blockchain.ErrInvalidTxSignature, forged.ID())
+	}
+
+	//外部矿工把伪造的交易挖进了区块，接收区块时也能发现
+	template, err := chain.NewBlockTemplate(minerPublicKey)
+	if err != nil {
+		t.Fatalf("NewBlockTemplate failed err: %v", err)
+	}
+	_, err = chain.SubmitBlock(template.TemplateID, solveTemplate(t, template, true))
+	expectValidationError(t, err, blockchain.ErrInvalidTxSignature, 1)
+	if errors.As(err, &validationErr); validationErr.TxID != forged.ID() {
+		t.Errorf("got tx %s want %s", validationErr.TxID, forged.ID())
+	}
+	if chain.Height() != 0 {
+		t.Errorf("block with forged transaction should not be connected, height %d", chain.Height())
+	}
+}
+
+// BenchmarkValidateChain 校验2000个区块、4000笔交易的链：逐个验签名、并行验签名，以及签名都已经在缓存里
+// go test ./test/blockchain -run '^$' -bench ValidateChain
+func BenchmarkValidateChain(b *testing.B) {
+	chain := newLongChain(b, 2000, 2)
+	benchmarks := []struct {
+		name      string
+		workers   int
+		cacheSize int
+	}{
+		{name: "Serial", workers: 1, cacheSize: 0},
+		{name: "Parallel", workers: 0, cacheSize: 0},
+		{name: "Cached", workers: 0, cacheSize: blockchain.DefaultSignatureCacheSize},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			chain.SetValidationWorkers(bm.workers)
+			chain.SetSignatureCacheSize(bm.cacheSize)
+			if err := chain.ValidateChain(); err != nil {
+				b.Fatalf("ValidateChain failed err: %v", err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := chain.ValidateChain(); err != nil {
+					b.Fatalf("ValidateChain failed err: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"CcCoin-go-version/internal/encryption"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// newSnapshotSource 回归测试网每10个区块承诺一次账本状态，挖出blocks个带交易的区块
+func newSnapshotSource(t *testing.T, minerPublicKey string, blocks int) *blockchain.Blockchain {
+	t.Helper()
+	chain := newPowChain(t, blockchain.PowAlgorithmSHA256, 1)
+	for i := 0; i < blocks; i++ {
+		if err := chain.AddTransction2Pool(newSignedTx(t, 10, 0.01)); err != nil {
+			t.Fatalf("Failed to add transaction to pool: %v", err)
+		}
+		if err := chain.MineTransctionFromPool(minerPublicKey); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+	}
+	return chain
+}
+
+// exportSnapshot 像从网络上收到快照一样，编码成JSON再还原
+func exportSnapshot(t *testing.T, chain *blockchain.Blockchain, height int) blockchain.StateSnapshot {
+	t.Helper()
+	snapshot, err := chain.ExportSnapshot(height)
+	if err != nil {
+		t.Fatalf("ExportSnapshot failed err: %v", err)
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatalf("Marshal failed err: %v", err)
+	}
+	var decoded blockchain.StateSnapshot
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed err: %v", err)
+	}
+	return decoded
+}
+
+// trustedCheckpoint 从可信的来源(这里是source)拿到的快照高度的区块hash
+func trustedCheckpoint(chain *blockchain.Blockchain, height int) blockchain.Checkpoint {
+	block, _ := chain.GetBlock(height)
+	return blockchain.Checkpoint{Height: height, Hash: block.Hash()}
+}
+
+func bootstrap(snapshot blockchain.StateSnapshot, checkpoints ...blockchain.Checkpoint) (*blockchain.Blockchain, error) {
+	params := fundedParams(blockchain.RegTestParams)
+	params.Checkpoints = checkpoints
+	engine, err := blockchain.NewConsensusEngine(params)
+	if err != nil {
+		return nil, err
+	}
+	return blockchain.NewBlockchainFromSnapshot(params, engine, snapshot)
+}
+
+func TestSnapshot_Bootstrap(t *testing.T) {
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	source := newSnapshotSource(t, minerPublicKey, 25)
+	for height := 1; height <= 25; height++ {
+		block, _ := source.GetBlock(height)
+		if committed := block.Info(height).StateRoot != ""; committed != (height%10 == 0) {
+			t.Errorf("block %d state commitment %v", height, committed)
+		}
+	}
+
+	//不指定高度的话导出最近一个有状态承诺的高度
+	snapshot := exportSnapshot(t, source, 0)
+	if snapshot.Height != 20 || len(snapshot.Headers) != 20 || snapshot.Balances[minerPublicKey] == 0 {
+		t.Fatalf("got snapshot at height %d with %d headers", snapshot.Height, len(snapshot.Headers))
+	}
+	target, err := bootstrap(snapshot, trustedCheckpoint(source, 20))
+	if err != nil {
+		t.Fatalf("NewBlockchainFromSnapshot failed err: %v", err)
+	}
+	if target.Height() != 20 || target.PrunedHeight() != 20 {
+		t.Fatalf("bootstrapped chain height %d pruned height %d want 20", target.Height(), target.PrunedHeight())
+	}
+
+	//快照之后的区块从快照的账本状态开始校验
+	for height := 21; height <= 25; height++ {
+		if err := target.ProcessBlock(blockchain.NewBlockFromInfo(relayBlock(t, source, height))); err != nil {
+			t.Fatalf("ProcessBlock(%d) failed err: %v", height, err)
+		}
+	}
+	sourceTip, _ := source.GetBlock(25)
+	targetTip, _ := target.GetBlock(25)
+	if targetTip.Hash() != sourceTip.Hash() {
+		t.Fatalf("target should follow source")
+	}
+	if target.GetBalance(minerPublicKey) != source.GetBalance(minerPublicKey) {
+		t.Errorf("miner balance got %v want %v", target.GetBalance(minerPublicKey), source.GetBalance(minerPublicKey))
+	}
+	if err := target.ValidateChain(); err != nil {
+		t.Errorf("ValidateChain got err %v want nil", err)
+	}
+
+	//接着在快照启动的节点上挖矿，承诺的账本状态和完整重放出来的一致
+	for height := 26; height <= 30; height++ {
+		if err := target.MineTransctionFromPool(minerPublicKey); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+		if err := source.ProcessBlock(blockchain.NewBlockFromInfo(relayBlock(t, target, height))); err != nil {
+			t.Fatalf("source ProcessBlock(%d) failed err: %v", height, err)
+		}
+	}
+	if _, err := target.ExportSnapshot(30); err != nil {
+		t.Errorf("ExportSnapshot(30) on bootstrapped chain failed err: %v", err)
+	}
+	if err := source.ValidateChain(); err != nil {
+		t.Errorf("source ValidateChain got err %v want nil", err)
+	}
+}
+
+func TestSnapshot_RequireCheckpoint(t *testing.T) {
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	source := newSnapshotSource(t, minerPublicKey, 20)
+	snapshot := exportSnapshot(t, source, 20)
+
+	//区块头和状态承诺都对得上也不行，提供快照的节点完全可以自己伪造一条链
+	if _, err := bootstrap(snapshot); !errors.Is(err, blockchain.ErrUntrustedSnapshot) {
+		t.Errorf("NewBlockchainFromSnapshot got err %v want %v", err, blockchain.ErrUntrustedSnapshot)
+	}
+	if _, err := bootstrap(snapshot, trustedCheckpoint(source, 10)); !errors.Is(err, blockchain.ErrUntrustedSnapshot) {
+		t.Errorf("NewBlockchainFromSnapshot got err %v want %v", err, blockchain.ErrUntrustedSnapshot)
+	}
+
+	//另一条链上同一高度的快照对不上checkpoint
+	other := newSnapshotSource(t, minerPublicKey, 20)
+	_, err := bootstrap(exportSnapshot(t, other, 20), trustedCheckpoint(source, 20))
+	expectValidationError(t, err, blockchain.ErrCheckpointMismatch, 20)
+}
+
+func TestSnapshot_RejectTampered(t *testing.T) {
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	source := newSnapshotSource(t, minerPublicKey, 20)
+
+	testCases := []struct {
+		name       string
+		tamper     func(snapshot *blockchain.StateSnapshot)
+		want       error
+		blockIndex int //-1表示不是某个区块头的问题
+	}{
+		{name: "Balance", tamper: func(s *blockchain.StateSnapshot) { s.Balances[minerPublicKey]++ }, want: blockchain.ErrSnapshotMismatch, blockIndex: -1},
+		{name: "Nonce", tamper: func(s *blockchain.StateSnapshot) {
+			for address := range s.Nonces {
+				s.Nonces[address] = 0
+				break
+			}
+		}, want: blockchain.ErrSnapshotMismatch, blockIndex: -1},
+		{name: "Header", tamper: func(s *blockchain.StateSnapshot) { s.Headers[4].Timestamp++ }, want: blockchain.ErrTamperedBlock, blockIndex: 5},
+		{name: "State Commitment", tamper: func(s *blockchain.StateSnapshot) { s.Headers[9].StateRoot = s.Headers[19].StateRoot }, want: blockchain.ErrTamperedBlock, blockIndex: 10},
+		{name: "Missing Header", tamper: func(s *blockchain.StateSnapshot) { s.Headers = s.Headers[1:] }, want: blockchain.ErrSnapshotMismatch, blockIndex: -1},
+		{name: "No Commitment", tamper: func(s *blockchain.StateSnapshot) {
+			s.Height = 15
+			s.Headers = s.Headers[:15]
+		}, want: blockchain.ErrNoStateCommitment, blockIndex: -1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			snapshot := exportSnapshot(t, source, 20)
+			tc.tamper(&snapshot)
+			_, err := bootstrap(snapshot, trustedCheckpoint(source, snapshot.Height))
+			if tc.blockIndex >= 0 {
+				expectValidationError(t, err, tc.want, tc.blockIndex)
+				return
+			}
+			if !errors.Is(err, tc.want) {
+				t.Errorf("NewBlockchainFromSnapshot got err %v want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestSnapshot_Export(t *testing.T) {
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	source := newSnapshotSource(t, minerPublicKey, 9)
+	if _, err := source.ExportSnapshot(0); !errors.Is(err, blockchain.ErrNoStateCommitment) {
+		t.Errorf("ExportSnapshot before the first commitment got err %v want %v", err, blockchain.ErrNoStateCommitment)
+	}
+
+	//外部矿工挖的区块也要带上状态承诺
+	template, err := source.NewBlockTemplate(minerPublicKey)
+	if err != nil {
+		t.Fatalf("NewBlockTemplate failed err: %v", err)
+	}
+	if template.Height != 10 || template.StateRoot == "" {
+		t.Fatalf("template at height %d should commit to the ledger state", template.Height)
+	}
+	if _, err := source.SubmitBlock(template.TemplateID, solveTemplate(t, template, true)); err != nil {
+		t.Fatalf("SubmitBlock failed err: %v", err)
+	}
+	if snapshot := exportSnapshot(t, source, 10); snapshot.Root != template.StateRoot {
+		t.Errorf("snapshot root got %s want %s", snapshot.Root, template.StateRoot)
+	}
+
+	for _, height := range []int{5, 11} {
+		if _, err := source.ExportSnapshot(height); !errors.Is(err, blockchain.ErrNoStateCommitment) {
+			t.Errorf("ExportSnapshot(%d) got err %v want %v", height, err, blockchain.ErrNoStateCommitment)
+		}
+	}
+
+	//裁剪掉交易之后算不出更早的账本状态
+	for i := 0; i < 2; i++ {
+		if err := source.MineTransctionFromPool(minerPublicKey); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+	}
+	if err := source.SetPruneDepth(1); err != nil {
+		t.Fatalf("SetPruneDepth failed err: %v", err)
+	}
+	if _, err := source.ExportSnapshot(10); !errors.Is(err, blockchain.ErrPruned) {
+		t.Errorf("ExportSnapshot on pruned chain got err %v want %v", err, blockchain.ErrPruned)
+	}
+}
@@ -0,0 +1,298 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"CcCoin-go-version/internal/encryption"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestValidation_TransactionErrors(t *testing.T) {
+	senderPrivateKey, senderPublicKey := encryption.GenerateKeyPair()
+	otherPrivateKey, _ := encryption.GenerateKeyPair()
+	_, receiverPublicKey := encryption.GenerateKeyPair()
+	myChain := blockchain.NewBlockchain(1)
+
+	//用别人的私钥签名
+	forged, _ := blockchain.NewTransactionWithNonce(senderPublicKey, otherPrivateKey, receiverPublicKey, 10, 0, 0)
+	negative, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, -10, 0, 0)
+	notANumber, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, math.NaN(), 0, 0)
+	infiniteFee, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, 10, math.Inf(1), 0)
+
+	testCases := []struct {
+		name string
+		tx   blockchain.Transaction
+		want error
+	}{
+		{name: "Bad Signature", tx: forged, want: blockchain.ErrInvalidTxSignature},
+		{name: "Negative Amount", tx: negative, want: blockchain.ErrInvalidAmount},
+		{name: "NaN Amount", tx: notANumber, want: blockchain.ErrInvalidAmount},
+		{name: "Infinite Fee", tx: infiniteFee, want: blockchain.ErrInvalidAmount},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := myChain.AddTransction2Pool(tc.tx)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("AddTransction2Pool got err %v want %v", err, tc.want)
+			}
+			var validationErr *blockchain.ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("AddTransction2Pool err %v should be a *ValidationError", err)
+			}
+			if validationErr.BlockIndex != -1 || validationErr.TxID != tc.tx.ID() {
+				t.Errorf("got block index %d tx %s want -1 and %s", validationErr.BlockIndex, validationErr.TxID, tc.tx.ID())
+			}
+			if !strings.Contains(err.Error(), tc.tx.ID()) {
+				t.Errorf("error message %q should name the transaction", err.Error())
+			}
+		})
+	}
+
+	if err := forged.Validate(); !errors.Is(err, blockchain.ErrInvalidTxSignature) || forged.IsValid() {
+		t.Errorf("Validate got err %v want %v", err, blockchain.ErrInvalidTxSignature)
+	}
+}
+
+func TestValidation_ConfirmedSpendCarriesTxID(t *testing.T) {
+	senderPrivateKey, senderPublicKey := fundedKeyPair()
+	_, receiverPublicKey := encryption.GenerateKeyPair()
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	myChain := newFundedChain(t, 1)
+
+	tx, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, 10, 0, 0)
+	if err := myChain.AddTransction2Pool(tx); err != nil {
+		t.Fatalf("Failed to add transaction to pool: %v", err)
+	}
+	if err := myChain.MineTransctionFromPool(minerPublicKey); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	again, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, minerPublicKey, 10, 1, 0)
+	err := myChain.AddTransction2Pool(again)
+	var validationErr *blockchain.ValidationError
+	if !errors.Is(err, blockchain.ErrDoubleSpend) || !errors.As(err, &validationErr) || validationErr.TxID != again.ID() {
+		t.Errorf("AddTransction2Pool got err %v want %v for tx %s", err, blockchain.ErrDoubleSpend, again.ID())
+	}
+}
+
+func TestValidation_ValidateChain(t *testing.T) {
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	myChain := newFundedChain(t, 1)
+	for i := 0; i < 2; i++ {
+		if err := myChain.AddTransction2Pool(newSignedTx(t, 10, 0.01)); err != nil {
+			t.Fatalf("Failed to add transaction to pool: %v", err)
+		}
+		if err := myChain.MineTransctionFromPool(minerPublicKey); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+	}
+	if err := myChain.ValidateChain(); err != nil {
+		t.Fatalf("ValidateChain got err %v want nil", err)
+	}
+
+	//和checkpoint冲突的区块，错误里带着它的高度
+	block, _ := myChain.GetBlock(2)
+	myChain.SetCheckpoints([]blockchain.Checkpoint{{Height: 1, Hash: block.Hash()}})
+	err := myChain.ValidateChain()
+	var validationErr *blockchain.ValidationError
+	if !errors.Is(err, blockchain.ErrCheckpointMismatch) || !errors.As(err, &validationErr) {
+		t.Fatalf("ValidateChain got err %v want %v", err, blockchain.ErrCheckpointMismatch)
+	}
+	if validationErr.BlockIndex != 1 || validationErr.TxID != "" {
+		t.Errorf("got block index %d tx %q want block 1 and no tx", validationErr.BlockIndex, validationErr.TxID)
+	}
+	if !strings.HasPrefix(err.Error(), "block 1: ") {
+		t.Errorf("error message %q should name the block", err.Error())
+	}
+	if myChain.IsValidChain() {
+		t.Errorf("IsValidChain should agree with ValidateChain")
+	}
+}
+
+func TestValidation_OverspendCarriesBlockAndTx(t *testing.T) {
+	senderPrivateKey, sender := encryption.GenerateKeyPair()
+	_, receiver := encryption.GenerateKeyPair()
+	params := fundedParams(blockchain.RegTestParams)
+	params.Genesis.Allocations[sender] = 100
+	chain, err := blockchain.NewBlockchainWithParams(params)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithParams failed err: %v", err)
+	}
+
+	//普通的转账：金额超过余额，或者金额付得起、加上手续费就超过了余额
+	testCases := []struct {
+		name   string
+		amount float64
+		fee    float64
+	}{
+		{name: "Amount", amount: 101, fee: 0},
+		{name: "Amount Plus Fee", amount: 100, fee: 0.01},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tx, _ := blockchain.NewTransactionWithNonce(sender, senderPrivateKey, receiver, tc.amount, tc.fee, 0)
+			err := chain.ProcessBlock(craftBlock(t, chain, "miner", tx))
+			var validationErr *blockchain.ValidationError
+			if !errors.Is(err, blockchain.ErrOverspend) || !errors.As(err, &validationErr) {
+				t.Fatalf("ProcessBlock got err %v want %v", err, blockchain.ErrOverspend)
+			}
+			if validationErr.BlockIndex != 1 || validationErr.TxID != tx.ID() {
+				t.Errorf("got block index %d tx %s want block 1 and %s", validationErr.BlockIndex, validationErr.TxID, tx.ID())
+			}
+			if !strings.HasPrefix(err.Error(), "block 1: ") || !strings.Contains(err.Error(), tx.ID()) {
+				t.Errorf("error message %q should name the block and the transaction", err.Error())
+			}
+		})
+	}
+	if chain.Height() != 0 || chain.GetBalance(sender) != 100 {
+		t.Errorf("overspending blocks should not be connected, height %d balance %v", chain.Height(), chain.GetBalance(sender))
+	}
+}
+
+func TestValidation_RejectNonFiniteAmountInBlock(t *testing.T) {
+	senderPrivateKey, sender := fundedKeyPair()
+	_, receiver := encryption.GenerateKeyPair()
+	chain := newPowChain(t, blockchain.PowAlgorithmSHA256, 1)
+
+	//NaN比较的结果总是false，混进区块的话发送者和接收者的余额都会变成NaN
+	testCases := []struct {
+		name   string
+		amount float64
+		fee    float64
+	}{
+		{name: "NaN Amount", amount: math.NaN(), fee: 0},
+		{name: "NaN Fee", amount: 10, fee: math.NaN()},
+		{name: "Infinite Amount", amount: math.Inf(1), fee: 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tx, _ := blockchain.NewTransactionWithNonce(sender, senderPrivateKey, receiver, tc.amount, tc.fee, 0)
+			err := chain.ProcessBlock(craftBlock(t, chain, "miner", tx))
+			expectValidationError(t, err, blockchain.ErrInvalidAmount, 1)
+		})
+	}
+	if chain.Height() != 0 || math.IsNaN(chain.GetBalance(receiver)) {
+		t.Errorf("block with non-finite amounts should not be connected, height %d", chain.Height())
+	}
+}
+
+func TestValidation_SignatureCoversEveryField(t *testing.T) {
+	senderPrivateKey, senderPublicKey := encryption.GenerateKeyPair()
+	_, receiverPublicKey := encryption.GenerateKeyPair()
+
+	//把一个字段的数字挪到相邻的字段里，拼起来的字符串和原来的一样，签名也不能通过
+	testCases := []struct {
+		name     string
+		amount   float64
+		fee      float64
+		nonce    uint64
+		tampered func(info *blockchain.TransactionInfo)
+	}{
+		{name: "Amount And Fee", amount: 1, fee: 10, nonce: 0, tampered: func(info *blockchain.TransactionInfo) {
+			info.Amount, info.Fee = 11, 0
+		}},
+		{name: "Fee And Nonce", amount: 5, fee: 1, nonce: 10, tampered: func(info *blockchain.TransactionInfo) {
+			info.Fee, info.Nonce = 11, 0
+		}},
+		{name: "Nonce And Extra Nonce", amount: 5, fee: 1, nonce: 11, tampered: func(info *blockchain.TransactionInfo) {
+			info.Nonce, info.ExtraNonce = 1, 10
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tx, err := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, tc.amount, tc.fee, tc.nonce)
+			if err != nil {
+				t.Fatalf("NewTransactionWithNonce failed err: %v", err)
+			}
+			block := blockchain.NewBlockFromInfo(blockchain.BlockInfo{Transactions: []blockchain.TransactionInfo{tx.Info()}})
+			original := block.Transactions()[0]
+			if err := original.Validate(); err != nil {
+				t.Fatalf("Validate original got err %v want nil", err)
+			}
+
+			info := tx.Info()
+			tc.tampered(&info)
+			block = blockchain.NewBlockFromInfo(blockchain.BlockInfo{Transactions: []blockchain.TransactionInfo{info}})
+			tampered := block.Transactions()[0]
+			if err := tampered.Validate(); !errors.Is(err, blockchain.ErrInvalidTxSignature) {
+				t.Errorf("Validate tampered got err %v want %v", err, blockchain.ErrInvalidTxSignature)
+			}
+			if tampered.ID() == tx.ID() {
+				t.Errorf("tampered transaction should not keep the id %s", tx.ID())
+			}
+		})
+	}
+}
+
+func TestValidation_InsufficientBalance(t *testing.T) {
+	unfundedPrivateKey, unfundedPublicKey := encryption.GenerateKeyPair()
+	senderPrivateKey, senderPublicKey := fundedKeyPair()
+	_, receiverPublicKey := encryption.GenerateKeyPair()
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	chain := newPowChain(t, blockchain.PowAlgorithmSHA256, 1)
+
+	unfunded, _ := blockchain.NewTransactionWithNonce(unfundedPublicKey, unfundedPrivateKey, receiverPublicKey, 1e9, 0.01, 0)
+	//预挖的1e6够付第一笔，付完之后就不够付第二笔了
+	first, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, 6e5, 0.01, 0)
+	second, _ := blockchain.NewTransactionWithNonce(senderPublicKey, senderPrivateKey, receiverPublicKey, 6e5, 0.01, 1)
+
+	//进池的时候
+	if err := chain.AddTransction2Pool(first); err != nil {
+		t.Fatalf("Failed to add transaction to pool: %v", err)
+	}
+	for _, tx := range []blockchain.Transaction{unfunded, second} {
+		err := chain.AddTransction2Pool(tx)
+		var validationErr *blockchain.ValidationError
+		if !errors.Is(err, blockchain.ErrInsufficientBalance) || !errors.Is(err, blockchain.ErrOverspend) || !errors.As(err, &validationErr) || validationErr.TxID != tx.ID() {
+			t.Errorf("AddTransction2Pool got err %v want %v for tx %s", err, blockchain.ErrInsufficientBalance, tx.ID())
+		}
+	}
+
+	//绕过进池的检查直接塞进交易池，打包的时候也会被跳过
+	for _, tx := range []blockchain.Transaction{unfunded, second} {
+		if err := chain.Mempool().Add(tx); err != nil {
+			t.Fatalf("Add failed err: %v", err)
+		}
+	}
+	if err := chain.MineTransctionFromPool(minerPublicKey); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	block, _ := chain.GetBlock(1)
+	if mined := block.Transactions(); len(mined) != 2 || mined[0].ID() != first.ID() {
+		t.Fatalf("block should only pack the funded transaction and the miner reward, got %d transactions", len(mined))
+	}
+
+	//外部矿工硬塞进区块，接收区块的时候按区块里前面的交易之后的余额校验
+	otherPrivateKey, otherPublicKey := fundedKeyPair()
+	spend, _ := blockchain.NewTransactionWithNonce(otherPublicKey, otherPrivateKey, receiverPublicKey, 6e5, 0.01, 0)
+	overspend, _ := blockchain.NewTransactionWithNonce(otherPublicKey, otherPrivateKey, receiverPublicKey, 6e5, 0.01, 1)
+	testCases := []struct {
+		name string
+		txs  []blockchain.Transaction
+		bad  blockchain.Transaction
+	}{
+		{name: "Unfunded Sender", txs: []blockchain.Transaction{unfunded}, bad: unfunded},
+		{name: "Running Balance", txs: []blockchain.Transaction{spend, overspend}, bad: overspend},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := chain.ProcessBlock(craftBlock(t, chain, minerPublicKey, tc.txs...))
+			expectValidationError(t, err, blockchain.ErrInsufficientBalance, 2)
+			var validationErr *blockchain.ValidationError
+			if errors.As(err, &validationErr); !errors.Is(err, blockchain.ErrOverspend) || validationErr.TxID != tc.bad.ID() {
+				t.Errorf("got err %v want %v for tx %s", err, blockchain.ErrOverspend, tc.bad.ID())
+			}
+			if chain.Height() != 1 {
+				t.Errorf("overspending block should not be connected, height %d", chain.Height())
+			}
+		})
+	}
+
+	//同样的区块把透支的交易去掉就能接上，说明拼出来的区块本身没问题
+	if err := chain.ProcessBlock(craftBlock(t, chain, minerPublicKey, spend)); err != nil {
+		t.Fatalf("ProcessBlock failed err: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package test
+
+import (
+	"CcCoin-go-version/internal/encryption"
+	"encoding/hex"
+	"testing"
+)
+
+func TestScrypt(t *testing.T) {
+	testCases := []struct {
+		name     string
+		password string
+		salt     string
+		N, r, p  int
+		keyLen   int
+		expected string
+	}{
+		{
+			//RFC 7914 第12节的测试向量
+			name:     "RFC 7914 Empty",
+			N:        16,
+			r:        1,
+			p:        1,
+			keyLen:   64,
+			expected: "77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906",
+		},
+		{
+			name:     "RFC 7914 Password",
+			password: "password",
+			salt:     "NaCl",
+			N:        1024,
+			r:        8,
+			p:        16,
+			keyLen:   64,
+			expected: "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b3731622eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640",
+		},
+		{
+			//工作量证明用的参数
+			name:     "Proof Of Work Parameters",
+			password: "block header",
+			salt:     "block header",
+			N:        1024,
+			r:        1,
+			p:        1,
+			keyLen:   32,
+			expected: "afd0d7504fff8d5476b7f6f7027be78ab649e8e982d36056c6018f2200a68987",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := encryption.Scrypt([]byte(tc.password), []byte(tc.salt), tc.N, tc.r, tc.p, tc.keyLen)
+			if err != nil {
+				t.Fatalf("Scrypt failed err: %v", err)
+			}
+			if got := hex.EncodeToString(key); got != tc.expected {
+				t.Errorf("Scrypt got %s want %s", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestScrypt_InvalidParameters(t *testing.T) {
+	for _, N := range []int{0, 1, 1000} {
+		if _, err := encryption.Scrypt([]byte("password"), []byte("salt"), N, 1, 1, 32); err == nil {
+			t.Errorf("N=%d should be rejected", N)
+		}
+	}
+	if _, err := encryption.Scrypt([]byte("password"), []byte("salt"), 16, 0, 1, 32); err == nil {
+		t.Errorf("r=0 should be rejected")
+	}
+}
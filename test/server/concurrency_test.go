@@ -0,0 +1,97 @@
+package server
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"CcCoin-go-version/internal/encryption"
+	"CcCoin-go-version/internal/server"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// 用 go test -race 跑，多个goroutine同时发交易、挖矿和查询
+func TestBlockchainServer_ConcurrentRequests(t *testing.T) {
+	const senders = 8
+	const txsPerSender = 10
+	const miners = 4
+
+	senderPrivateKeys := make([]string, senders)
+	senderPublicKeys := make([]string, senders)
+	for i := range senderPrivateKeys {
+		senderPrivateKeys[i], senderPublicKeys[i] = encryption.GenerateKeyPair()
+	}
+	myChain := newFundedChain(t, blockchain.RegTestParams, senderPublicKeys...)
+	server := server.NewBlockchainServer(myChain)
+
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		senderPrivateKey, senderPublicKey := senderPrivateKeys[i], senderPublicKeys[i]
+		_, receiverPublicKey := encryption.GenerateKeyPair()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for nonce := 0; nonce < txsPerSender; nonce++ {
+				jsonData, _ := json.Marshal(map[string]interface{}{
+					"SenderPublicKey":   senderPublicKey,
+					"SenderPrivateKey":  senderPrivateKey,
+					"ReceiverPublicKey": receiverPublicKey,
+					"Amount":            1.0,
+					"Nonce":             nonce,
+				})
+				req, _ := http.NewRequest("POST", "/transction/", bytes.NewBuffer(jsonData))
+				rr := httptest.NewRecorder()
+				server.ServeHTTP(rr, req)
+				//和挖矿并发的时候，交易可能已经上链了，这时会被当成双花拒绝
+				if rr.Code != http.StatusCreated && rr.Code != http.StatusConflict {
+					t.Errorf("add transaction returned status %v", rr.Code)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < miners; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				jsonData, _ := json.Marshal(map[string]string{"MinerPublicKey": "minerPublicKey"})
+				req, _ := http.NewRequest("POST", "/mine/", bytes.NewBuffer(jsonData))
+				rr := httptest.NewRecorder()
+				server.ServeHTTP(rr, req)
+				if rr.Code != http.StatusCreated {
+					t.Errorf("mine returned status %v", rr.Code)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				myChain.Height()
+				myChain.GetBalance("minerPublicKey")
+				myChain.Mempool().Count()
+				myChain.IsValidChain()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if myChain.Height() != miners*5 {
+		t.Errorf("height got %d want %d", myChain.Height(), miners*5)
+	}
+	if err := myChain.MineTransctionFromPool("minerPublicKey"); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	if myChain.Mempool().Count() != 0 {
+		t.Errorf("all transactions should be mined, %d left", myChain.Mempool().Count())
+	}
+	if !myChain.IsValidChain() {
+		t.Errorf("chain should stay valid under concurrent requests")
+	}
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"CcCoin-go-version/internal/encryption"
+	"CcCoin-go-version/internal/server"
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseEvent SSE流里的一个事件，只带id没有data的事件用来推进Last-Event-ID
+type sseEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+type sseClient struct {
+	t      *testing.T
+	resp   *http.Response
+	events chan sseEvent
+}
+
+func newSSEClient(t *testing.T, url, lastEventID string) *sseClient {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s failed err: %v", url, err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET %s got status %d content type %q", url, resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	c := &sseClient{t: t, resp: resp, events: make(chan sseEvent, 64)}
+	go func() {
+		defer close(c.events)
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(nil, 1024*1024)
+		var event sseEvent
+		hasFields := false
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if hasFields {
+					c.events <- event
+				}
+				event, hasFields = sseEvent{}, false
+			case strings.HasPrefix(line, ":"):
+			case strings.HasPrefix(line, "id: "):
+				event.id, hasFields = strings.TrimPrefix(line, "id: "), true
+			case strings.HasPrefix(line, "event: "):
+				event.event, hasFields = strings.TrimPrefix(line, "event: "), true
+			case strings.HasPrefix(line, "data: "):
+				event.data, hasFields = strings.TrimPrefix(line, "data: "), true
+			}
+		}
+	}()
+	t.Cleanup(func() { resp.Body.Close() })
+	return c
+}
+
+func (c *sseClient) next() sseEvent {
+	c.t.Helper()
+	select {
+	case event, ok := <-c.events:
+		if !ok {
+			c.t.Fatalf("event stream closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("timed out waiting for event")
+	}
+	return sseEvent{}
+}
+
+// expect 读下一个事件，检查id和事件名，返回data
+func (c *sseClient) expect(id, event string) string {
+	c.t.Helper()
+	got := c.next()
+	if got.id != id || got.event != event {
+		c.t.Fatalf("got event id %q type %q want id %q type %q", got.id, got.event, id, event)
+	}
+	return got.data
+}
+
+func TestBlockchainServer_EventsBadRequest(t *testing.T) {
+	server := server.NewBlockchainServer(blockchain.NewBlockchain(1))
+
+	testCases := []struct {
+		name           string
+		method         string
+		url            string
+		expectedStatus int
+	}{
+		{name: "Wrong Method", method: http.MethodPost, url: "/events/", expectedStatus: http.StatusMethodNotAllowed},
+		{name: "Invalid Since", method: http.MethodGet, url: "/events/?since=abc", expectedStatus: http.StatusBadRequest},
+		{name: "Negative Since", method: http.MethodGet, url: "/events/?since=-1", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tc.method, tc.url, nil)
+			rr := httptest.NewRecorder()
+
+			server.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tc.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tc.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestBlockchainServer_Events(t *testing.T) {
+	alicePrivateKey, alicePublicKey := encryption.GenerateKeyPair()
+	bobPrivateKey, bobPublicKey := encryption.GenerateKeyPair()
+	params := blockchain.MainNetParams
+	params.Genesis.Difficulty = 1
+	myChain := newFundedChain(t, params, alicePublicKey, bobPublicKey)
+	ts := httptest.NewServer(server.NewBlockchainServer(myChain))
+	//Cleanup按注册的反序执行，先断开客户端的流，Close才不会一直等着推送事件的handler
+	t.Cleanup(ts.Close)
+	_, carolPublicKey := encryption.GenerateKeyPair()
+	_, minerPublicKey := encryption.GenerateKeyPair()
+
+	for i := 0; i < 2; i++ {
+		if err := myChain.MineTransctionFromPool(minerPublicKey); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+	}
+
+	//从高度0续上，只关心alice：补发的两个区块和alice无关，只推进id
+	alice := newSSEClient(t, ts.URL+"/events/?since=0&address="+alicePublicKey, "")
+	alice.expect("1", "")
+	alice.expect("2", "")
+	//不过滤的订阅，不补发历史区块
+	all := newSSEClient(t, ts.URL+"/events/", "")
+
+	aliceTx, _ := blockchain.NewTransactionWithNonce(alicePublicKey, alicePrivateKey, carolPublicKey, 10, 0.01, 0)
+	bobTx, _ := blockchain.NewTransactionWithNonce(bobPublicKey, bobPrivateKey, carolPublicKey, 10, 0.01, 0)
+	for _, tx := range []blockchain.Transaction{aliceTx, bobTx} {
+		if err := myChain.AddTransction2Pool(tx); err != nil {
+			t.Fatalf("Failed to add transaction to pool: %v", err)
+		}
+	}
+	var tx blockchain.TransactionInfo
+	json.Unmarshal([]byte(alice.expect("", "tx")), &tx)
+	if tx.ID != aliceTx.ID() {
+		t.Errorf("alice got tx %s want %s", tx.ID, aliceTx.ID())
+	}
+	all.expect("", "tx")
+	all.expect("", "tx")
+
+	if err := myChain.MineTransctionFromPool(minerPublicKey); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	//bob的交易被过滤掉了，alice的下一个事件就是区块
+	var block blockchain.BlockInfo
+	json.Unmarshal([]byte(alice.expect("3", "block")), &block)
+	tip, _ := myChain.GetBlock(3)
+	if block.Height != 3 || block.Hash != tip.Hash() || len(block.Transactions) != 3 {
+		t.Errorf("got block %d %s with %d txs want block 3 %s with 3 txs", block.Height, block.Hash, len(block.Transactions), tip.Hash())
+	}
+	all.expect("3", "block")
+
+	//回滚：id退回到断开之后的高度，区块里的交易回到交易池
+	if _, err := myChain.DisconnectTip(); err != nil {
+		t.Fatalf("DisconnectTip failed err: %v", err)
+	}
+	json.Unmarshal([]byte(alice.expect("2", "reorg")), &block)
+	if block.Height != 3 || block.Hash != tip.Hash() {
+		t.Errorf("reorg got block %d %s want block 3 %s", block.Height, block.Hash, tip.Hash())
+	}
+	alice.expect("", "tx")
+	all.expect("2", "reorg")
+
+	//重新挖出高度3的区块，之前回滚掉的高度不会被当成重复的区块
+	if err := myChain.MineTransctionFromPool(minerPublicKey); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	alice.expect("3", "block")
+
+	//带着Last-Event-ID重连，只补发之后的区块
+	resumed := newSSEClient(t, ts.URL+"/events/?since=0", "2")
+	json.Unmarshal([]byte(resumed.expect("3", "block")), &block)
+	if tip, _ = myChain.GetBlock(3); block.Hash != tip.Hash() {
+		t.Errorf("resumed block hash got %s want %s", block.Hash, tip.Hash())
+	}
+}
+
+func TestBlockchainServer_EventsPruned(t *testing.T) {
+	myChain := blockchain.NewBlockchain(1)
+	ts := httptest.NewServer(server.NewBlockchainServer(myChain))
+	t.Cleanup(ts.Close)
+	_, alicePublicKey := encryption.GenerateKeyPair()
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	for i := 0; i < 2; i++ {
+		if err := myChain.MineTransctionFromPool(minerPublicKey); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+	}
+	if err := myChain.SetPruneDepth(1); err != nil {
+		t.Fatalf("SetPruneDepth failed err: %v", err)
+	}
+
+	//交易被裁剪掉的区块看不出和alice有没有关系，照样补发，带上pruned
+	alice := newSSEClient(t, ts.URL+"/events/?since=0&address="+alicePublicKey, "")
+	var block blockchain.BlockInfo
+	json.Unmarshal([]byte(alice.expect("1", "block")), &block)
+	if !block.Pruned || len(block.Transactions) != 0 {
+		t.Errorf("replayed block 1 got pruned %v with %d txs want pruned", block.Pruned, len(block.Transactions))
+	}
+	alice.expect("2", "")
+}
@@ -5,17 +5,38 @@ import (
 	"CcCoin-go-version/internal/encryption"
 	"CcCoin-go-version/internal/server"
 	"bytes"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
+// newFundedChain 按params创建区块链，创世区块里给addresses各预挖一笔钱；改过的创世区块和主网的checkpoint对不上，去掉
+func newFundedChain(t *testing.T, params blockchain.ChainParams, addresses ...string) *blockchain.Blockchain {
+	t.Helper()
+	params.Genesis.Allocations = map[string]float64{}
+	for _, address := range addresses {
+		params.Genesis.Allocations[address] = 1000
+	}
+	params.Checkpoints = nil
+	chain, err := blockchain.NewBlockchainWithParams(params)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithParams failed err: %v", err)
+	}
+	return chain
+}
+
 func TestBlockchainServer_AddTransaction(t *testing.T) {
-	mockBlockchain := blockchain.NewBlockchain(3)
-	server := server.NewBlockchainServer(mockBlockchain)
 	senderPrivateKey, senderPublicKey := encryption.GenerateKeyPair()
 	_, receiverPublicKey := encryption.GenerateKeyPair()
+	_, unfundedPublicKey := encryption.GenerateKeyPair()
+	params := blockchain.MainNetParams
+	params.Genesis.Difficulty = 3
+	mockBlockchain := newFundedChain(t, params, senderPublicKey)
+	server := server.NewBlockchainServer(mockBlockchain)
 
 	testCases := []struct {
 		name           string
@@ -32,6 +53,16 @@ func TestBlockchainServer_AddTransaction(t *testing.T) {
 			},
 			expectedStatus: http.StatusCreated,
 		},
+		{
+			name: "Insufficient Balance",
+			txData: map[string]interface{}{
+				"SenderPublicKey":   unfundedPublicKey,
+				"SenderPrivateKey":  senderPrivateKey,
+				"ReceiverPublicKey": receiverPublicKey,
+				"Amount":            100.0,
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
 		{
 			name: "Invalid Transaction Data",
 			txData: map[string]interface{}{
@@ -163,3 +194,588 @@ func TestBlockchainServer_MineHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestBlockchainServer_Generate(t *testing.T) {
+	regtestChain, _ := blockchain.NewBlockchainWithParams(blockchain.RegTestParams)
+	regtestServer := server.NewBlockchainServer(regtestChain)
+	mainnetServer := server.NewBlockchainServer(blockchain.NewBlockchain(3))
+
+	testCases := []struct {
+		name           string
+		server         *server.BlockchainServer
+		generateData   map[string]interface{}
+		expectedStatus int
+	}{
+		{
+			name:           "Generate On Regtest",
+			server:         regtestServer,
+			generateData:   map[string]interface{}{"Address": "minerPublicKey", "Blocks": 5},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Missing Address",
+			server:         regtestServer,
+			generateData:   map[string]interface{}{"Blocks": 5},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Generate On Mainnet",
+			server:         mainnetServer,
+			generateData:   map[string]interface{}{"Address": "minerPublicKey", "Blocks": 5},
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			jsonData, _ := json.Marshal(tc.generateData)
+			req, _ := http.NewRequest("POST", "/generate/", bytes.NewBuffer(jsonData))
+			rr := httptest.NewRecorder()
+
+			tc.server.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tc.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tc.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestBlockchainServer_MiningStats(t *testing.T) {
+	mockBlockchain := blockchain.NewBlockchain(2)
+	server := server.NewBlockchainServer(mockBlockchain)
+	if err := mockBlockchain.MineTransctionFromPool("minerPublicKey"); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+
+	req, _ := http.NewRequest("GET", "/mine/", nil)
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var stats blockchain.MiningStats
+	if err := json.NewDecoder(rr.Body).Decode(&stats); err != nil {
+		t.Fatalf("decode mining stats failed err: %v", err)
+	}
+	if stats.BlocksMined != 1 || stats.TotalAttempts == 0 {
+		t.Errorf("unexpected mining stats %+v", stats)
+	}
+}
+
+func TestBlockchainServer_BackgroundMiner(t *testing.T) {
+	regtestChain, _ := blockchain.NewBlockchainWithParams(blockchain.RegTestParams)
+	server := server.NewBlockchainServer(regtestChain)
+
+	testCases := []struct {
+		name           string
+		method         string
+		path           string
+		body           map[string]string
+		expectedStatus int
+	}{
+		{
+			name:           "Start Without Address",
+			method:         "POST",
+			path:           "/miner/start/",
+			body:           map[string]string{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Start",
+			method:         "POST",
+			path:           "/miner/start/",
+			body:           map[string]string{"RewardAddress": "minerPublicKey"},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Start Twice",
+			method:         "POST",
+			path:           "/miner/start/",
+			body:           map[string]string{"RewardAddress": "minerPublicKey"},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Status",
+			method:         "GET",
+			path:           "/miner/status/",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Stop",
+			method:         "POST",
+			path:           "/miner/stop/",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Stop Twice",
+			method:         "POST",
+			path:           "/miner/stop/",
+			expectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			jsonData, _ := json.Marshal(tc.body)
+			req, _ := http.NewRequest(tc.method, tc.path, bytes.NewBuffer(jsonData))
+			rr := httptest.NewRecorder()
+
+			server.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tc.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tc.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestBlockchainServer_Signers(t *testing.T) {
+	signerPrivateKey, signerPublicKey := encryption.GenerateKeyPair()
+	_, newcomer := encryption.GenerateKeyPair()
+	params := blockchain.RegTestParams
+	params.Consensus = blockchain.ConsensusProofOfAuthority
+	params.Genesis.Hash = ""
+	params.Genesis.Signers = []string{signerPublicKey}
+	engine := blockchain.NewProofOfAuthority()
+	if err := engine.Authorize(signerPublicKey, signerPrivateKey); err != nil {
+		t.Fatalf("Authorize failed err: %v", err)
+	}
+	poaChain, err := blockchain.NewBlockchainWithEngine(params, engine)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithEngine failed err: %v", err)
+	}
+	poaServer := server.NewBlockchainServer(poaChain)
+	powServer := server.NewBlockchainServer(blockchain.NewBlockchain(3))
+
+	testCases := []struct {
+		name           string
+		server         *server.BlockchainServer
+		method         string
+		path           string
+		body           map[string]interface{}
+		expectedStatus int
+	}{
+		{
+			name:           "List Signers",
+			server:         poaServer,
+			method:         "GET",
+			path:           "/signers/",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Propose Signer",
+			server:         poaServer,
+			method:         "POST",
+			path:           "/signers/propose/",
+			body:           map[string]interface{}{"Address": newcomer, "Authorize": true},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Propose Empty Address",
+			server:         poaServer,
+			method:         "POST",
+			path:           "/signers/propose/",
+			body:           map[string]interface{}{"Authorize": true},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Discard Proposal",
+			server:         poaServer,
+			method:         "POST",
+			path:           "/signers/discard/",
+			body:           map[string]interface{}{"Address": newcomer},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Signers On Proof Of Work",
+			server:         powServer,
+			method:         "GET",
+			path:           "/signers/",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			jsonData, _ := json.Marshal(tc.body)
+			req, _ := http.NewRequest(tc.method, tc.path, bytes.NewBuffer(jsonData))
+			rr := httptest.NewRecorder()
+
+			tc.server.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tc.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tc.expectedStatus)
+			}
+		})
+	}
+
+	//出块的时候签名者会投票，只有一个签名者的话一票就生效
+	if err := engine.Propose(newcomer, true); err != nil {
+		t.Fatalf("Propose failed err: %v", err)
+	}
+	if err := poaChain.MineTransctionFromPool("miner"); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	req, _ := http.NewRequest("GET", "/signers/", nil)
+	rr := httptest.NewRecorder()
+	poaServer.ServeHTTP(rr, req)
+	var response struct {
+		Signers []string `json:"signers"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("decode signers failed err: %v", err)
+	}
+	if len(response.Signers) != 2 {
+		t.Errorf("signers got %v want 2 signers", response.Signers)
+	}
+}
+
+func TestBlockchainServer_Validators(t *testing.T) {
+	_, validator := encryption.GenerateKeyPair()
+	params := blockchain.RegTestParams
+	params.Consensus = blockchain.ConsensusProofOfStake
+	params.Genesis.Hash = ""
+	params.Genesis.Allocations = map[string]float64{validator: 100}
+	params.Genesis.Stakes = map[string]float64{validator: 60}
+	posChain, err := blockchain.NewBlockchainWithParams(params)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithParams failed err: %v", err)
+	}
+	server := server.NewBlockchainServer(posChain)
+
+	req, _ := http.NewRequest("GET", "/validators/", nil)
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var response struct {
+		Validators map[string]float64 `json:"validators"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("decode validators failed err: %v", err)
+	}
+	if response.Validators[validator] != 60 {
+		t.Errorf("validators got %v want %s staking 60", response.Validators, validator)
+	}
+}
+
+func TestBlockchainServer_BlockTemplate(t *testing.T) {
+	regtestChain, _ := blockchain.NewBlockchainWithParams(blockchain.RegTestParams)
+	server := server.NewBlockchainServer(regtestChain)
+
+	testCases := []struct {
+		name           string
+		method         string
+		path           string
+		body           map[string]interface{}
+		expectedStatus int
+	}{
+		{
+			name:           "Get Block Template",
+			method:         "POST",
+			path:           "/getblocktemplate/",
+			body:           map[string]interface{}{"RewardAddress": "minerPublicKey"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Missing Reward Address",
+			method:         "POST",
+			path:           "/getblocktemplate/",
+			body:           map[string]interface{}{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Submit Unknown Template",
+			method:         "POST",
+			path:           "/submitblock/",
+			body:           map[string]interface{}{"TemplateID": "unknown", "Nonce": 1},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Submit Without Nonce",
+			method:         "POST",
+			path:           "/submitblock/",
+			body:           map[string]interface{}{"TemplateID": "unknown"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Wrong Method",
+			method:         "GET",
+			path:           "/submitblock/",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			jsonData, _ := json.Marshal(tc.body)
+			req, _ := http.NewRequest(tc.method, tc.path, bytes.NewBuffer(jsonData))
+			rr := httptest.NewRecorder()
+
+			server.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tc.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tc.expectedStatus)
+			}
+		})
+	}
+
+	//像外部矿工一样领取模板、找nonce、提交
+	jsonData, _ := json.Marshal(map[string]string{"RewardAddress": "minerPublicKey"})
+	req, _ := http.NewRequest("POST", "/getblocktemplate/", bytes.NewBuffer(jsonData))
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	var template blockchain.BlockTemplate
+	if err := json.NewDecoder(rr.Body).Decode(&template); err != nil {
+		t.Fatalf("decode block template failed err: %v", err)
+	}
+	header, _ := hex.DecodeString(template.Header)
+	nonce := uint32(0)
+	for ; ; nonce++ {
+		binary.BigEndian.PutUint32(header[blockchain.HeaderNonceOffset:], nonce)
+		hash, _ := blockchain.PowHash(template.Algorithm, header)
+		if strings.HasPrefix(hash, template.Target) {
+			break
+		}
+	}
+	jsonData, _ = json.Marshal(map[string]interface{}{"TemplateID": template.TemplateID, "Nonce": nonce})
+	req, _ = http.NewRequest("POST", "/submitblock/", bytes.NewBuffer(jsonData))
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("submitblock returned wrong status code: got %v want %v, body %s", status, http.StatusCreated, rr.Body.String())
+	}
+	if regtestChain.Height() != 1 {
+		t.Errorf("submitted block should be connected, height %d", regtestChain.Height())
+	}
+
+	//同一个模板再提交一次，链的末端已经变了
+	req, _ = http.NewRequest("POST", "/submitblock/", bytes.NewBuffer(jsonData))
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("resubmit returned wrong status code: got %v want %v", status, http.StatusConflict)
+	}
+}
+
+func TestBlockchainServer_Validate(t *testing.T) {
+	mockBlockchain := blockchain.NewBlockchain(1)
+	server := server.NewBlockchainServer(mockBlockchain)
+	_, senderPublicKey := encryption.GenerateKeyPair()
+	otherPrivateKey, _ := encryption.GenerateKeyPair()
+	_, receiverPublicKey := encryption.GenerateKeyPair()
+
+	//私钥和发送者对不上，错误信息里说明是签名的问题
+	jsonData, _ := json.Marshal(map[string]interface{}{
+		"SenderPublicKey":   senderPublicKey,
+		"SenderPrivateKey":  otherPrivateKey,
+		"ReceiverPublicKey": receiverPublicKey,
+		"Amount":            10.0,
+	})
+	req, _ := http.NewRequest("POST", "/transction/", bytes.NewBuffer(jsonData))
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), blockchain.ErrInvalidTxSignature.Error()) {
+		t.Errorf("forged transaction got status %d body %q", rr.Code, rr.Body.String())
+	}
+
+	if err := mockBlockchain.MineTransctionFromPool(receiverPublicKey); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	block, _ := mockBlockchain.GetBlock(1)
+
+	testCases := []struct {
+		name        string
+		checkpoints []blockchain.Checkpoint
+		valid       bool
+		blockIndex  int //-1表示结果里不带区块高度
+	}{
+		{name: "Valid Chain", valid: true, blockIndex: -1},
+		{name: "Checkpoint Mismatch", checkpoints: []blockchain.Checkpoint{{Height: 1, Hash: "not-" + block.Hash()}}, valid: false, blockIndex: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockBlockchain.SetCheckpoints(tc.checkpoints)
+			req, _ := http.NewRequest("GET", "/validate/", nil)
+			rr := httptest.NewRecorder()
+
+			server.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+			}
+			var result struct {
+				Valid      bool   `json:"valid"`
+				Error      string `json:"error"`
+				BlockIndex *int   `json:"blockIndex"`
+			}
+			json.NewDecoder(rr.Body).Decode(&result)
+			if result.Valid != tc.valid {
+				t.Errorf("valid got %v want %v, error %q", result.Valid, tc.valid, result.Error)
+			}
+			blockIndex := -1
+			if result.BlockIndex != nil {
+				blockIndex = *result.BlockIndex
+			}
+			if blockIndex != tc.blockIndex {
+				t.Errorf("blockIndex got %v want %v", blockIndex, tc.blockIndex)
+			}
+		})
+	}
+}
+
+func TestBlockchainServer_Block(t *testing.T) {
+	source := blockchain.NewBlockchain(1)
+	mockBlockchain := blockchain.NewBlockchain(1)
+	server := server.NewBlockchainServer(mockBlockchain)
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	if err := source.MineTransctionFromPool(minerPublicKey); err != nil {
+		t.Fatalf("MineTransctionFromPool failed err: %v", err)
+	}
+	block, _ := source.GetBlock(1)
+	tampered := block.Info(1)
+	tampered.Transactions[len(tampered.Transactions)-1].Amount++
+
+	testCases := []struct {
+		name           string
+		body           interface{}
+		expectedStatus int
+	}{
+		{name: "Invalid Block Data", body: map[string]interface{}{"InvalidField": "invalidValue"}, expectedStatus: http.StatusBadRequest},
+		{name: "Tampered Block", body: tampered, expectedStatus: http.StatusBadRequest},
+		{name: "Valid Block", body: block.Info(1), expectedStatus: http.StatusCreated},
+		{name: "Known Block", body: block.Info(1), expectedStatus: http.StatusConflict},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			jsonData, _ := json.Marshal(tc.body)
+			req, _ := http.NewRequest("POST", "/block/", bytes.NewBuffer(jsonData))
+			rr := httptest.NewRecorder()
+
+			server.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tc.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v, body %q", status, tc.expectedStatus, rr.Body.String())
+			}
+		})
+	}
+	if tip, _ := mockBlockchain.GetBlock(1); mockBlockchain.Height() != 1 || tip.Hash() != block.Hash() {
+		t.Errorf("posted block should be connected, height %d", mockBlockchain.Height())
+	}
+}
+
+func TestBlockchainServer_PrunedBlock(t *testing.T) {
+	mockBlockchain := blockchain.NewBlockchain(1)
+	server := server.NewBlockchainServer(mockBlockchain)
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	for i := 0; i < 3; i++ {
+		if err := mockBlockchain.MineTransctionFromPool(minerPublicKey); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+	}
+	if err := mockBlockchain.SetPruneDepth(1); err != nil {
+		t.Fatalf("SetPruneDepth failed err: %v", err)
+	}
+
+	testCases := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		pruned         bool
+	}{
+		{name: "Invalid Height", query: "height=abc", expectedStatus: http.StatusBadRequest},
+		{name: "Unknown Height", query: "height=4", expectedStatus: http.StatusNotFound},
+		{name: "Pruned Block", query: "height=2", expectedStatus: http.StatusOK, pruned: true},
+		{name: "Full Block", query: "height=3", expectedStatus: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/block/?"+tc.query, nil)
+			rr := httptest.NewRecorder()
+
+			server.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tc.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v, body %q", status, tc.expectedStatus, rr.Body.String())
+			}
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+			var info blockchain.BlockInfo
+			json.NewDecoder(rr.Body).Decode(&info)
+			if info.Pruned != tc.pruned || (len(info.Transactions) == 0) != tc.pruned {
+				t.Errorf("pruned got %v with %d transactions want %v", info.Pruned, len(info.Transactions), tc.pruned)
+			}
+		})
+	}
+
+	req, _ := http.NewRequest("GET", "/validate/", nil)
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	var result struct {
+		Valid        bool `json:"valid"`
+		PrunedHeight int  `json:"prunedHeight"`
+	}
+	json.NewDecoder(rr.Body).Decode(&result)
+	if !result.Valid || result.PrunedHeight != 2 {
+		t.Errorf("validate got %+v want valid with prunedHeight 2", result)
+	}
+}
+
+func TestBlockchainServer_Snapshot(t *testing.T) {
+	mockBlockchain, err := blockchain.NewBlockchainWithParams(blockchain.RegTestParams)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithParams failed err: %v", err)
+	}
+	server := server.NewBlockchainServer(mockBlockchain)
+	_, minerPublicKey := encryption.GenerateKeyPair()
+	for i := 0; i < 12; i++ {
+		if err := mockBlockchain.MineTransctionFromPool(minerPublicKey); err != nil {
+			t.Fatalf("MineTransctionFromPool failed err: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		name           string
+		query          string
+		pruneDepth     int
+		expectedStatus int
+	}{
+		{name: "Invalid Height", query: "?height=abc", expectedStatus: http.StatusBadRequest},
+		{name: "No Commitment", query: "?height=5", expectedStatus: http.StatusNotFound},
+		{name: "Latest", query: "", expectedStatus: http.StatusOK},
+		{name: "Height", query: "?height=10", expectedStatus: http.StatusOK},
+		{name: "Pruned", query: "?height=10", pruneDepth: 1, expectedStatus: http.StatusGone},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := mockBlockchain.SetPruneDepth(tc.pruneDepth); err != nil {
+				t.Fatalf("SetPruneDepth failed err: %v", err)
+			}
+			req, _ := http.NewRequest("GET", "/snapshot/"+tc.query, nil)
+			rr := httptest.NewRecorder()
+
+			server.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tc.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v, body %q", status, tc.expectedStatus, rr.Body.String())
+			}
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+			var snapshot blockchain.StateSnapshot
+			json.NewDecoder(rr.Body).Decode(&snapshot)
+			block, _ := mockBlockchain.GetBlock(10)
+			if snapshot.Height != 10 || snapshot.Hash != block.Hash() || len(snapshot.Headers) != 10 || snapshot.Balances[minerPublicKey] == 0 {
+				t.Errorf("got snapshot at height %d hash %s with %d headers", snapshot.Height, snapshot.Hash, len(snapshot.Headers))
+			}
+		})
+	}
+}
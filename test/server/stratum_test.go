@@ -0,0 +1,373 @@
+package server
+
+import (
+	"CcCoin-go-version/internal/blockchain"
+	"CcCoin-go-version/internal/server"
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stratumClient 测试用的矿工，notifications里是收到的mining.notify任务
+type stratumClient struct {
+	t             *testing.T
+	conn          net.Conn
+	reader        *bufio.Reader
+	nextID        int
+	notifications []server.StratumJob
+}
+
+type stratumMessage struct {
+	ID     *int                 `json:"id"`
+	Method string               `json:"method"`
+	Result json.RawMessage      `json:"result"`
+	Error  *server.StratumError `json:"error"`
+	Params []server.StratumJob  `json:"params"`
+}
+
+func newStratumClient(t *testing.T, addr string) *stratumClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial stratum server failed err: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &stratumClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *stratumClient) read() stratumMessage {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		c.t.Fatalf("read stratum message failed err: %v", err)
+	}
+	var message stratumMessage
+	if err := json.Unmarshal(line, &message); err != nil {
+		c.t.Fatalf("decode stratum message %s failed err: %v", line, err)
+	}
+	return message
+}
+
+// call 发送请求并等到对应的响应，中间收到的任务记到notifications里
+func (c *stratumClient) call(method string, params ...interface{}) (json.RawMessage, *server.StratumError) {
+	c.t.Helper()
+	c.nextID++
+	data, _ := json.Marshal(map[string]interface{}{"id": c.nextID, "method": method, "params": params})
+	if _, err := c.conn.Write(append(data, '\n')); err != nil {
+		c.t.Fatalf("write stratum request failed err: %v", err)
+	}
+	for {
+		message := c.read()
+		if message.Method == "mining.notify" {
+			c.notifications = append(c.notifications, message.Params...)
+			continue
+		}
+		if message.ID == nil || *message.ID != c.nextID {
+			c.t.Fatalf("unexpected stratum message %+v", message)
+		}
+		return message.Result, message.Error
+	}
+}
+
+// job 等到一个满足条件的任务
+func (c *stratumClient) job(match func(server.StratumJob) bool) server.StratumJob {
+	c.t.Helper()
+	for {
+		for _, job := range c.notifications {
+			if match(job) {
+				return job
+			}
+		}
+		message := c.read()
+		if message.Method != "mining.notify" {
+			c.t.Fatalf("unexpected stratum message %+v", message)
+		}
+		c.notifications = append(c.notifications, message.Params...)
+	}
+}
+
+// solveJob 找一个工作量hash以hit开头、但不以miss开头的nonce，miss为空表示不限制
+func solveJob(t *testing.T, job server.StratumJob, hit, miss string) uint32 {
+	t.Helper()
+	header, _ := hex.DecodeString(job.Header)
+	for nonce := uint32(0); ; nonce++ {
+		binary.BigEndian.PutUint32(header[blockchain.HeaderNonceOffset:], nonce)
+		hash, err := blockchain.PowHash(job.Algorithm, header)
+		if err != nil {
+			t.Fatalf("PowHash failed err: %v", err)
+		}
+		if strings.HasPrefix(hash, hit) && (miss == "" || !strings.HasPrefix(hash, miss)) {
+			return nonce
+		}
+	}
+}
+
+// startStratumServer 在本地随便一个端口上启动矿池服务，份额难度是1，测试结束时关掉
+func startStratumServer(t *testing.T, chain *blockchain.Blockchain) (*server.StratumServer, string) {
+	t.Helper()
+	stratum, err := server.NewStratumServer(chain, "poolAddress")
+	if err != nil {
+		t.Fatalf("NewStratumServer failed err: %v", err)
+	}
+	stratum.SetShareDifficulty(1)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed err: %v", err)
+	}
+	go stratum.Serve(listener)
+	t.Cleanup(func() { stratum.Close() })
+	return stratum, listener.Addr().String()
+}
+
+func TestStratumServer_Mining(t *testing.T) {
+	params := blockchain.RegTestParams
+	params.Genesis.Difficulty = 2
+	chain, err := blockchain.NewBlockchainWithParams(params)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithParams failed err: %v", err)
+	}
+	if _, err := server.NewStratumServer(chain, ""); err == nil {
+		t.Fatalf("stratum server without pool address should fail")
+	}
+	stratum, addr := startStratumServer(t, chain)
+
+	client := newStratumClient(t, addr)
+	if _, stratumErr := client.call("mining.submit", "worker1", "job", 1); stratumErr == nil || stratumErr.Code != server.StratumErrNotSubscribed {
+		t.Fatalf("submit before subscribe should fail with not subscribed, got %v", stratumErr)
+	}
+	if _, stratumErr := client.call("mining.subscribe"); stratumErr != nil {
+		t.Fatalf("subscribe failed err: %v", stratumErr)
+	}
+	job := client.job(func(job server.StratumJob) bool { return job.Height == 1 })
+	if job.Target != "0" || job.BlockTarget != "00" || !job.CleanJobs {
+		t.Fatalf("unexpected job %+v", job)
+	}
+
+	if _, stratumErr := client.call("mining.submit", "worker1", job.JobID, 1); stratumErr == nil || stratumErr.Code != server.StratumErrUnauthorized {
+		t.Fatalf("submit before authorize should fail with unauthorized, got %v", stratumErr)
+	}
+	if _, stratumErr := client.call("mining.authorize", "worker1"); stratumErr != nil {
+		t.Fatalf("authorize failed err: %v", stratumErr)
+	}
+
+	//不满足份额难度
+	nonce := solveJob(t, job, "", job.Target)
+	if _, stratumErr := client.call("mining.submit", "worker1", job.JobID, nonce); stratumErr == nil || stratumErr.Code != server.StratumErrLowDifficulty {
+		t.Fatalf("low difficulty share should be rejected, got %v", stratumErr)
+	}
+	//满足份额难度但不够出块
+	nonce = solveJob(t, job, job.Target, job.BlockTarget)
+	if _, stratumErr := client.call("mining.submit", "worker1", job.JobID, nonce); stratumErr != nil {
+		t.Fatalf("share should be accepted err: %v", stratumErr)
+	}
+	if _, stratumErr := client.call("mining.submit", "worker1", job.JobID, nonce); stratumErr == nil || stratumErr.Code != server.StratumErrDuplicateShare {
+		t.Fatalf("duplicate share should be rejected, got %v", stratumErr)
+	}
+	if chain.Height() != 0 {
+		t.Fatalf("share below block difficulty should not connect a block")
+	}
+
+	//getblocktemplate接口发再多模板也挤不掉矿池的任务
+	for i := 0; i < 100; i++ {
+		if _, err := chain.NewBlockTemplate(fmt.Sprintf("httpMiner%d", i)); err != nil {
+			t.Fatalf("NewBlockTemplate failed err: %v", err)
+		}
+	}
+
+	//挖到区块
+	nonce = solveJob(t, job, job.BlockTarget, "")
+	if _, stratumErr := client.call("mining.submit", "worker1", job.JobID, nonce); stratumErr != nil {
+		t.Fatalf("block share should be accepted err: %v", stratumErr)
+	}
+	if chain.Height() != 1 {
+		t.Fatalf("block should be connected, height %d", chain.Height())
+	}
+	if balance := chain.GetBalance("poolAddress"); balance != params.Genesis.MinerReward {
+		t.Errorf("pool address should get the miner reward, got %v", balance)
+	}
+
+	//链的末端变了，推送新任务，旧任务作废
+	next := client.job(func(next server.StratumJob) bool { return next.Height == 2 })
+	tip, _ := chain.GetBlock(chain.Height())
+	if !next.CleanJobs || next.PrevHash != tip.Hash() {
+		t.Errorf("new job should build on the new tip %+v", next)
+	}
+	if _, stratumErr := client.call("mining.submit", "worker1", job.JobID, nonce+1); stratumErr == nil || stratumErr.Code != server.StratumErrJobNotFound {
+		t.Errorf("stale job should be rejected, got %v", stratumErr)
+	}
+
+	stats := stratum.Workers()["worker1"]
+	if stats.AcceptedShares != 2 || stats.Blocks != 1 || stats.RejectedShares != 3 {
+		t.Errorf("unexpected worker stats %+v", stats)
+	}
+}
+
+func TestStratumServer_SessionExtraNonce(t *testing.T) {
+	params := blockchain.RegTestParams
+	params.Genesis.Difficulty = 3
+	chain, err := blockchain.NewBlockchainWithParams(params)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithParams failed err: %v", err)
+	}
+	_, addr := startStratumServer(t, chain)
+
+	//两个连接拿到的是同一个任务，但区块头里的extra nonce不一样
+	clients := []*stratumClient{newStratumClient(t, addr), newStratumClient(t, addr)}
+	extraNonces := map[uint64]bool{}
+	jobs := make([]server.StratumJob, len(clients))
+	for i, client := range clients {
+		result, stratumErr := client.call("mining.subscribe")
+		if stratumErr != nil {
+			t.Fatalf("subscribe failed err: %v", stratumErr)
+		}
+		var subscription struct {
+			ExtraNonce uint64 `json:"extraNonce"`
+		}
+		if err := json.Unmarshal(result, &subscription); err != nil || subscription.ExtraNonce == 0 {
+			t.Fatalf("subscribe result %s should carry an extra nonce", result)
+		}
+		extraNonces[subscription.ExtraNonce] = true
+		if _, stratumErr := client.call("mining.authorize", "worker"); stratumErr != nil {
+			t.Fatalf("authorize failed err: %v", stratumErr)
+		}
+		jobs[i] = client.job(func(job server.StratumJob) bool { return job.Height == 1 })
+	}
+	if len(extraNonces) != 2 {
+		t.Fatalf("every session should get its own extra nonce, got %v", extraNonces)
+	}
+	if jobs[0].JobID != jobs[1].JobID || jobs[0].Header == jobs[1].Header {
+		t.Fatalf("sessions should mine the same job with different headers, got %+v and %+v", jobs[0], jobs[1])
+	}
+
+	//份额按连接区分，另一个连接提交同一个nonce不算重复，但要按它自己的区块头来算
+	nonce := solveJob(t, jobs[0], jobs[0].Target, jobs[0].BlockTarget)
+	if _, stratumErr := clients[0].call("mining.submit", "worker", jobs[0].JobID, nonce); stratumErr != nil {
+		t.Fatalf("share should be accepted err: %v", stratumErr)
+	}
+	_, stratumErr := clients[1].call("mining.submit", "worker", jobs[1].JobID, nonce)
+	if stratumErr != nil && stratumErr.Code == server.StratumErrDuplicateShare {
+		t.Fatalf("same nonce from another session should not be a duplicate share")
+	}
+
+	//第二个连接挖到区块，区块的出块奖励交易带着它的extra nonce
+	nonce = solveJob(t, jobs[1], jobs[1].BlockTarget, "")
+	if _, stratumErr := clients[1].call("mining.submit", "worker", jobs[1].JobID, nonce); stratumErr != nil {
+		t.Fatalf("block share should be accepted err: %v", stratumErr)
+	}
+	block, _ := chain.GetBlock(1)
+	transactions := block.Transactions()
+	if coinbase := transactions[len(transactions)-1]; !extraNonces[coinbase.ExtraNonce()] {
+		t.Errorf("coinbase extra nonce %d should be one of the sessions' %v", coinbase.ExtraNonce(), extraNonces)
+	}
+}
+
+func TestStratumServer_WorkerName(t *testing.T) {
+	chain, err := blockchain.NewBlockchainWithParams(blockchain.RegTestParams)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithParams failed err: %v", err)
+	}
+	_, addr := startStratumServer(t, chain)
+	client := newStratumClient(t, addr)
+
+	testCases := []struct {
+		name   string
+		worker string
+		valid  bool
+	}{
+		{name: "Plain", worker: "rig-01.alice", valid: true},
+		{name: "Empty", worker: "", valid: false},
+		{name: "Space", worker: "rig 01", valid: false},
+		{name: "Control Character", worker: "rig\n01", valid: false},
+		{name: "Too Long", worker: strings.Repeat("a", 65), valid: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, stratumErr := client.call("mining.authorize", tc.worker)
+			if tc.valid != (stratumErr == nil) {
+				t.Errorf("authorize %q got err %v want valid %v", tc.worker, stratumErr, tc.valid)
+			}
+		})
+	}
+}
+
+func TestStratumServer_WorkerLimit(t *testing.T) {
+	chain, err := blockchain.NewBlockchainWithParams(blockchain.RegTestParams)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithParams failed err: %v", err)
+	}
+	stratum, addr := startStratumServer(t, chain)
+	client := newStratumClient(t, addr)
+
+	//一个连接最多登记16个矿工名，已经登记过的可以重复登记
+	for i := 0; i < 16; i++ {
+		if _, stratumErr := client.call("mining.authorize", fmt.Sprintf("worker%d", i)); stratumErr != nil {
+			t.Fatalf("authorize failed err: %v", stratumErr)
+		}
+	}
+	if _, stratumErr := client.call("mining.authorize", "worker16"); stratumErr == nil {
+		t.Errorf("authorize beyond the per connection limit should fail")
+	}
+	if _, stratumErr := client.call("mining.authorize", "worker0"); stratumErr != nil {
+		t.Errorf("authorize a registered worker failed err: %v", stratumErr)
+	}
+	if workers := stratum.Workers(); len(workers) != 16 {
+		t.Errorf("workers got %d want 16", len(workers))
+	}
+
+	//连接断开之后这些矿工名的统计也清掉了
+	client.conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(stratum.Workers()) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if workers := stratum.Workers(); len(workers) != 0 {
+		t.Errorf("workers of closed connections should be dropped, got %d", len(workers))
+	}
+}
+
+func TestStratumServer_JobShareLimit(t *testing.T) {
+	params := blockchain.RegTestParams
+	params.Genesis.Difficulty = 8
+	chain, err := blockchain.NewBlockchainWithParams(params)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithParams failed err: %v", err)
+	}
+	_, addr := startStratumServer(t, chain)
+	client := newStratumClient(t, addr)
+	if _, stratumErr := client.call("mining.subscribe"); stratumErr != nil {
+		t.Fatalf("subscribe failed err: %v", stratumErr)
+	}
+	if _, stratumErr := client.call("mining.authorize", "worker"); stratumErr != nil {
+		t.Fatalf("authorize failed err: %v", stratumErr)
+	}
+	job := client.job(func(job server.StratumJob) bool { return job.Height == 1 })
+
+	//一个任务下每个连接最多记1024个份额，之后的份额要等新任务
+	header, _ := hex.DecodeString(job.Header)
+	accepted := 0
+	for nonce := uint32(0); accepted <= 1024; nonce++ {
+		binary.BigEndian.PutUint32(header[blockchain.HeaderNonceOffset:], nonce)
+		hash, err := blockchain.PowHash(job.Algorithm, header)
+		if err != nil {
+			t.Fatalf("PowHash failed err: %v", err)
+		}
+		if !strings.HasPrefix(hash, job.Target) || strings.HasPrefix(hash, job.BlockTarget) {
+			continue
+		}
+		_, stratumErr := client.call("mining.submit", "worker", job.JobID, nonce)
+		if accepted < 1024 && stratumErr != nil {
+			t.Fatalf("share %d should be accepted err: %v", accepted, stratumErr)
+		}
+		if accepted == 1024 && (stratumErr == nil || stratumErr.Code != server.StratumErrOther) {
+			t.Fatalf("share beyond the per job limit should be rejected, got %v", stratumErr)
+		}
+		accepted++
+	}
+}